@@ -0,0 +1,89 @@
+package approx
+
+import (
+	"math"
+	"math/big"
+	"math/cmplx"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"fast-ksw/fckks"
+	"fast-ksw/ring"
+)
+
+func sigmoid(x float64) float64 {
+	return 1 / (1 + math.Exp(-x))
+}
+
+func TestApprox(t *testing.T) {
+
+	t.Run("Chebyshev/Exp", func(t *testing.T) {
+		pol := ChebyshevReal(math.Exp, -1, 1, 16)
+		require.Equal(t, fckks.Chebyshev, pol.Basis)
+		require.Equal(t, 16, pol.Degree())
+		require.Equal(t, -1.0, pol.A)
+		require.Equal(t, 1.0, pol.B)
+		require.Less(t, MaxErrorReal(math.Exp, pol, 1000), 1e-13)
+	})
+
+	t.Run("Chebyshev/Sigmoid", func(t *testing.T) {
+		prevErr := math.Inf(1)
+		for _, degree := range []int{7, 15, 31, 63} {
+			err := MaxErrorReal(sigmoid, ChebyshevReal(sigmoid, -8, 8, degree), 1000)
+			require.Less(t, err, prevErr)
+			prevErr = err
+		}
+		require.Less(t, prevErr, 1e-6)
+	})
+
+	t.Run("Chebyshev/Complex", func(t *testing.T) {
+		f := func(x complex128) complex128 { return cmplx.Exp(complex(0, 1) * x) }
+		pol := Chebyshev(f, -4, 4, 31)
+		require.Less(t, MaxError(f, pol, 1000), 1e-12)
+	})
+
+	t.Run("Chebyshev/InterpolatesPolynomials", func(t *testing.T) {
+		// x^3 = (3T_1(x) + T_3(x))/4
+		pol := ChebyshevReal(func(x float64) float64 { return x * x * x }, -1, 1, 3)
+		expected := []complex128{0, 0.75, 0, 0.25}
+		for i := range expected {
+			require.Less(t, cmplx.Abs(pol.Coeffs[i]-expected[i]), 1e-15)
+		}
+	})
+
+	t.Run("ChebyshevBig", func(t *testing.T) {
+		logPrecision := 256
+		f := func(x *big.Float) *ring.Complex {
+			y := new(big.Float).SetPrec(uint(logPrecision)).Mul(x, x)
+			y.Mul(y, x)
+			return ring.NewComplex(y, ring.NewFloat(0, logPrecision))
+		}
+		pol := ChebyshevBig(f, -1, 1, 3, logPrecision)
+		expected := []complex128{0, 0.75, 0, 0.25}
+		for i := range expected {
+			require.Less(t, cmplx.Abs(pol.Coeffs[i]-expected[i]), 1e-16)
+		}
+	})
+
+	t.Run("ChebyshevBig/MatchesChebyshev", func(t *testing.T) {
+		logPrecision := 128
+		f := func(x *big.Float) *ring.Complex {
+			xf, _ := x.Float64()
+			return ring.NewComplex(ring.NewFloat(sigmoid(xf), logPrecision), ring.NewFloat(0, logPrecision))
+		}
+		polBig := ChebyshevBig(f, -8, 8, 31, logPrecision)
+		pol := ChebyshevReal(sigmoid, -8, 8, 31)
+		for i := range pol.Coeffs {
+			require.Less(t, cmplx.Abs(pol.Coeffs[i]-polBig.Coeffs[i]), 1e-14)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		pol := ChebyshevReal(math.Exp, -1, 1, 4)
+		require.Panics(t, func() { MaxErrorReal(math.Exp, pol, 1) })
+		require.Panics(t, func() { ChebyshevBig(nil, -1, 1, 4, 52) })
+		require.Panics(t, func() { ChebyshevBig(nil, -1, 1, 4, MaxLogPrecision+1) })
+		require.Panics(t, func() { ChebyshevReal(math.Exp, 1, -1, 4) })
+	})
+}
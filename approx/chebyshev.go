@@ -0,0 +1,134 @@
+// Package approx computes polynomial approximations of functions, to be evaluated homomorphically
+// with fckks.PolynomialEvaluator.
+package approx
+
+import (
+	"math"
+	"math/big"
+	"math/cmplx"
+
+	"fast-ksw/fckks"
+	"fast-ksw/ring"
+)
+
+// pi is the constant Pi with 1000 decimal digits.
+const pi = "3.1415926535897932384626433832795028841971693993751058209749445923078164062862089986280348253421170679821480865132823066470938446095505822317253594081284811174502841027019385211055596446229489549303819644288109756659334461284756482337867831652712019091456485669234603486104543266482133936072602491412737245870066063155881748815209209628292540917153643678925903600113305305488204665213841469519415116094330572703657595919530921861173819326117931051185480744623799627495673518857527248912279381830119491298336733624406566430860213949463952247371907021798609437027705392171762931767523846748184676694051320005681271452635608277857713427577896091736371787214684409012249534301465495853710507922796892589235420199561121290219608640344181598136297747713099605187072113499999983729780499510597317328160963185950244594553469083026425223082533446850352619311881710100031378387528865875332083814206171776691473035982534904287554687311595628638823537875937519577818577805321712268066130019278766111959092164201989"
+
+// MaxLogPrecision is the maximum precision in bits of ChebyshevBig, bounded by the digits of pi.
+const MaxLogPrecision = 3000
+
+// Chebyshev returns the polynomial of the given degree interpolating f at the degree+1 Chebyshev
+// nodes of [a, b], in the Chebyshev basis on [a, b], computed with fckks.ChebyshevInterpolation.
+// It can be evaluated with fckks.PolynomialEvaluator.
+func Chebyshev(f func(complex128) complex128, a, b float64, degree int) *fckks.Polynomial {
+	return fckks.ChebyshevInterpolation(f, a, b, degree)
+}
+
+// ChebyshevReal returns the polynomial of the given degree interpolating the real function f at the
+// Chebyshev nodes of [a, b], as Chebyshev does.
+func ChebyshevReal(f func(float64) float64, a, b float64, degree int) *fckks.Polynomial {
+	return Chebyshev(func(x complex128) complex128 { return complex(f(real(x)), 0) }, a, b, degree)
+}
+
+// ChebyshevBig returns the polynomial of the given degree interpolating f at the Chebyshev nodes of
+// [a, b] as Chebyshev does, but computes the nodes and the coefficients with logPrecision bits of
+// precision, f being called on the nodes with this precision. The coefficients are rounded to
+// complex128 at the end only. This avoids the cancellations of the float64 computation for large
+// degrees, or for functions whose coefficients decrease fast.
+func ChebyshevBig(f func(*big.Float) *ring.Complex, a, b float64, degree, logPrecision int) *fckks.Polynomial {
+
+	if logPrecision < 53 || logPrecision > MaxLogPrecision {
+		panic("cannot ChebyshevBig: logPrecision must be between 53 and MaxLogPrecision")
+	}
+
+	n := degree + 1
+	prec := uint(logPrecision)
+
+	PI, _ := new(big.Float).SetPrec(prec).SetString(pi)
+
+	bigA, bigB := ring.NewFloat(a, logPrecision), ring.NewFloat(b, logPrecision)
+	center := new(big.Float).SetPrec(prec).Add(bigA, bigB)
+	center.Quo(center, ring.NewFloat(2, logPrecision))
+	radius := new(big.Float).SetPrec(prec).Sub(bigB, bigA)
+	radius.Quo(radius, ring.NewFloat(2, logPrecision))
+
+	// cos(theta_k) for the angles theta_k = Pi*(k+1/2)/n of the nodes
+	cosTheta := make([]*big.Float, n)
+	values := make([]*ring.Complex, n)
+	for k := 0; k < n; k++ {
+		theta := ring.NewFloat(float64(k)+0.5, logPrecision)
+		theta.Mul(theta, PI)
+		theta.Quo(theta, ring.NewFloat(float64(n), logPrecision))
+		cosTheta[k] = ring.Cos(theta)
+
+		x := new(big.Float).SetPrec(prec).Mul(radius, cosTheta[k])
+		x.Add(x, center)
+		values[k] = f(x)
+	}
+
+	sums := make([]*ring.Complex, n)
+	for j := range sums {
+		sums[j] = ring.NewComplex(ring.NewFloat(0, logPrecision), ring.NewFloat(0, logPrecision))
+	}
+
+	// T_j(cos(theta_k)) = cos(j*theta_k), with the recurrence T_{j+1} = 2x*T_j - T_{j-1} started
+	// from T_{-1} = T_1
+	tmp := ring.NewFloat(0, logPrecision)
+	for k := 0; k < n; k++ {
+
+		tPrev, t := new(big.Float).SetPrec(prec).Set(cosTheta[k]), ring.NewFloat(1, logPrecision)
+
+		for j := 0; j < n; j++ {
+
+			sums[j][0].Add(sums[j][0], tmp.Mul(values[k][0], t))
+			sums[j][1].Add(sums[j][1], tmp.Mul(values[k][1], t))
+
+			next := new(big.Float).SetPrec(prec).Mul(cosTheta[k], t)
+			next.Add(next, next)
+			next.Sub(next, tPrev)
+			tPrev, t = t, next
+		}
+	}
+
+	coeffs := make([]complex128, n)
+	scale := ring.NewFloat(2, logPrecision)
+	scale.Quo(scale, ring.NewFloat(float64(n), logPrecision))
+	for j := range coeffs {
+		sums[j][0].Mul(sums[j][0], scale)
+		sums[j][1].Mul(sums[j][1], scale)
+		coeffs[j] = sums[j].Float64()
+	}
+	coeffs[0] /= 2
+
+	return fckks.NewPolynomial(fckks.Chebyshev, coeffs, a, b)
+}
+
+// chebyshevNodes returns the n+1 Chebyshev nodes of [a, b], (a+b)/2 + (b-a)/2 * cos(Pi*(k+1/2)/(n+1)).
+func chebyshevNodes(a, b float64, n int) (nodes []float64) {
+	nodes = make([]float64, n+1)
+	for k := range nodes {
+		nodes[k] = (a+b)/2 + (b-a)/2*math.Cos(math.Pi*(float64(k)+0.5)/float64(n+1))
+	}
+	return
+}
+
+// MaxError returns the maximum of |f(x) - pol(x)| on nbPoints points evenly spaced on the interval
+// [pol.A, pol.B], including its bounds.
+func MaxError(f func(complex128) complex128, pol *fckks.Polynomial, nbPoints int) (maxErr float64) {
+
+	if nbPoints < 2 {
+		panic("cannot MaxError: nbPoints must be at least 2")
+	}
+
+	for i := 0; i < nbPoints; i++ {
+		x := complex(pol.A+(pol.B-pol.A)*float64(i)/float64(nbPoints-1), 0)
+		maxErr = math.Max(maxErr, cmplx.Abs(f(x)-pol.Evaluate(x)))
+	}
+
+	return
+}
+
+// MaxErrorReal returns the maximum error of pol on the real function f as MaxError does.
+func MaxErrorReal(f func(float64) float64, pol *fckks.Polynomial, nbPoints int) float64 {
+	return MaxError(func(x complex128) complex128 { return complex(f(real(x)), 0) }, pol, nbPoints)
+}
@@ -19,6 +19,7 @@ package ckks
 import (
 	"fast-ksw/ring"
 	"fast-ksw/rlwe"
+	"fast-ksw/utils"
 )
 
 // Encryptor an encryption interface for the CKKS scheme.
@@ -42,6 +43,12 @@ func NewEncryptor(params Parameters, key interface{}) Encryptor {
 	return &encryptor{rlwe.NewEncryptor(params.Parameters, key), params}
 }
 
+// NewEncryptorWithPRNG instantiates a new Encryptor for the CKKS scheme sampling all its randomness
+// from the provided PRNG. The key argument can be *rlwe.PublicKey, *rlwe.SecretKey or nil.
+func NewEncryptorWithPRNG(params Parameters, key interface{}, prng utils.PRNG) Encryptor {
+	return &encryptor{rlwe.NewEncryptorWithPRNG(params.Parameters, key, prng), params}
+}
+
 // Encrypt encrypts the input plaintext and write the result on ciphertext.
 // The level of the output ciphertext is min(plaintext.Level(), ciphertext.Level()).
 func (enc *encryptor) Encrypt(plaintext *Plaintext, ciphertext *Ciphertext) {
@@ -123,7 +123,7 @@ type Evaluator interface {
 	// ==============
 	// === Others ===
 	// ==============
-	GetKeySwitcher() *rlwe.KeySwitcher
+	GetKeySwitcher() rlwe.KeySwitchingBackend
 	PoolQMul() [3]*ring.Poly
 	CtxPool() *Ciphertext
 }
@@ -133,10 +133,9 @@ type Evaluator interface {
 type evaluator struct {
 	*evaluatorBase
 	*evaluatorBuffers
-	*rlwe.KeySwitcher
 
-	rlk             *rlwe.RelinearizationKey
-	rtks            *rlwe.RotationKeySet
+	ksw             rlwe.KeySwitchingBackend
+	evk             rlwe.EvaluationKeySet
 	permuteNTTIndex map[uint64][]uint64
 }
 
@@ -145,8 +144,9 @@ type evaluatorBase struct {
 }
 
 type evaluatorBuffers struct {
-	poolQMul [3]*ring.Poly // Memory pool in order : for MForm(c0), MForm(c1), c2
-	ctxpool  *Ciphertext   // Memory pool for ciphertext that need to be scaled up (to be removed eventually)
+	poolQMul [3]*ring.Poly  // Memory pool in order : for MForm(c0), MForm(c1), c2
+	poolQP   [3]rlwe.PolyQP // Memory pool for the outputs of the key-switching backend
+	ctxpool  *Ciphertext    // Memory pool for ciphertext that need to be scaled up (to be removed eventually)
 }
 
 // PoolQMul returns a pointer to internal memory pool poolQMul.
@@ -170,6 +170,10 @@ func newEvaluatorBuffers(evalBase *evaluatorBase) *evaluatorBuffers {
 	params := evalBase.params
 	ringQ := params.RingQ()
 	buff.poolQMul = [3]*ring.Poly{ringQ.NewPoly(), ringQ.NewPoly(), ringQ.NewPoly()}
+	if params.PCount() != 0 {
+		ringQP := params.RingQP()
+		buff.poolQP = [3]rlwe.PolyQP{ringQP.NewPoly(), ringQP.NewPoly(), ringQP.NewPoly()}
+	}
 	buff.ctxpool = NewCiphertext(params, 2, params.MaxLevel(), params.DefaultScale())
 	return buff
 }
@@ -178,37 +182,61 @@ func newEvaluatorBuffers(evalBase *evaluatorBase) *evaluatorBuffers {
 // operations on the Ciphertexts and/or Plaintexts. It stores a small pool of polynomials
 // and Ciphertexts that will be used for intermediate values.
 func NewEvaluator(params Parameters, evaluationKey rlwe.EvaluationKey) Evaluator {
+	var ksw rlwe.KeySwitchingBackend
+	if params.PCount() != 0 {
+		ksw = rlwe.NewKeySwitcher(params.Parameters)
+	}
+	return NewEvaluatorWithKeySwitcher(params, ksw, evaluationKey)
+}
+
+// NewEvaluatorWithKeySwitcher creates a new Evaluator whose key-switches are done by the backend ksw
+// with the keys of evk, which must be of the type expected by ksw.
+func NewEvaluatorWithKeySwitcher(params Parameters, ksw rlwe.KeySwitchingBackend, evk rlwe.EvaluationKeySet) Evaluator {
 	eval := new(evaluator)
 	eval.evaluatorBase = newEvaluatorBase(params)
 	eval.evaluatorBuffers = newEvaluatorBuffers(eval.evaluatorBase)
+	eval.ksw = ksw
+	eval.evk = evk
+	eval.permuteNTTIndex = eval.permuteNTTIndexesForKey(evk)
+	return eval
+}
 
-	eval.rlk = evaluationKey.Rlk
-	eval.rtks = evaluationKey.Rtks
-	if eval.rtks != nil {
-		eval.permuteNTTIndex = *eval.permuteNTTIndexesForKey(eval.rtks)
-	}
+// GetKeySwitcher returns the key-switching backend of the evaluator.
+func (eval *evaluator) GetKeySwitcher() rlwe.KeySwitchingBackend {
+	return eval.ksw
+}
 
-	if params.PCount() != 0 {
-		eval.KeySwitcher = rlwe.NewKeySwitcher(params.Parameters)
+// relinearizationKey returns the relinearization key of the evaluator, or panics if it is not available.
+func (eval *evaluator) relinearizationKey() rlwe.KeySwitchingKey {
+	if eval.evk != nil {
+		if rlk, ok := eval.evk.GetRelinearizationKey(); ok {
+			return rlk
+		}
 	}
+	panic("cannot relinearize: relinearization key not available")
+}
 
-	return eval
+// galoisKey returns the key of the automorphism defined by galEl, or panics if it is not available.
+func (eval *evaluator) galoisKey(galEl uint64) rlwe.KeySwitchingKey {
+	if eval.evk != nil {
+		if swk, ok := eval.evk.GetGaloisKey(galEl); ok {
+			return swk
+		}
+	}
+	panic(fmt.Sprintf("rotation key k=%d not available", eval.params.InverseGaloisElement(galEl)))
 }
 
-func (eval *evaluator) permuteNTTIndexesForKey(rtks *rlwe.RotationKeySet) *map[uint64][]uint64 {
-	if rtks == nil {
-		return &map[uint64][]uint64{}
+// permuteNTTIndexesForKey returns the NTT permutation indexes of the automorphisms of the keys of evk.
+func (eval *evaluator) permuteNTTIndexesForKey(evk rlwe.EvaluationKeySet) map[uint64][]uint64 {
+	if evk == nil {
+		return map[uint64][]uint64{}
 	}
-	permuteNTTIndex := make(map[uint64][]uint64, len(rtks.Keys))
-	for galEl := range rtks.Keys {
+	galEls := evk.GaloisElements()
+	permuteNTTIndex := make(map[uint64][]uint64, len(galEls))
+	for _, galEl := range galEls {
 		permuteNTTIndex[galEl] = eval.params.RingQ().PermuteNTTIndex(galEl)
 	}
-	return &permuteNTTIndex
-}
-
-// GetKeySwitcher returns a pointer to the internal rlwe.KeySwither.
-func (eval *evaluator) GetKeySwitcher() *rlwe.KeySwitcher {
-	return eval.KeySwitcher
+	return permuteNTTIndex
 }
 
 func (eval *evaluator) checkBinary(op0, op1, opOut Operand, opOutMinDegree int) {
@@ -1192,14 +1220,14 @@ func (eval *evaluator) mulRelin(op0, op1 Operand, relin bool, ctOut *Ciphertext)
 
 		if relin {
 			c2.IsNTT = false
-			eval.RingQ().InvNTTLvl(level, c2, c2)
-			eval.SwitchKeysInPlace(level, c2, eval.rlk.Keys[0], eval.Pool[1].Q, eval.Pool[2].Q)
+			ringQ.InvNTTLvl(level, c2, c2)
+			eval.ksw.Switch(level, c2, eval.relinearizationKey(), eval.poolQP[1].Q, eval.poolQP[2].Q)
 
-			ringQ.NTTLazyLvl(level, eval.Pool[1].Q, eval.Pool[1].Q)
-			ringQ.NTTLazyLvl(level, eval.Pool[2].Q, eval.Pool[2].Q)
+			ringQ.NTTLvl(level, eval.poolQP[1].Q, eval.poolQP[1].Q)
+			ringQ.NTTLvl(level, eval.poolQP[2].Q, eval.poolQP[2].Q)
 
-			ringQ.AddLvl(level, c0, eval.Pool[1].Q, ctOut.Value[0])
-			ringQ.AddLvl(level, c1, eval.Pool[2].Q, ctOut.Value[1])
+			ringQ.AddLvl(level, c0, eval.poolQP[1].Q, ctOut.Value[0])
+			ringQ.AddLvl(level, c1, eval.poolQP[2].Q, ctOut.Value[1])
 		}
 
 		// Case Plaintext (x) Ciphertext or Ciphertext (x) Plaintext
@@ -1301,9 +1329,9 @@ func (eval *evaluator) mulRelinAndAdd(op0, op1 Operand, relin bool, ctOut *Ciphe
 		if relin {
 			c2.IsNTT = true
 			ringQ.MulCoeffsMontgomeryLvl(level, c01, tmp1.Value[1], c2) // c2 = c[1]*c[1]
-			eval.SwitchKeysInPlace(level, c2, eval.rlk.Keys[0], eval.Pool[1].Q, eval.Pool[2].Q)
-			ringQ.AddLvl(level, c0, eval.Pool[1].Q, c0)
-			ringQ.AddLvl(level, c1, eval.Pool[2].Q, c1)
+			eval.ksw.Switch(level, c2, eval.relinearizationKey(), eval.poolQP[1].Q, eval.poolQP[2].Q)
+			ringQ.AddLvl(level, c0, eval.poolQP[1].Q, c0)
+			ringQ.AddLvl(level, c1, eval.poolQP[2].Q, c1)
 		} else {
 			ringQ.MulCoeffsMontgomeryAndAddLvl(level, c01, tmp1.Value[1], c2) // c2 = c[1]*c[1]
 		}
@@ -1350,10 +1378,10 @@ func (eval *evaluator) Relinearize(ct0 *Ciphertext, ctOut *Ciphertext) {
 	level := utils.MinInt(ct0.Level(), ctOut.Level())
 	ringQ := eval.params.RingQ()
 
-	eval.SwitchKeysInPlace(level, ct0.Value[2], eval.rlk.Keys[0], eval.Pool[1].Q, eval.Pool[2].Q)
+	eval.ksw.Switch(level, ct0.Value[2], eval.relinearizationKey(), eval.poolQP[1].Q, eval.poolQP[2].Q)
 
-	ringQ.AddLvl(level, ct0.Value[0], eval.Pool[1].Q, ctOut.Value[0])
-	ringQ.AddLvl(level, ct0.Value[1], eval.Pool[2].Q, ctOut.Value[1])
+	ringQ.AddLvl(level, ct0.Value[0], eval.poolQP[1].Q, ctOut.Value[0])
+	ringQ.AddLvl(level, ct0.Value[1], eval.poolQP[2].Q, ctOut.Value[1])
 
 	ctOut.El().Resize(eval.params.Parameters, 1)
 }
@@ -1381,10 +1409,10 @@ func (eval *evaluator) SwitchKeys(ct0 *Ciphertext, switchingKey *rlwe.SwitchingK
 
 	ctOut.Scale = ct0.Scale
 
-	eval.SwitchKeysInPlace(level, ct0.Value[1], switchingKey, eval.Pool[1].Q, eval.Pool[2].Q)
+	eval.ksw.Switch(level, ct0.Value[1], switchingKey, eval.poolQP[1].Q, eval.poolQP[2].Q)
 
-	ringQ.AddLvl(level, ct0.Value[0], eval.Pool[1].Q, ctOut.Value[0])
-	ring.CopyValuesLvl(level, eval.Pool[2].Q, ctOut.Value[1])
+	ringQ.AddLvl(level, ct0.Value[0], eval.poolQP[1].Q, ctOut.Value[0])
+	ring.CopyValuesLvl(level, eval.poolQP[2].Q, ctOut.Value[1])
 }
 
 // RotateNew rotates the columns of ct0 by k positions to the left, and returns the result in a newly created element.
@@ -1448,19 +1476,16 @@ func (eval *evaluator) Conjugate(ct0 *Ciphertext, ctOut *Ciphertext) {
 
 func (eval *evaluator) permuteNTT(ct0 *Ciphertext, galEl uint64, ctOut *Ciphertext) {
 
-	rtk, generated := eval.rtks.GetRotationKey(galEl)
-	if !generated {
-		panic(fmt.Sprintf("rotation key k=%d not available", eval.params.InverseGaloisElement(galEl)))
-	}
+	rtk := eval.galoisKey(galEl)
 
 	level := utils.MinInt(ct0.Level(), ctOut.Level())
-	pool2Q := eval.Pool[1].Q
-	pool3Q := eval.Pool[2].Q
+	pool2Q := eval.poolQP[1].Q
+	pool3Q := eval.poolQP[2].Q
 	ringQ := eval.params.RingQ()
 
 	ringQ.InvNTTLvl(level, ct0.Value[1], pool2Q)
 	pool2Q.IsNTT = false
-	eval.SwitchKeysInPlace(level, pool2Q, rtk, ctOut.Value[0], ctOut.Value[1])
+	eval.ksw.Switch(level, pool2Q, rtk, ctOut.Value[0], ctOut.Value[1])
 
 	ringQ.NTTLvl(level, ctOut.Value[0], pool2Q)
 	ringQ.NTTLvl(level, ctOut.Value[1], pool3Q)
@@ -1471,7 +1496,7 @@ func (eval *evaluator) permuteNTT(ct0 *Ciphertext, galEl uint64, ctOut *Cipherte
 	ringQ.PermuteNTTLvl(level, pool3Q, galEl, ctOut.Value[1])
 }
 
-func (eval *evaluator) RotateHoistedNoModDownNew(level int, rotations []int, c0 *ring.Poly, c2DecompQP []rlwe.PolyQP) (cOut map[int][2]rlwe.PolyQP) {
+func (eval *evaluator) RotateHoistedNoModDownNew(level int, rotations []int, c0 *ring.Poly, c2Decomp *rlwe.Decomposition) (cOut map[int][2]rlwe.PolyQP) {
 	ringQ := eval.params.RingQ()
 	ringP := eval.params.RingP()
 	cOut = make(map[int][2]rlwe.PolyQP)
@@ -1479,34 +1504,30 @@ func (eval *evaluator) RotateHoistedNoModDownNew(level int, rotations []int, c0
 
 		if i != 0 {
 			cOut[i] = [2]rlwe.PolyQP{{Q: ringQ.NewPolyLvl(level), P: ringP.NewPoly()}, {Q: ringQ.NewPolyLvl(level), P: ringP.NewPoly()}}
-			eval.PermuteNTTHoistedNoModDown(level, c0, c2DecompQP, i, cOut[i][0].Q, cOut[i][1].Q, cOut[i][0].P, cOut[i][1].P)
+			eval.PermuteNTTHoistedNoModDown(level, c0, c2Decomp, i, cOut[i][0].Q, cOut[i][1].Q, cOut[i][0].P, cOut[i][1].P)
 		}
 	}
 
 	return
 }
 
-func (eval *evaluator) PermuteNTTHoistedNoModDown(level int, c0 *ring.Poly, c2DecompQP []rlwe.PolyQP, k int, ct0OutQ, ct1OutQ, ct0OutP, ct1OutP *ring.Poly) {
+func (eval *evaluator) PermuteNTTHoistedNoModDown(level int, c0 *ring.Poly, c2Decomp *rlwe.Decomposition, k int, ct0OutQ, ct1OutQ, ct0OutP, ct1OutP *ring.Poly) {
 
-	pool2Q := eval.Pool[0].Q
-	pool3Q := eval.Pool[1].Q
+	pool2Q := eval.poolQP[0].Q
+	pool3Q := eval.poolQP[1].Q
 
-	pool2P := eval.Pool[0].P
-	pool3P := eval.Pool[1].P
+	pool2P := eval.poolQP[0].P
+	pool3P := eval.poolQP[1].P
 
 	levelQ := level
 	levelP := eval.params.PCount() - 1
 
 	galEl := eval.params.GaloisElementForColumnRotationBy(k)
 
-	rtk, generated := eval.rtks.Keys[galEl]
-	if !generated {
-		fmt.Println(k)
-		panic("switching key not available")
-	}
+	rtk := eval.galoisKey(galEl)
 	index := eval.permuteNTTIndex[galEl]
 
-	eval.KeyswitchHoistedNoModDown(levelQ, c2DecompQP, rtk, pool2Q, pool3Q, pool2P, pool3P)
+	eval.ksw.SwitchHoistedNoModDown(levelQ, c2Decomp, rtk, pool2Q, pool3Q, pool2P, pool3P)
 
 	ringQ := eval.params.RingQ()
 
@@ -1520,7 +1541,7 @@ func (eval *evaluator) PermuteNTTHoistedNoModDown(level int, c0 *ring.Poly, c2De
 	ringQ.PermuteNTTWithIndexLvl(levelP, pool2P, index, ct0OutP)
 }
 
-func (eval *evaluator) PermuteNTTHoisted(level int, c0, c1 *ring.Poly, c2DecompQP []rlwe.PolyQP, k int, cOut0, cOut1 *ring.Poly) {
+func (eval *evaluator) PermuteNTTHoisted(level int, c0, c1 *ring.Poly, c2Decomp *rlwe.Decomposition, k int, cOut0, cOut1 *ring.Poly) {
 
 	if k == 0 {
 		cOut0.Copy(c0)
@@ -1529,20 +1550,16 @@ func (eval *evaluator) PermuteNTTHoisted(level int, c0, c1 *ring.Poly, c2DecompQ
 	}
 
 	galEl := eval.params.GaloisElementForColumnRotationBy(k)
-	rtk, generated := eval.rtks.Keys[galEl]
-	if !generated {
-		panic(fmt.Sprintf("specific rotation has not been generated: %d", k))
-	}
-
+	rtk := eval.galoisKey(galEl)
 	index := eval.permuteNTTIndex[galEl]
 
-	pool2Q := eval.Pool[0].Q
-	pool3Q := eval.Pool[1].Q
+	pool2Q := eval.poolQP[0].Q
+	pool3Q := eval.poolQP[1].Q
 
-	pool2P := eval.Pool[0].P
-	pool3P := eval.Pool[1].P
+	pool2P := eval.poolQP[0].P
+	pool3P := eval.poolQP[1].P
 
-	eval.KeyswitchHoisted(level, c2DecompQP, rtk, pool2Q, pool3Q, pool2P, pool3P)
+	eval.ksw.SwitchHoisted(level, c2Decomp, rtk, pool2Q, pool3Q, pool2P, pool3P)
 
 	ringQ := eval.params.RingQ()
 
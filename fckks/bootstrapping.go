@@ -0,0 +1,222 @@
+package fckks
+
+import (
+	"math"
+
+	"fast-ksw/ckks"
+	"fast-ksw/frlwe"
+	"fast-ksw/ring"
+)
+
+// Bootstrapper refreshes ciphertexts at a low level into ciphertexts at the level Depth() below the top
+// of the modulus chain, with the CKKS bootstrapping of Cheon et al.:
+//
+//   - ModRaise lifts the ciphertext from Q[0] to the whole modulus chain, so that it decrypts to
+//     scale*m + Q[0]*I for a polynomial I of small integer coefficients;
+//   - CoeffsToSlots moves the coefficients divided by Q[0] into the slots, with a homomorphic inverse DFT;
+//   - EvalMod removes their integer part I, with a cosine interpolated on a small interval and brought
+//     to [-K, K] by double-angle formulas;
+//   - SlotsToCoeffs moves the result back to the coefficients, with a homomorphic DFT.
+//
+// The DFTs are dense matrices of one logical level each, which leave the slots in bit-reversed order between
+// them.
+//
+// All the key switches are done by the Evaluator with the frlwe keys: the rotations of the trace and of
+// the DFTs, the conjugation splitting the real and imaginary parts, the relinearizations of EvalMod,
+// which are fused with its rescalings, and the switches to and from the ephemeral sparse secret around
+// the ModRaise.
+type Bootstrapper struct {
+	*Evaluator
+	BootstrappingParameters
+	polyEval *PolynomialEvaluator
+
+	// q0 is the first prime, and evalModScale the scale of the input of EvalMod
+	q0           float64
+	evalModScale float64
+
+	ctsMatrices HomomorphicDFTMatrix
+	stcMatrices HomomorphicDFTMatrix
+	sinePoly    *Polynomial
+	sineScale   float64
+}
+
+// NewBootstrapper creates a new Bootstrapper for the ciphertexts of params, with its own Evaluator using the keys of
+// evk. evk must hold the relinearization key, the conjugation key and the rotation keys of btpParams.Rotations(params),
+// and the encapsulation key if btpParams.EphemeralSecretWeight is not 0.
+// The parameters must use the standard ring and have more than btpParams.Depth() logical levels.
+func NewBootstrapper(params Parameters, btpParams BootstrappingParameters, evk frlwe.EvaluationKey) (btp *Bootstrapper) {
+
+	if params.RingType() != ring.Standard {
+		panic("cannot NewBootstrapper: the bootstrapping requires the standard ring")
+	}
+
+	if evk.Rlk == nil || evk.Cjk == nil {
+		panic("cannot NewBootstrapper: the relinearization key and the conjugation key are required")
+	}
+
+	if btpParams.EphemeralSecretWeight > 0 && evk.Ek == nil {
+		panic("cannot NewBootstrapper: the encapsulation key is required for an ephemeral secret")
+	}
+
+	if btpParams.K < 1 || btpParams.SineDegree < 1 || btpParams.DoubleAngle < 0 || btpParams.EphemeralSecretWeight < 0 {
+		panic("cannot NewBootstrapper: invalid BootstrappingParameters")
+	}
+
+	if btpParams.Depth() > params.MaxLogicalLevel() {
+		panic("cannot NewBootstrapper: the modulus chain has not enough logical levels for the bootstrapping")
+	}
+
+	btp = new(Bootstrapper)
+	btp.Evaluator = NewEvaluator(params, evk)
+	btp.BootstrappingParameters = btpParams
+	btp.polyEval = NewPolynomialEvaluator(btp.Evaluator)
+
+	btp.q0 = float64(params.Q()[0])
+
+	// cos(2*Pi*(K*x - 1/4)/2^r) on [-1, 1], which gives sin(2*Pi*K*x) after r double angles
+	K, r := float64(btpParams.K), math.Exp2(float64(btpParams.DoubleAngle))
+	btp.sinePoly = ChebyshevInterpolation(func(x complex128) complex128 {
+		return complex(math.Cos(2*math.Pi*(K*real(x)-0.25)/r), 0)
+	}, -1, 1, btpParams.SineDegree)
+
+	maxLevel := params.MaxLogicalLevel()
+	levelSine := params.LevelQ(maxLevel - 1 - btp.sinePoly.Depth())
+
+	// The input of EvalMod and the output of the cosine are at the scale of the level that divides them,
+	// which keeps the scale of the double angles stable
+	btp.evalModScale = btp.params.levelModulusFloat(params.LevelQ(maxLevel - 1))
+	btp.sineScale = btp.params.levelModulusFloat(levelSine)
+
+	sineOutScale := btp.sineScale
+	for i := 0; i < btpParams.DoubleAngle; i++ {
+		sineOutScale *= sineOutScale / btp.params.levelModulusFloat(params.LevelQ(maxLevel-1-btp.sinePoly.Depth()-i))
+	}
+
+	// The DFTs keep the scale of the ciphertexts, which Bootstrap sets to evalModScale before CoeffsToSlots, and
+	// to the scale of the input ciphertext before SlotsToCoeffs: their scalings compensate for these changes.
+	// CoeffsToSlots also divides the coefficients by K, and the trace multiplies those of sparse ciphertexts by
+	// 2^(MaxLogSlots-LogSlots). The output of EvalMod is sineOutScale * sin(2*Pi*c/q0) for a coefficient c.
+	ctsLiteral, stcLiteral := btpParams.dftLiterals(params)
+	traceFactor := math.Exp2(float64(params.MaxLogSlots() - params.LogSlots()))
+	ctsLiteral.Scaling = btp.evalModScale / (traceFactor * btp.q0 * K)
+	stcLiteral.Scaling = btp.q0 / (2 * math.Pi * sineOutScale)
+
+	ecd := ckks.NewEncoder(params.Parameters)
+	btp.ctsMatrices = NewHomomorphicDFTMatrixFromLiteral(params, ecd, ctsLiteral)
+	btp.stcMatrices = NewHomomorphicDFTMatrixFromLiteral(params, ecd, stcLiteral)
+
+	return
+}
+
+// BootstrapNew refreshes ctIn as in Bootstrap and returns the result in a newly created element.
+func (btp *Bootstrapper) BootstrapNew(ctIn *ckks.Ciphertext) (ctOut *ckks.Ciphertext) {
+	ctOut = ckks.NewCiphertext(btp.params.Parameters, 1, btp.params.LevelQ(btp.OutputLogicalLevel()), ctIn.Scale)
+	btp.Bootstrap(ctIn, ctOut)
+	return
+}
+
+// Bootstrap refreshes ctIn, of any level, and returns the result in ctOut, which must be at least at the logical
+// level OutputLogicalLevel(), at this level and with the scale of ctIn. The message of ctIn multiplied by its
+// scale must be small compared to Q[0]: the precision of the result decreases with the ratio Q[0]/ctIn.Scale,
+// and the bootstrapping fails if the message times the scale is not smaller than Q[0]/2.
+func (btp *Bootstrapper) Bootstrap(ctIn, ctOut *ckks.Ciphertext) {
+
+	if ctIn.Degree() != 1 || ctOut.Degree() != 1 {
+		panic("cannot Bootstrap: input and output Ciphertext must be of degree 1")
+	}
+
+	params := btp.params
+
+	var ct *ckks.Ciphertext
+	if btp.EphemeralSecretWeight > 0 {
+		ct = btp.ModRaiseNew(btp.SwitchToSparseNew(ctIn))
+		btp.SwitchToDense(ct, ct)
+	} else {
+		ct = btp.ModRaiseNew(ctIn)
+	}
+
+	// Keeps the coefficients of the sparse ciphertext
+	if params.LogSlots() < params.MaxLogSlots() {
+		btp.Trace(ct, params.LogSlots(), params.MaxLogSlots(), ct)
+	}
+
+	// The scalings of the DFTs account for these scales, see NewBootstrapper
+	ct.Scale = btp.evalModScale
+	ctReal, ctImag := btp.CoeffsToSlotsNew(ct, btp.ctsMatrices)
+
+	ctReal = btp.EvalModNew(ctReal)
+	ctReal.Scale = ctIn.Scale
+	if ctImag != nil {
+		ctImag = btp.EvalModNew(ctImag)
+		ctImag.Scale = ctIn.Scale
+	}
+
+	btp.SlotsToCoeffs(ctReal, ctImag, btp.stcMatrices, ctOut)
+}
+
+// OutputLogicalLevel returns the logical level of the ciphertexts returned by Bootstrap.
+func (btp *Bootstrapper) OutputLogicalLevel() int {
+	return btp.params.MaxLogicalLevel() - btp.Depth()
+}
+
+// ModRaiseNew lifts ctIn, reduced modulo Q[0], to the whole modulus chain with the centered representatives of its
+// coefficients, and returns the result in a newly created element. The result decrypts to the decryption of
+// ctIn modulo Q[0] plus Q[0]*I, where I has small integer coefficients. Its scale is Q[0], so that its
+// slots hold the coefficients divided by Q[0], whose integer part is removed by EvalMod.
+func (btp *Bootstrapper) ModRaiseNew(ctIn *ckks.Ciphertext) (ctOut *ckks.Ciphertext) {
+
+	ringQ := btp.params.RingQ()
+	q0 := ringQ.Modulus[0]
+
+	ctOut = ckks.NewCiphertext(btp.params.Parameters, 1, btp.params.MaxLevel(), btp.q0)
+
+	coeffs := btp.polyQPool[0].Coeffs[0]
+	for i := range ctOut.Value {
+
+		ringQ.InvNTTSingle(0, ctIn.Value[i].Coeffs[0], coeffs)
+
+		for j, qj := range ringQ.Modulus {
+			out := ctOut.Value[i].Coeffs[j]
+			for k, c := range coeffs {
+				if c > q0>>1 {
+					if v := (q0 - c) % qj; v != 0 {
+						out[k] = qj - v
+					} else {
+						out[k] = 0
+					}
+				} else {
+					out[k] = c % qj
+				}
+			}
+		}
+
+		ringQ.NTT(ctOut.Value[i], ctOut.Value[i])
+		ctOut.Value[i].IsNTT = true
+	}
+
+	return
+}
+
+// EvalModNew evaluates (1/2Pi)*sin(2Pi*K*x) on the real slots x of ct, returned by CoeffsToSlotsNew, and returns
+// the result in a newly created element, EvalModDepth() logical levels below. The result approximates
+// K*x - round(K*x) when this difference is small.
+func (btp *Bootstrapper) EvalModNew(ct *ckks.Ciphertext) (ctOut *ckks.Ciphertext) {
+
+	ctOut, err := btp.polyEval.EvaluateNew(ct, btp.sinePoly, btp.sineScale)
+	if err != nil {
+		panic(err)
+	}
+
+	// cos(2x) = 2cos(x)^2 - 1
+	for i := 0; i < btp.DoubleAngle; i++ {
+		ctSq := ciphertextAtLevel(btp.ctxPool, 2, ctOut.Level())
+		btp.Evaluator.Evaluator.Mul(ctOut, ctOut, ctSq)
+		btp.Evaluator.Evaluator.Add(ctSq, ctSq, ctSq)
+		btp.Evaluator.Evaluator.AddConst(ctSq, -1.0, ctSq)
+		btp.rescaleLogicalLevel(ctSq, ctOut)
+	}
+
+	ctOut.Scale *= 2 * math.Pi
+
+	return
+}
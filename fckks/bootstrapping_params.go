@@ -0,0 +1,142 @@
+package fckks
+
+import (
+	"math/bits"
+	"sort"
+
+	"fast-ksw/ring"
+	"fast-ksw/rlwe"
+)
+
+// BootstrappingParameters are the parameters of the EvalMod step of the bootstrapping. The other steps
+// are determined by the Parameters: the bootstrapping consumes the top logical levels of the modulus
+// chain, one for CoeffsToSlots, EvalModDepth() for EvalMod and one for SlotsToCoeffs, and refreshes a
+// ciphertext whose message, multiplied by its scale, is small compared to the first prime Q[0].
+type BootstrappingParameters struct {
+	// K bounds the integer part I of the coefficients divided by Q[0] after the ModRaise. It depends on the
+	// Hamming weight H of the secret, the coefficients of I being sums of H+1 terms in [-1/2, 1/2].
+	K int
+	// SineDegree is the degree of the Chebyshev interpolant of the cosine evaluated on [-K, K].
+	SineDegree int
+	// DoubleAngle is the number of double-angle formulas cos(2x) = 2cos(x)^2 - 1 applied to the cosine, which
+	// is interpolated on an interval 2^DoubleAngle times smaller.
+	DoubleAngle int
+	// EphemeralSecretWeight is the Hamming weight of the ephemeral sparse secret to which Bootstrap switches the
+	// ciphertexts before the ModRaise, with the encapsulation key of the frlwe.EvaluationKey, and from which it
+	// switches them back afterwards. K then depends on this weight rather than on the one of the secret. It is 0
+	// if the bootstrapping uses the secret of the Parameters.
+	EphemeralSecretWeight int
+}
+
+// EvalModDepth returns the number of logical levels consumed by EvalMod.
+func (p BootstrappingParameters) EvalModDepth() int {
+	return bits.Len64(uint64(p.SineDegree)) + p.DoubleAngle
+}
+
+// Depth returns the number of logical levels consumed by the bootstrapping.
+func (p BootstrappingParameters) Depth() int {
+	return p.EvalModDepth() + 2
+}
+
+// Rotations returns the rotations used by the bootstrapping of ciphertexts of params.LogSlots() slots.
+// Their keys are generated with frlwe.KeyGenerator.GenRotKeys. The bootstrapping also needs the
+// relinearization key and the conjugation key.
+func (p BootstrappingParameters) Rotations(params Parameters) (rotations []int) {
+
+	logSlots := params.LogSlots()
+
+	rotIndex := make(map[int]bool)
+
+	if logSlots < params.MaxLogSlots() {
+		for _, k := range params.RotationsForTrace(logSlots, params.MaxLogSlots()) {
+			rotIndex[k] = true
+		}
+	}
+
+	cts, stc := p.dftLiterals(params)
+	for _, k := range append(cts.Rotations(params), stc.Rotations(params)...) {
+		rotIndex[k] = true
+	}
+
+	rotations = make([]int, 0, len(rotIndex))
+	for k := range rotIndex {
+		rotations = append(rotations, k)
+	}
+	sort.Ints(rotations)
+
+	return
+}
+
+// dftLiterals returns the homomorphic DFTs of the bootstrapping, without their scalings: CoeffsToSlots at the top
+// logical level, and SlotsToCoeffs right below EvalMod.
+func (p BootstrappingParameters) dftLiterals(params Parameters) (cts, stc HomomorphicDFTMatrixLiteral) {
+
+	maxLevel := params.MaxLogicalLevel()
+
+	cts = HomomorphicDFTMatrixLiteral{
+		Type:         CoeffsToSlots,
+		LogSlots:     params.LogSlots(),
+		LogicalLevel: maxLevel,
+		Depth:        1,
+		BitReversed:  true,
+	}
+
+	stc = HomomorphicDFTMatrixLiteral{
+		Type:         SlotsToCoeffs,
+		LogSlots:     params.LogSlots(),
+		LogicalLevel: maxLevel - 1 - p.EvalModDepth(),
+		Depth:        1,
+		BitReversed:  true,
+	}
+
+	return
+}
+
+// BootstrappingParametersSet is a ParametersLiteral and BootstrappingParameters fitted for one another.
+type BootstrappingParametersSet struct {
+	SchemeParams        ParametersLiteral
+	BootstrappingParams BootstrappingParameters
+}
+
+var (
+	// BootstrappingPN16QP1127 is a parameter set for logN=16 and logQP=1127 refreshing 2^7 slots, with a secret of
+	// Hamming weight 192. It has 10 logical levels of 40 bits left after the bootstrapping, for a scale of 2^40 and
+	// a first prime of 55 bits. The key switching uses alpha = 2 primes per digit and blocks of gamma = 2 primes of
+	// QP: T must exceed N * beta * q_j * R_i, which holds with 5 primes of 60 bits.
+	BootstrappingPN16QP1127 = BootstrappingParametersSet{
+		SchemeParams: ParametersLiteral{
+			LogN: 16,
+			Q: []uint64{
+				0x80000000080001, // 55, Q[0]
+
+				0x100003e0001, 0xffffb20001, 0x10000500001, 0xffff940001, 0xffff8a0001, // 40 x 10, circuit
+				0xffff820001, 0xffff780001, 0x10000960001, 0x10000a40001, 0xffff580001,
+
+				0x10000b60001, // 40, SlotsToCoeffs
+
+				0x4000000120001, 0x3ffffffd20001, 0x4000000420001, 0x3ffffffb80001, 0x4000000660001, // 50 x 9, EvalMod
+				0x40000007e0001, 0x4000000800001, 0x40000008a0001, 0x4000000de0001,
+
+				0x10000000006e0001, // 60, CoeffsToSlots
+			},
+			P: []uint64{ // 61 x 2
+				0x1fffffffffe00001, 0x1fffffffffc80001,
+			},
+			T: []uint64{ // 60 x 5
+				0xffffffffffc0001, 0xfffffffff840001, 0xfffffffff6a0001, 0xfffffffff5a0001,
+				0xfffffffff2a0001,
+			},
+			Sigma:        rlwe.DefaultSigma,
+			DefaultScale: 1 << 40,
+			LogSlots:     7,
+			H:            192,
+			Gamma:        2,
+			RingType:     ring.Standard,
+		},
+		BootstrappingParams: BootstrappingParameters{
+			K:           16,
+			SineDegree:  47,
+			DoubleAngle: 3,
+		},
+	}
+)
@@ -0,0 +1,323 @@
+package fckks
+
+import (
+	"errors"
+	"math"
+
+	"fast-ksw/ckks"
+)
+
+// approximationSamples is the number of points of an interval on which the iterative approximations are run in the
+// clear to bound their error.
+const approximationSamples = 1 << 10
+
+// gCoeffs are the odd coefficients, in units of 2^-10, of the polynomials g_n of Cheon et al., "Efficient
+// Homomorphic Comparison Methods with Optimal Complexity": gCoeffs[n-1][i] is the coefficient of x^(2i+1) in g_n.
+// The g_n map [-1, 1] into itself and have a large derivative at 0, so that they quickly push small inputs
+// away from 0, but they do not converge to the sign.
+var gCoeffs = [4][]float64{
+	{2126, -1359},
+	{3334, -6108, 3796},
+	{4589, -16577, 25614, -12860},
+	{5850, -34974, 97015, -113492, 46623},
+}
+
+// SignParameters are the parameters of the approximation of the sign by the composite polynomials of Cheon et al.:
+// the sign of x is approximated by f_N o ... o f_N o g_N o ... o g_N (x), where f_N and g_N are odd polynomials of
+// degree 2N+1. The g_N push the inputs away from 0 and the f_N, which converge to the sign, refine the result.
+// The numbers of compositions of each polynomial are the smallest ones achieving the precision.
+type SignParameters struct {
+	// N selects the polynomials f_N and g_N, for N between 1 and 4.
+	N int
+	// LogGap is the log2 of the inverse of the smallest absolute value of the inputs: the sign is approximated on
+	// the x in [-1, 1] such that |x| >= 2^-LogGap.
+	LogGap int
+	// LogPrecision is the log2 of the inverse of the error of the approximation on these inputs.
+	LogPrecision int
+}
+
+// NewSignParameters returns the SignParameters achieving the given gap and precision with the smallest depth,
+// and among them with the fewest multiplications. It returns an error if this depth exceeds maxDepth.
+func NewSignParameters(logGap, logPrecision, maxDepth int) (p SignParameters, err error) {
+
+	bestCost := -1
+	for n := 1; n <= len(gCoeffs); n++ {
+
+		candidate := SignParameters{N: n, LogGap: logGap, LogPrecision: logPrecision}
+
+		dg, df := candidate.Compositions()
+		if cost := (dg + df) * (2*n + 1); candidate.Depth() <= maxDepth && (bestCost < 0 || candidate.Depth() < p.Depth() ||
+			(candidate.Depth() == p.Depth() && cost < bestCost)) {
+			p, bestCost = candidate, cost
+		}
+	}
+
+	if bestCost < 0 {
+		return p, errors.New("cannot NewSignParameters: the precision cannot be achieved within maxDepth")
+	}
+
+	return p, nil
+}
+
+// Compositions returns the number of compositions dg of g_N and df of f_N of the approximation. They are found by
+// following the image of [2^-LogGap, 1] by the successive compositions in the clear.
+func (p SignParameters) Compositions() (dg, df int) {
+
+	if p.N < 1 || p.N > len(gCoeffs) || p.LogGap < 1 || p.LogPrecision < 1 {
+		panic("cannot Compositions: invalid SignParameters")
+	}
+
+	f, g := p.fPolynomial(), p.gPolynomial()
+	target := 1 - math.Exp2(-float64(p.LogPrecision))
+
+	// The number of compositions of f needed from [lo, hi], which only depends on lo since f is increasing on [0, 1]
+	compositionsF := func(lo float64) (df int) {
+		for ; lo < target; df++ {
+			if next := real(f.Evaluate(complex(lo, 0))); next > lo {
+				lo = next
+			} else {
+				return -1
+			}
+		}
+		return
+	}
+
+	// Each composition of g is followed by the compositions of f it requires, and the best total is kept
+	lo, hi := math.Exp2(-float64(p.LogGap)), 1.0
+	dg, df = 0, compositionsF(lo)
+	for i := 1; i <= 64; i++ {
+
+		lo, hi = imageInterval(g, lo, hi)
+
+		if n := compositionsF(lo); n >= 0 && (df < 0 || i+n < dg+df) {
+			dg, df = i, n
+		}
+	}
+
+	if df < 0 {
+		panic("cannot Compositions: the approximation does not converge")
+	}
+
+	return
+}
+
+// Depth returns the number of logical levels consumed by Comparator.SignNew and Comparator.CompareNew.
+// Comparator.MaxNew and Comparator.MinNew consume one more.
+func (p SignParameters) Depth() int {
+	dg, df := p.Compositions()
+	return (dg + df) * p.fPolynomial().Depth()
+}
+
+// fPolynomial returns f_N(x) = sum_{i=0}^{N} binomial(2i, i)/4^i * x * (1 - x^2)^i, which is the odd polynomial of
+// degree 2N+1 with f_N(1) = 1 whose derivatives of order 1 to N vanish at -1 and 1.
+func (p SignParameters) fPolynomial() *Polynomial {
+
+	coeffs := make([]complex128, 2*p.N+2)
+
+	// c = binomial(2i, i)/4^i
+	c := 1.0
+	for i := 0; i <= p.N; i++ {
+		if i > 0 {
+			c *= float64(2*i*(2*i-1)) / float64(4*i*i)
+		}
+
+		// (1 - x^2)^i = sum_k binomial(i, k) * (-x^2)^k
+		binom := 1.0
+		for k := 0; k <= i; k++ {
+			if k > 0 {
+				binom = -binom * float64(i-k+1) / float64(k)
+			}
+			coeffs[2*k+1] += complex(c*binom, 0)
+		}
+	}
+
+	return NewPolynomial(Monomial, coeffs, 0, 0)
+}
+
+// gPolynomial returns g_N.
+func (p SignParameters) gPolynomial() *Polynomial {
+	coeffs := make([]complex128, 2*p.N+2)
+	for i, c := range gCoeffs[p.N-1] {
+		coeffs[2*i+1] = complex(c/1024, 0)
+	}
+	return NewPolynomial(Monomial, coeffs, 0, 0)
+}
+
+// imageInterval returns the smallest interval containing the values of pol on approximationSamples+1 points of
+// [lo, hi].
+func imageInterval(pol *Polynomial, lo, hi float64) (loOut, hiOut float64) {
+	loOut, hiOut = math.Inf(1), math.Inf(-1)
+	for i := 0; i <= approximationSamples; i++ {
+		y := real(pol.Evaluate(complex(lo+(hi-lo)*float64(i)/approximationSamples, 0)))
+		loOut, hiOut = math.Min(loOut, y), math.Max(hiOut, y)
+	}
+	return
+}
+
+// Comparator evaluates the sign and the comparisons built on it on the real slots of ciphertexts. The inputs of
+// the sign, and the differences of the inputs of the comparisons, must be in [-1, 1], and are only accurately
+// handled if their absolute value is at least 2^-LogGap. The imaginary parts of the slots must be zero.
+type Comparator struct {
+	*PolynomialEvaluator
+	SignParameters
+
+	// pols are the compositions of the sign approximation, in their order of evaluation, and depth their depth
+	pols  []*Polynomial
+	depth int
+}
+
+// NewComparator creates a new Comparator using the keys of eval. It needs the relinearization key, which must be
+// a frlwe.RelinKey, and the rotation keys of Parameters.RotationsForReduceMax for ReduceMaxNew.
+func NewComparator(eval *Evaluator, signParams SignParameters) (cmp *Comparator) {
+
+	cmp = &Comparator{PolynomialEvaluator: NewPolynomialEvaluator(eval), SignParameters: signParams}
+
+	dg, df := signParams.Compositions()
+	f, g := signParams.fPolynomial(), signParams.gPolynomial()
+	for i := 0; i < dg+df; i++ {
+		if i < dg {
+			cmp.pols = append(cmp.pols, g)
+		} else {
+			cmp.pols = append(cmp.pols, f)
+		}
+	}
+	cmp.depth = (dg + df) * f.Depth()
+
+	return
+}
+
+// SignNew approximates the sign of the slots of ctIn and returns the result, at the scale of ctIn, in a newly
+// created element, Depth() logical levels below.
+// It returns an error if ctIn is not of degree 1 or has not enough levels.
+func (cmp *Comparator) SignNew(ctIn *ckks.Ciphertext) (ctOut *ckks.Ciphertext, err error) {
+	return cmp.evaluateSign(ctIn, 1, 0, ctIn.Scale)
+}
+
+// CompareNew approximates the slot-wise comparison of ct0 and ct1, 1 where ct0 > ct1 and 0 where ct0 < ct1,
+// and returns the result, at the scale of ct0 - ct1, in a newly created element, Depth() logical levels below.
+// It returns an error if the operands are not of degree 1 or have not enough levels.
+func (cmp *Comparator) CompareNew(ct0, ct1 *ckks.Ciphertext) (ctOut *ckks.Ciphertext, err error) {
+	diff := cmp.SubNew(ct0, ct1)
+	return cmp.evaluateSign(diff, 0.5, 0.5, diff.Scale)
+}
+
+// MaxNew approximates the slot-wise maximum of ct0 and ct1, as ct1 + (ct0 - ct1) * CompareNew(ct0, ct1), and
+// returns the result, at the scale of ct0 - ct1, in a newly created element, Depth()+1 logical levels below.
+// It returns an error if the operands are not of degree 1 or have not enough levels.
+func (cmp *Comparator) MaxNew(ct0, ct1 *ckks.Ciphertext) (ctOut *ckks.Ciphertext, err error) {
+
+	prod, err := cmp.mulByComparison(ct0, ct1)
+	if err != nil {
+		return nil, err
+	}
+
+	cmp.Add(ct1, prod, prod)
+
+	return prod, nil
+}
+
+// MinNew approximates the slot-wise minimum of ct0 and ct1, as ct0 - (ct0 - ct1) * CompareNew(ct0, ct1), and
+// returns the result, at the scale of ct0 - ct1, in a newly created element, Depth()+1 logical levels below.
+// It returns an error if the operands are not of degree 1 or have not enough levels.
+func (cmp *Comparator) MinNew(ct0, ct1 *ckks.Ciphertext) (ctOut *ckks.Ciphertext, err error) {
+
+	prod, err := cmp.mulByComparison(ct0, ct1)
+	if err != nil {
+		return nil, err
+	}
+
+	cmp.Sub(ct0, prod, prod)
+
+	return prod, nil
+}
+
+// ReduceMaxNew sets each slot i of the result to the maximum of the slots i + j*batch of ctIn for 0 <= j < n
+// (indexes taken modulo the number of slots), with a tree of log2(n) rotations and MaxNew, and returns it in
+// a newly created element, log2(n)*(Depth()+1) logical levels below. n must be a power of two. The rotation keys
+// of Parameters.RotationsForReduceMax(batch, n) must be in the EvaluationKey.
+// It returns an error if n is not a power of two, or if ctIn is not of degree 1 or has not enough levels.
+func (cmp *Comparator) ReduceMaxNew(ctIn *ckks.Ciphertext, batch, n int) (ctOut *ckks.Ciphertext, err error) {
+
+	if n < 1 || n&(n-1) != 0 {
+		return nil, errors.New("cannot ReduceMax: n must be a power of two")
+	}
+
+	ctOut = ctIn
+	for i := 1; i < n; i <<= 1 {
+		if ctOut, err = cmp.MaxNew(ctOut, cmp.RotateNew(ctOut, i*batch)); err != nil {
+			return nil, err
+		}
+	}
+
+	if ctOut == ctIn {
+		ctOut = ctIn.CopyNew()
+	}
+
+	return ctOut, nil
+}
+
+// RotationsForReduceMax returns the rotations used by Comparator.ReduceMaxNew with parameters batch and n.
+func (p Parameters) RotationsForReduceMax(batch, n int) (rotations []int) {
+	return p.RotationsForInnerSumLog(batch, n)
+}
+
+// mulByComparison returns (ct0 - ct1) * CompareNew(ct0, ct1), at the scale of ct0 - ct1. The comparison is computed
+// at the scale of the modulus of its level, so that the rescaling of the product leaves the scale unchanged.
+func (cmp *Comparator) mulByComparison(ct0, ct1 *ckks.Ciphertext) (prod *ckks.Ciphertext, err error) {
+
+	params := cmp.params
+
+	diff := cmp.SubNew(ct0, ct1)
+
+	logicalLevel := params.LogicalLevel(diff.Level()) - cmp.depth
+	if logicalLevel < 1 {
+		return nil, errors.New("cannot Compare: input Ciphertext has not enough levels")
+	}
+
+	level := params.LevelQ(logicalLevel)
+
+	comparison, err := cmp.evaluateSign(diff, 0.5, 0.5, cmp.params.levelModulusFloat(level))
+	if err != nil {
+		return nil, err
+	}
+
+	return cmp.mulRescaleLogicalLevelNew(diff, comparison), nil
+}
+
+// evaluateSign returns a*sign(ctIn) + b at the given scale, with the compositions of the sign approximation.
+// The intermediate compositions are computed at the scale of ctIn.
+func (cmp *Comparator) evaluateSign(ctIn *ckks.Ciphertext, a, b float64, targetScale float64) (ctOut *ckks.Ciphertext, err error) {
+
+	if ctIn.Degree() != 1 {
+		return nil, errors.New("cannot Sign: input Ciphertext must be of degree 1")
+	}
+
+	if cmp.params.LogicalLevel(ctIn.Level()) < cmp.depth {
+		return nil, errors.New("cannot Sign: input Ciphertext has not enough levels for the depth of the sign")
+	}
+
+	ctOut = ctIn
+	for i, pol := range cmp.pols {
+
+		scale := ctIn.Scale
+
+		// The last composition is scaled by a
+		if i == len(cmp.pols)-1 {
+			coeffs := make([]complex128, len(pol.Coeffs))
+			for j, c := range pol.Coeffs {
+				coeffs[j] = complex(a, 0) * c
+			}
+			pol, scale = NewPolynomial(Monomial, coeffs, 0, 0), targetScale
+		}
+
+		if ctOut, err = cmp.EvaluateNew(ctOut, pol, scale); err != nil {
+			return nil, err
+		}
+	}
+
+	if b != 0 {
+		cmp.Evaluator.Evaluator.AddConst(ctOut, b, ctOut)
+	}
+
+	return ctOut, nil
+}
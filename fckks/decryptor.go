@@ -27,6 +27,8 @@ func (dec *Decryptor) Decrypt(ctIn *ckks.Ciphertext, ptOut *ckks.Plaintext) {
 }
 
 func (dec *Decryptor) DecryptToMsg(ctIn *ckks.Ciphertext, msgOut *Message) {
+	// Decrypt truncates the plaintext to the level of ctIn, so the pool is brought back to this level
+	dec.ptxtPool.Value.Coeffs = dec.ptxtPool.Value.Coeffs[:ctIn.Level()+1]
 	dec.Decrypt(ctIn, dec.ptxtPool)
 	msgOut.Value = dec.encoder.Decode(dec.ptxtPool, dec.params.LogSlots())
 }
@@ -0,0 +1,459 @@
+package fckks
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+
+	"fast-ksw/ckks"
+	"fast-ksw/ring"
+	"fast-ksw/utils"
+)
+
+// DFTType is the direction of a HomomorphicDFTMatrix.
+type DFTType int
+
+const (
+	// CoeffsToSlots moves the coefficients of a ciphertext to its slots, with a homomorphic inverse DFT.
+	CoeffsToSlots DFTType = iota
+	// SlotsToCoeffs moves the slots of a ciphertext to its coefficients, with a homomorphic DFT.
+	SlotsToCoeffs
+)
+
+// HomomorphicDFTMatrixLiteral describes the homomorphic encoding or decoding of the ciphertexts of 2^LogSlots
+// slots, evaluated with Evaluator.CoeffsToSlots or Evaluator.SlotsToCoeffs.
+//
+// The special FFT of the encoding is the product of LogSlots butterfly matrices of three diagonals. They are
+// grouped into Depth matrices, each evaluated with Evaluator.LinearTransform and followed by a rescaling: a
+// smaller depth needs fewer logical levels but more rotations. Unless BitReversed is set, the bit-reversal
+// permutation of the FFT is merged into the last matrix of CoeffsToSlots or into the first matrix of
+// SlotsToCoeffs, which becomes close to dense.
+type HomomorphicDFTMatrixLiteral struct {
+	Type     DFTType
+	LogSlots int
+	// LogicalLevel is the logical level of the first matrix: the transform consumes the logical levels
+	// LogicalLevel down to LogicalLevel-Depth+1.
+	LogicalLevel int
+	Depth        int
+	// BitReversed leaves the coefficients in bit-reversed order in the slots: CoeffsToSlots returns them
+	// in this order and SlotsToCoeffs expects them in this order. This saves rotations when the order of
+	// the slots does not matter between the two, as in the bootstrapping.
+	BitReversed bool
+	// Scaling is a constant multiplied with the transform, 0 being read as 1.
+	Scaling float64
+}
+
+// HomomorphicDFTMatrix is the encoding of a HomomorphicDFTMatrixLiteral, its matrices being sorted in the
+// order of their evaluation.
+type HomomorphicDFTMatrix struct {
+	HomomorphicDFTMatrixLiteral
+	Matrices []LinearTransform
+}
+
+// NewHomomorphicDFTMatrixFromLiteral encodes the matrices of the homomorphic DFT described by lit. Each matrix
+// is encoded at the scale of the primes of its logical level, so that the transform keeps the scale of the
+// ciphertext.
+func NewHomomorphicDFTMatrixFromLiteral(params Parameters, ecd ckks.Encoder, lit HomomorphicDFTMatrixLiteral) HomomorphicDFTMatrix {
+
+	factors := lit.factors(params)
+	logSlots := lit.dftLogSlots(params)
+
+	matrices := make([]LinearTransform, len(factors))
+	for i, diagonals := range factors {
+		levelQ := params.LevelQ(lit.LogicalLevel - i)
+		matrices[i] = NewLinearTransform(params, ecd, diagonals, levelQ, params.levelModulusFloat(levelQ), logSlots)
+	}
+
+	return HomomorphicDFTMatrix{HomomorphicDFTMatrixLiteral: lit, Matrices: matrices}
+}
+
+// Rotations returns the rotations whose keys are needed to evaluate the homomorphic DFT described by lit,
+// as the matrices created by NewHomomorphicDFTMatrixFromLiteral do. The keys are generated with
+// frlwe.KeyGenerator.GenRotKeys. CoeffsToSlots also needs the conjugation key.
+func (lit HomomorphicDFTMatrixLiteral) Rotations(params Parameters) (rotations []int) {
+
+	slots := 1 << lit.dftLogSlots(params)
+
+	rotIndex := make(map[int]bool)
+	for _, diagonals := range lit.factors(params) {
+
+		diags := make([]int, 0, len(diagonals))
+		for k := range diagonals {
+			diags = append(diags, k)
+		}
+
+		for _, k := range bsgsRotations(diags, findBestBSGSSplit(diags, slots)) {
+			if !rotIndex[k] {
+				rotIndex[k] = true
+				rotations = append(rotations, k)
+			}
+		}
+	}
+
+	return
+}
+
+// dftLogSlots returns the log2 of the number of slots of the matrices: the real and the imaginary parts of
+// the coefficients of a sparse ciphertext are packed in twice its number of slots.
+func (lit HomomorphicDFTMatrixLiteral) dftLogSlots(params Parameters) int {
+	if lit.LogSlots < params.MaxLogSlots() {
+		return lit.LogSlots + 1
+	}
+	return lit.LogSlots
+}
+
+// OutputLogicalLevel returns the logical level of the ciphertexts returned by the transform.
+func (lit HomomorphicDFTMatrixLiteral) OutputLogicalLevel() int {
+	return lit.LogicalLevel - lit.Depth
+}
+
+// factors returns the diagonals of the matrices of lit, in the order of their evaluation.
+//
+// The encoding of n slots z maps w = G*z to the coefficients, the real parts of w on the coefficients 0 to n-1
+// of the polynomial in Y = X^(N/2n) and the imaginary parts on the coefficients n to 2n-1, and the decoding is
+// the special FFT F = G^-1. F = S_L * ... * S_1 * R and G = R * T_1 * ... * T_L, where R is the bit-reversal
+// permutation and S_l and T_l are the butterflies of span 2^(l-1).
+//
+// CoeffsToSlots evaluates G/2 on the slots, whose sum with its conjugate is the real part of w. For sparse
+// ciphertexts, whose n slots are replicated on the 2n slots of the matrices, the last matrix also multiplies the
+// slots n to 2n-1 by -i, so that the real parts of the result are the coefficients 0 to 2n-1. SlotsToCoeffs maps
+// the 2n real slots v to F*(v[:n] + i*v[n:]), the first matrix packing the real and imaginary parts.
+func (lit HomomorphicDFTMatrixLiteral) factors(params Parameters) (factors []map[int][]complex128) {
+
+	if params.RingType() != ring.Standard {
+		panic("cannot NewHomomorphicDFTMatrix: the homomorphic DFT requires the standard ring")
+	}
+
+	if lit.LogSlots < 1 || lit.LogSlots > params.MaxLogSlots() {
+		panic(fmt.Sprintf("cannot NewHomomorphicDFTMatrix: LogSlots must be between 1 and %d", params.MaxLogSlots()))
+	}
+
+	if lit.Depth < 1 || lit.Depth > lit.LogSlots {
+		panic("cannot NewHomomorphicDFTMatrix: Depth must be between 1 and LogSlots")
+	}
+
+	if lit.LogicalLevel < lit.Depth || lit.LogicalLevel > params.MaxLogicalLevel() {
+		panic("cannot NewHomomorphicDFTMatrix: the matrices must fit between the logical levels 1 and MaxLogicalLevel")
+	}
+
+	logN := lit.LogSlots
+	n := 1 << logN
+
+	rotGroup, roots := specialFFTRoots(n, int(params.RingQ().NthRoot))
+
+	// The butterflies in the order of their evaluation, T_l being divided by 2 for the division by n of G
+	stages := make([]map[int][]complex128, logN)
+	for l := 1; l <= logN; l++ {
+		if lit.Type == CoeffsToSlots {
+			stages[logN-l] = butterfly(n, l, rotGroup, roots, true)
+		} else {
+			stages[l-1] = butterfly(n, l, rotGroup, roots, false)
+		}
+	}
+
+	// Splits the butterflies in Depth groups, the first ones taking the remainder
+	factors = make([]map[int][]complex128, lit.Depth)
+	for i, start := 0, 0; i < lit.Depth; i++ {
+		size := logN / lit.Depth
+		if i < logN%lit.Depth {
+			size++
+		}
+		factors[i] = stages[start]
+		for _, stage := range stages[start+1 : start+size] {
+			factors[i] = mulDiagonals(stage, factors[i], n)
+		}
+		start += size
+	}
+
+	if !lit.BitReversed {
+		if lit.Type == CoeffsToSlots {
+			factors[lit.Depth-1] = bitReverse(factors[lit.Depth-1], n, false)
+		} else {
+			factors[0] = bitReverse(factors[0], n, true)
+		}
+	}
+
+	// Replicates the diagonals on the slots of the matrices
+	s := 1 << lit.dftLogSlots(params)
+	for i := range factors {
+		for k, diag := range factors[i] {
+			replicated := make([]complex128, s)
+			for j := range replicated {
+				replicated[j] = diag[j%n]
+			}
+			factors[i][k] = replicated
+		}
+	}
+
+	if lit.Type == CoeffsToSlots {
+		rows := make([]complex128, s)
+		for j := range rows {
+			if j < n {
+				rows[j] = 0.5
+			} else {
+				rows[j] = -0.5i
+			}
+		}
+		factors[lit.Depth-1] = mulDiagonals(map[int][]complex128{0: rows}, factors[lit.Depth-1], s)
+	} else if s > n {
+		// [I, iI; I, iI]
+		pack := map[int][]complex128{0: make([]complex128, s), n: make([]complex128, s)}
+		for j := 0; j < s; j++ {
+			if j < n {
+				pack[0][j], pack[n][j] = 1, 1i
+			} else {
+				pack[0][j], pack[n][j] = 1i, 1
+			}
+		}
+		factors[0] = mulDiagonals(factors[0], pack, s)
+	}
+
+	if lit.Scaling != 0 {
+		for _, diag := range factors[lit.Depth-1] {
+			for j := range diag {
+				diag[j] *= complex(lit.Scaling, 0)
+			}
+		}
+	}
+
+	return
+}
+
+// specialFFTRoots returns the powers of the Galois generator and the M-th roots of unity used by the special
+// FFT of n slots.
+func specialFFTRoots(n, M int) (rotGroup []int, roots []complex128) {
+
+	rotGroup = make([]int, n)
+	fivePows := 1
+	for i := range rotGroup {
+		rotGroup[i] = fivePows
+		fivePows *= int(ckks.GaloisGen)
+		fivePows &= M - 1
+	}
+
+	roots = make([]complex128, M)
+	for i := range roots {
+		angle := 2 * math.Pi * float64(i) / float64(M)
+		roots[i] = complex(math.Cos(angle), math.Sin(angle))
+	}
+
+	return
+}
+
+// butterfly returns the diagonals of the butterfly of span 2^(l-1) of the special FFT of n slots, S_l, or of
+// the special inverse FFT, T_l/2 if inverse is set.
+func butterfly(n, l int, rotGroup []int, roots []complex128, inverse bool) (diagonals map[int][]complex128) {
+
+	M := len(roots)
+	length := 1 << l
+	lenh := length >> 1
+	mask := 4*length - 1
+	gap := M / (4 * length)
+
+	// The diagonals lenh and n-lenh are the same when lenh = n/2
+	diagonals = map[int][]complex128{0: make([]complex128, n), lenh: make([]complex128, n), n - lenh: make([]complex128, n)}
+
+	for k := 0; k < n; k++ {
+
+		j := k % length
+		if j < lenh {
+			// S_l: v[k] + psi_j * v[k+lenh], T_l: v[k] + v[k+lenh]
+			psi := roots[(rotGroup[j]&mask)*gap]
+			if inverse {
+				diagonals[0][k], diagonals[lenh][k] = 0.5, 0.5
+			} else {
+				diagonals[0][k], diagonals[lenh][k] = 1, psi
+			}
+		} else {
+			// S_l: v[k-lenh] - psi_j * v[k], T_l: (v[k-lenh] - v[k]) * conj(psi_j)
+			psi := roots[(rotGroup[j-lenh]&mask)*gap]
+			if inverse {
+				diagonals[0][k], diagonals[n-lenh][k] = -0.5*complex(real(psi), -imag(psi)), 0.5*complex(real(psi), -imag(psi))
+			} else {
+				diagonals[0][k], diagonals[n-lenh][k] = -psi, 1
+			}
+		}
+	}
+
+	return
+}
+
+// bitReverse returns the diagonals of the matrix a of n slots with its rows, or its columns if columns is set,
+// permuted by the bit-reversal permutation, i.e. of R*a or a*R.
+func bitReverse(a map[int][]complex128, n int, columns bool) (c map[int][]complex128) {
+
+	logN := uint64(bits.Len64(uint64(n)) - 1)
+
+	c = make(map[int][]complex128)
+	for k, diag := range a {
+		for i, v := range diag {
+
+			if v == 0 {
+				continue
+			}
+
+			// a[i][i+k] is moved to the row rev(i) or to the column rev(i+k)
+			var row, col int
+			if columns {
+				row, col = i, int(utils.BitReverse64(uint64((i+k)%n), logN))
+			} else {
+				row, col = int(utils.BitReverse64(uint64(i), logN)), (i+k)%n
+			}
+
+			kc := (col - row + n) % n
+			if _, ok := c[kc]; !ok {
+				c[kc] = make([]complex128, n)
+			}
+			c[kc][row] = v
+		}
+	}
+
+	return
+}
+
+// mulDiagonals returns the diagonals of the product a*b of the matrices of s slots given by their diagonals.
+func mulDiagonals(a, b map[int][]complex128, s int) (c map[int][]complex128) {
+
+	c = make(map[int][]complex128)
+
+	for ka, diagA := range a {
+		for kb, diagB := range b {
+
+			k := (ka + kb) % s
+			if _, ok := c[k]; !ok {
+				c[k] = make([]complex128, s)
+			}
+
+			// c[i][i+ka+kb] += a[i][i+ka] * b[i+ka][i+ka+kb]
+			for i := range c[k] {
+				c[k][i] += diagA[i] * diagB[(i+ka)%s]
+			}
+		}
+	}
+
+	for k, diag := range c {
+		var nonZero bool
+		for _, v := range diag {
+			nonZero = nonZero || v != 0
+		}
+		if !nonZero {
+			delete(c, k)
+		}
+	}
+
+	return
+}
+
+// CoeffsToSlotsNew evaluates ctsMatrices on ctIn as in CoeffsToSlots and returns the results in newly created
+// elements, ctImag being nil for sparse ciphertexts.
+func (eval *Evaluator) CoeffsToSlotsNew(ctIn *ckks.Ciphertext, ctsMatrices HomomorphicDFTMatrix) (ctReal, ctImag *ckks.Ciphertext) {
+
+	params := eval.params
+	levelQ := params.LevelQ(ctsMatrices.OutputLogicalLevel())
+
+	ctReal = ckks.NewCiphertext(params.Parameters, 1, levelQ, ctIn.Scale)
+	if ctsMatrices.LogSlots == params.MaxLogSlots() {
+		ctImag = ckks.NewCiphertext(params.Parameters, 1, levelQ, ctIn.Scale)
+	}
+
+	eval.CoeffsToSlots(ctIn, ctsMatrices, ctReal, ctImag)
+
+	return
+}
+
+// CoeffsToSlots moves the coefficients of ctIn, divided by its scale, to the slots of ctReal and ctImag, at the
+// scale of ctIn and ctsMatrices.Depth logical levels below ctsMatrices.LogicalLevel. For full ciphertexts,
+// ctReal holds the coefficients 0 to N/2-1 and ctImag the coefficients N/2 to N-1. For sparse ciphertexts of n
+// slots, whose coefficients must be on the multiples of N/2n, ctReal holds these 2n coefficients on its 2n
+// slots and ctImag must be nil. ctIn must be at least at the logical level of the first matrix.
+func (eval *Evaluator) CoeffsToSlots(ctIn *ckks.Ciphertext, ctsMatrices HomomorphicDFTMatrix, ctReal, ctImag *ckks.Ciphertext) {
+
+	if ctsMatrices.Type != CoeffsToSlots {
+		panic("cannot CoeffsToSlots: the matrices are not of type CoeffsToSlots")
+	}
+
+	if (ctImag == nil) != (ctsMatrices.LogSlots < eval.params.MaxLogSlots()) {
+		panic("cannot CoeffsToSlots: ctImag must be nil if and only if the ciphertext is sparse")
+	}
+
+	ct := eval.homomorphicDFT(ctIn, ctsMatrices)
+
+	// The matrices are divided by 2, so that the sum with the conjugate is the real part
+	ctConj := eval.ConjugateNew(ct)
+
+	if ctImag != nil {
+		eval.Evaluator.Sub(ct, ctConj, ctImag)
+		eval.DivByi(ctImag, ctImag)
+	}
+
+	eval.Evaluator.Add(ct, ctConj, ctReal)
+}
+
+// SlotsToCoeffsNew evaluates stcMatrices on ctReal and ctImag as in SlotsToCoeffs and returns the result in a
+// newly created element.
+func (eval *Evaluator) SlotsToCoeffsNew(ctReal, ctImag *ckks.Ciphertext, stcMatrices HomomorphicDFTMatrix) (ctOut *ckks.Ciphertext) {
+	ctOut = ckks.NewCiphertext(eval.params.Parameters, 1, eval.params.LevelQ(stcMatrices.OutputLogicalLevel()), ctReal.Scale)
+	eval.SlotsToCoeffs(ctReal, ctImag, stcMatrices, ctOut)
+	return
+}
+
+// SlotsToCoeffs moves the slots of ctReal and ctImag to the coefficients of ctOut, divided by its scale, as the
+// inverse of CoeffsToSlots: ctOut is at the scale of ctReal and stcMatrices.Depth logical levels below
+// stcMatrices.LogicalLevel. For full ciphertexts, ctImag may be nil, for coefficients N/2 to N-1 equal to zero.
+// For sparse ciphertexts, ctImag must be nil. ctReal and ctImag must have the same scale and be at least at the
+// logical level of the first matrix.
+func (eval *Evaluator) SlotsToCoeffs(ctReal, ctImag *ckks.Ciphertext, stcMatrices HomomorphicDFTMatrix, ctOut *ckks.Ciphertext) {
+
+	if stcMatrices.Type != SlotsToCoeffs {
+		panic("cannot SlotsToCoeffs: the matrices are not of type SlotsToCoeffs")
+	}
+
+	ct := ctReal
+	if ctImag != nil {
+
+		if stcMatrices.LogSlots < eval.params.MaxLogSlots() {
+			panic("cannot SlotsToCoeffs: ctImag must be nil for sparse ciphertexts")
+		}
+
+		if ctImag.Scale != ctReal.Scale {
+			panic("cannot SlotsToCoeffs: ctReal and ctImag must have the same scale")
+		}
+
+		ct = eval.MultByiNew(ctImag)
+		eval.Evaluator.Add(ct, ctReal, ct)
+	}
+
+	ct = eval.homomorphicDFT(ct, stcMatrices)
+
+	level := ct.Level()
+	for i := range ctOut.Value {
+		ring.CopyValuesLvl(level, ct.Value[i], ctOut.Value[i])
+		ctOut.Value[i].Coeffs = ctOut.Value[i].Coeffs[:level+1]
+	}
+	ctOut.Scale = ct.Scale
+}
+
+// homomorphicDFT evaluates the matrices of m on ctIn, each followed by a rescaling by the primes of its logical
+// level, and returns the result in a newly created element at the scale of ctIn.
+func (eval *Evaluator) homomorphicDFT(ctIn *ckks.Ciphertext, m HomomorphicDFTMatrix) (ctOut *ckks.Ciphertext) {
+
+	params := eval.params
+
+	if ctIn.Degree() != 1 {
+		panic("cannot evaluate the homomorphic DFT: input Ciphertext must be of degree 1")
+	}
+
+	if params.LogicalLevel(ctIn.Level()) < m.LogicalLevel {
+		panic("cannot evaluate the homomorphic DFT: the Ciphertext is below the logical level of the matrices")
+	}
+
+	ctOut = ctIn
+	for _, lt := range m.Matrices {
+		ct := ckks.NewCiphertext(params.Parameters, 1, utils.MinInt(ctOut.Level(), lt.Level), ctOut.Scale)
+		eval.linearTransform(ctOut, lt, ct)
+		eval.rescaleLogicalLevel(ct, ct)
+		ctOut = ct
+	}
+
+	return
+}
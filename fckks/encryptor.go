@@ -3,6 +3,7 @@ package fckks
 import (
 	"fast-ksw/ckks"
 	"fast-ksw/rlwe"
+	"fast-ksw/utils"
 )
 
 type Encryptor struct {
@@ -13,10 +14,19 @@ type Encryptor struct {
 }
 
 func NewEncryptor(params Parameters, pk *rlwe.PublicKey) (enc *Encryptor) {
+	return newEncryptor(params, ckks.NewEncryptor(params.Parameters, pk))
+}
+
+// NewEncryptorWithPRNG creates a new Encryptor sampling all its randomness from the provided PRNG.
+func NewEncryptorWithPRNG(params Parameters, pk *rlwe.PublicKey, prng utils.PRNG) (enc *Encryptor) {
+	return newEncryptor(params, ckks.NewEncryptorWithPRNG(params.Parameters, pk, prng))
+}
+
+func newEncryptor(params Parameters, ckksEnc ckks.Encryptor) (enc *Encryptor) {
 	enc = new(Encryptor)
 	enc.params = params
 	enc.ptxtPool = ckks.NewPlaintext(params.Parameters, params.MaxLevel(), params.DefaultScale())
-	enc.Encryptor = ckks.NewEncryptor(params.Parameters, pk)
+	enc.Encryptor = ckksEnc
 	enc.encoder = ckks.NewEncoder(params.Parameters)
 
 	return
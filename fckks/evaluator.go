@@ -9,107 +9,263 @@ import (
 	//"math/bits"
 )
 
+// Evaluator implements ckks.Evaluator. It wraps a ckks.Evaluator whose key-switching backend is a
+// frlwe.KeySwitcher, so that relinearizations, rotations, conjugations and key switches are all
+// done in the auxiliary modulus T with the keys of the frlwe.EvaluationKey. Additions bring their
+// operands to the same scale, and multiplications are rescaled according to the RescalingPolicy.
 type Evaluator struct {
 	ckks.Evaluator
 	params    Parameters
 	ksw       *frlwe.KeySwitcher
+	evks      rlwe.EvaluationKeySet
+	kgen      *frlwe.KeyGenerator
+	swks      map[*rlwe.SwitchingKey][2]*frlwe.SwitchingKey
+	ek        *frlwe.EncapsulationKey
 	polyQPool [4]*ring.Poly
+	ctxPool   *ckks.Ciphertext
+	costs     *CostTable
+
+	policy     RescalingPolicy
+	minScale   float64
+	ctxAlign   *ckks.Ciphertext
+	ctxRescale [2]*ckks.Ciphertext
+	ctxRotate  [2]*ckks.Ciphertext
+
+	permuteNTTIndex map[uint64][]uint64
+	ltBuffers       *linearTransformBuffers
+}
+
+// NewEvaluator creates a new Evaluator using the keys of evk. Fields of evk can be left nil,
+// in which case the operations requiring them panic.
+func NewEvaluator(params Parameters, evk frlwe.EvaluationKey) (eval *Evaluator) {
+	eval = newEvaluator(params, nil, frlwe.NewEvaluationKeySet(params.frlweParams, evk))
+	eval.ek = evk.Ek
+	return
 }
 
-func NewEvaluator(params Parameters) (eval *Evaluator) {
+// newEvaluator creates a new Evaluator whose embedded ckks.Evaluator uses the backend ksw with the keys
+// of evks. If ksw is nil, the frlwe.KeySwitcher of the Evaluator is used.
+func newEvaluator(params Parameters, ksw rlwe.KeySwitchingBackend, evks rlwe.EvaluationKeySet) (eval *Evaluator) {
 	eval = new(Evaluator)
 	eval.params = params
 	eval.ksw = frlwe.NewKeySwitcher(params.frlweParams)
-	evalKey := rlwe.EvaluationKey{nil, nil}
-	eval.Evaluator = ckks.NewEvaluator(params.Parameters, evalKey)
+	eval.evks = evks
+
+	if ksw == nil {
+		ksw = eval.ksw
+	}
+
+	eval.Evaluator = ckks.NewEvaluatorWithKeySwitcher(params.Parameters, ksw, evks)
+
+	eval.permuteNTTIndex = make(map[uint64][]uint64)
+	if evks != nil {
+		for _, galEl := range evks.GaloisElements() {
+			eval.permuteNTTIndex[galEl] = params.RingQ().PermuteNTTIndex(galEl)
+		}
+	}
 
 	for i := 0; i < len(eval.polyQPool); i++ {
 		eval.polyQPool[i] = params.RingQ().NewPoly()
 	}
 
+	eval.ctxPool = ckks.NewCiphertext(params.Parameters, 2, params.MaxLevel(), params.DefaultScale())
+	eval.ctxAlign = ckks.NewCiphertext(params.Parameters, 2, params.MaxLevel(), params.DefaultScale())
+	eval.ctxRescale[0] = ckks.NewCiphertext(params.Parameters, 1, params.MaxLevel(), params.DefaultScale())
+	eval.ctxRescale[1] = ckks.NewCiphertext(params.Parameters, 1, params.MaxLevel(), params.DefaultScale())
+	eval.ctxRotate[0] = ckks.NewCiphertext(params.Parameters, 1, params.MaxLevel(), params.DefaultScale())
+	eval.ctxRotate[1] = ckks.NewCiphertext(params.Parameters, 1, params.MaxLevel(), params.DefaultScale())
+
+	eval.policy = RescaleManual
+	eval.minScale = params.DefaultScale()
+
 	return
 }
 
-func (eval *Evaluator) AddNew(op0, op1 ckks.Operand) *ckks.Ciphertext {
-	return eval.Evaluator.AddNew(op0, op1)
-}
+// switchKeyNTT switches the key of c1, given in the NTT domain, and writes the result in the NTT domain on c0Out and c1Out.
+func (eval *Evaluator) switchKeyNTT(level int, c1 *ring.Poly, swk [2]*frlwe.SwitchingKey, c0Out, c1Out *ring.Poly) {
 
-func (eval *Evaluator) Add(op0, op1 ckks.Operand, ctOut *ckks.Ciphertext) {
-	eval.Evaluator.Add(op0, op1, ctOut)
-}
+	ringQ := eval.params.RingQ()
+
+	ringQ.InvNTTLvl(level, c1, eval.polyQPool[0])
+	eval.polyQPool[0].IsNTT = false
 
-func (eval *Evaluator) SubNew(op0, op1 ckks.Operand) *ckks.Ciphertext {
-	return eval.Evaluator.SubNew(op0, op1)
+	eval.ksw.SwitchKey(level, eval.polyQPool[0], swk[0], swk[1], c0Out, c1Out)
+
+	ringQ.NTTLvl(level, c0Out, c0Out)
+	ringQ.NTTLvl(level, c1Out, c1Out)
 }
 
-func (eval *Evaluator) Sub(op0, op1 ckks.Operand, ctOut *ckks.Ciphertext) {
-	eval.Evaluator.Sub(op0, op1, ctOut)
+// MulRelinAndAdd multiplies op0 with op1 with relinearization and adds the result on ctOut.
+// The product and ctOut are brought to the same scale as in Add. The product is not rescaled, but
+// its operands are with the RescaleLazy policy.
+// The procedure will panic if the evaluator was not created with a relinearization key.
+func (eval *Evaluator) MulRelinAndAdd(op0, op1 ckks.Operand, ctOut *ckks.Ciphertext) {
+
+	op0, op1 = eval.rescaleOperands(op0, op1)
+
+	if op0.Degree()+op1.Degree() < 2 {
+		eval.Evaluator.MulAndAdd(op0, op1, ctOut)
+		return
+	}
+
+	level := utils.MinInt(utils.MinInt(op0.Level(), op1.Level()), ctOut.Level())
+
+	ctTmp := ciphertextAtLevel(eval.ctxPool, 2, level)
+	eval.Evaluator.Mul(op0, op1, ctTmp)
+	eval.Relinearize(ctTmp, ctTmp)
+
+	eval.Add(ctOut, ctTmp, ctOut)
 }
 
-func (eval *Evaluator) MulRelinNew(ct0, ct1 *ckks.Ciphertext, rlk *frlwe.RelinKey) (ctOut *ckks.Ciphertext) {
-	ctOut = ckks.NewCiphertext(eval.params.Parameters, 2, utils.MinInt(ct0.Level(), ct1.Level()), 0)
-	eval.MulRelin(ct0, ct1, rlk, ctOut)
+// RotateWithKeyNew rotates ct0 with the rotation key rtk and returns the result in a newly created element.
+func (eval *Evaluator) RotateWithKeyNew(ct0 *ckks.Ciphertext, rtk *frlwe.RotationKey) (ctOut *ckks.Ciphertext) {
+	ctOut = ckks.NewCiphertext(eval.params.Parameters, 1, ct0.Level(), ct0.Scale)
+	eval.RotateWithKey(ct0, rtk, ctOut)
 	return
 }
 
-func (eval *Evaluator) MulRelin(ct0, ct1 *ckks.Ciphertext, rlk *frlwe.RelinKey, ctOut *ckks.Ciphertext) {
-	eval.Evaluator.Mul(ct0, ct1, ctOut)
+// RotateWithKey rotates ct0 with the rotation key rtk and returns the result in ctOut. rtk being a frlwe key,
+// the key switch is always done by the frlwe.KeySwitcher, including on a hybrid Evaluator.
+func (eval *Evaluator) RotateWithKey(ct0 *ckks.Ciphertext, rtk *frlwe.RotationKey, ctOut *ckks.Ciphertext) {
+	galEl := eval.params.GaloisElementForColumnRotationBy(int(rtk.Rotidx))
+	eval.permuteNTT(ct0, galEl, rtk.Value, ctOut)
+}
+
+// permuteNTT applies the automorphism galEl to ct0 with the frlwe switching key swk and returns the result in ctOut.
+func (eval *Evaluator) permuteNTT(ct0 *ckks.Ciphertext, galEl uint64, swk [2]*frlwe.SwitchingKey, ctOut *ckks.Ciphertext) {
+
+	if ct0.Degree() != 1 || ctOut.Degree() != 1 {
+		panic("cannot Rotate: input and output Ciphertext must be of degree 1")
+	}
 
+	level := utils.MinInt(ct0.Level(), ctOut.Level())
 	ringQ := eval.params.RingQ()
-	ringQ.InvNTTLvl(ctOut.Level(), ctOut.Value[2], eval.polyQPool[0])
 
-	eval.ksw.SwitchKey(ctOut.Level(), eval.polyQPool[0], rlk.Value[0], rlk.Value[1], eval.polyQPool[1], eval.polyQPool[2])
+	eval.switchKeyNTT(level, ct0.Value[1], swk, eval.polyQPool[1], eval.polyQPool[2])
 
-	ringQ.NTTLvl(ctOut.Level(), eval.polyQPool[1], eval.polyQPool[1])
-	ringQ.NTTLvl(ctOut.Level(), eval.polyQPool[2], eval.polyQPool[2])
+	ringQ.AddLvl(level, ct0.Value[0], eval.polyQPool[1], eval.polyQPool[1])
 
-	ringQ.AddLvl(ctOut.Level(), eval.polyQPool[1], ctOut.Value[0], ctOut.Value[0])
-	ringQ.AddLvl(ctOut.Level(), eval.polyQPool[2], ctOut.Value[1], ctOut.Value[1])
+	ringQ.PermuteNTTLvl(level, eval.polyQPool[1], galEl, ctOut.Value[0])
+	ringQ.PermuteNTTLvl(level, eval.polyQPool[2], galEl, ctOut.Value[1])
 
-	ctOut.Value = ctOut.Value[:2]
+	ctOut.Scale = ct0.Scale
 }
 
-func (eval *Evaluator) RotateNew(ctIn *ckks.Ciphertext, rtk *frlwe.RotationKey) (ctOut *ckks.Ciphertext) {
-	ctOut = ckks.NewCiphertext(eval.params.Parameters, 1, ctIn.Level(), ctIn.Scale)
-	eval.Rotate(ctIn, rtk, ctOut)
+// SwitchKeysNew re-encrypts ct0 under a different key with the rlwe switching key swk and returns the
+// result in a newly created element.
+func (eval *Evaluator) SwitchKeysNew(ct0 *ckks.Ciphertext, swk *rlwe.SwitchingKey) (ctOut *ckks.Ciphertext) {
+	ctOut = ckks.NewCiphertext(eval.params.Parameters, ct0.Degree(), ct0.Level(), ct0.Scale)
+	eval.SwitchKeys(ct0, swk, ctOut)
 	return
 }
 
-func (eval *Evaluator) Rotate(ctIn *ckks.Ciphertext, rtk *frlwe.RotationKey, ctOut *ckks.Ciphertext) {
+// SwitchKeys re-encrypts ct0 under a different key with the rlwe switching key swk and returns the
+// result in ctOut. swk is converted to the T basis on its first use with ConvertSwitchingKey, and the conversion is
+// kept by the Evaluator until ReleaseSwitchingKey(swk).
+func (eval *Evaluator) SwitchKeys(ct0 *ckks.Ciphertext, swk *rlwe.SwitchingKey, ctOut *ckks.Ciphertext) {
 
-	ringQ := eval.params.RingQ()
+	swkFast, ok := eval.swks[swk]
+	if !ok {
+		swkFast = eval.ConvertSwitchingKey(swk)
+	}
 
-	ringQ.InvNTTLvl(ctOut.Level(), ctIn.Value[1], eval.polyQPool[0])
+	eval.SwitchKeysFast(ct0, swkFast, ctOut)
+}
 
-	eval.ksw.SwitchKey(ctOut.Level(), eval.polyQPool[0], rtk.Value[0], rtk.Value[1], eval.polyQPool[1], eval.polyQPool[2])
+// ConvertSwitchingKey converts the rlwe switching key swk to the T basis, for ciphertexts of any level, and
+// records the conversion for the calls of SwitchKeys with swk. It returns the converted key, which can also be used
+// with SwitchKeysFast.
+func (eval *Evaluator) ConvertSwitchingKey(swk *rlwe.SwitchingKey) (swkFast [2]*frlwe.SwitchingKey) {
 
-	ringQ.NTTLvl(ctOut.Level(), eval.polyQPool[1], eval.polyQPool[1])
-	ringQ.NTTLvl(ctOut.Level(), eval.polyQPool[2], eval.polyQPool[2])
+	if eval.kgen == nil {
+		eval.kgen = frlwe.NewKeyGenerator(eval.params.frlweParams)
+		eval.swks = make(map[*rlwe.SwitchingKey][2]*frlwe.SwitchingKey)
+	}
 
-	ringQ.AddLvl(ctOut.Level(), ctIn.Value[0], eval.polyQPool[1], eval.polyQPool[1])
+	swkFast = eval.kgen.ConvertSwitchingKey(eval.params.MaxLevel(), swk)
+	eval.swks[swk] = swkFast
 
-	// permute ctOut
+	return
+}
 
-	galEl := eval.params.GaloisElementForColumnRotationBy(int(rtk.Rotidx))
-	ringQ.PermuteNTTLvl(ctOut.Level(), eval.polyQPool[1], galEl, ctOut.Value[0])
-	ringQ.PermuteNTTLvl(ctOut.Level(), eval.polyQPool[2], galEl, ctOut.Value[1])
+// ReleaseSwitchingKey discards the conversion of swk recorded by ConvertSwitchingKey or SwitchKeys, if any.
+func (eval *Evaluator) ReleaseSwitchingKey(swk *rlwe.SwitchingKey) {
+	delete(eval.swks, swk)
 }
 
-func (eval *Evaluator) SwitchKeys(ct0 *ckks.Ciphertext, swk [2]*frlwe.SwitchingKey, ctOut *ckks.Ciphertext) {
+// SwitchKeysFast re-encrypts ct0 under a different key with the frlwe switching key swk and returns
+// the result in ctOut. ct0 can be given in the NTT or in the coefficient domain, and ctOut is
+// returned in the same domain. swk being a frlwe key, the key switch is always done by the
+// frlwe.KeySwitcher, including on a hybrid Evaluator.
+func (eval *Evaluator) SwitchKeysFast(ct0 *ckks.Ciphertext, swk [2]*frlwe.SwitchingKey, ctOut *ckks.Ciphertext) {
+
+	if ct0.Degree() != 1 || ctOut.Degree() != 1 {
+		panic("cannot SwitchKeys: input and output Ciphertext must be of degree 1")
+	}
 
 	level := utils.MinInt(ct0.Level(), ctOut.Level())
 	ringQ := eval.params.RingQ()
 
 	ctOut.Scale = ct0.Scale
 
-	eval.ksw.SwitchKey(level, ct0.Value[1], swk[0], swk[1], eval.polyQPool[1], eval.polyQPool[2])
+	if ct0.Value[1].IsNTT {
+		eval.switchKeyNTT(level, ct0.Value[1], swk, eval.polyQPool[1], eval.polyQPool[2])
+	} else {
+		eval.ksw.SwitchKey(level, ct0.Value[1], swk[0], swk[1], eval.polyQPool[1], eval.polyQPool[2])
+	}
 
 	ringQ.AddLvl(level, ct0.Value[0], eval.polyQPool[1], ctOut.Value[0])
 	ring.CopyValuesLvl(level, eval.polyQPool[2], ctOut.Value[1])
+	ctOut.Value[0].IsNTT, ctOut.Value[1].IsNTT = ct0.Value[0].IsNTT, ct0.Value[1].IsNTT
 }
 
-func (eval *Evaluator) SwitchKeysNew(ct0 *ckks.Ciphertext, swk [2]*frlwe.SwitchingKey) (ctOut *ckks.Ciphertext) {
+// SwitchKeysFastNew re-encrypts ct0 under a different key with the frlwe switching key swk and returns
+// the result in a newly created element.
+func (eval *Evaluator) SwitchKeysFastNew(ct0 *ckks.Ciphertext, swk [2]*frlwe.SwitchingKey) (ctOut *ckks.Ciphertext) {
 	ctOut = ckks.NewCiphertext(eval.params.Parameters, ct0.Degree(), ct0.Level(), ct0.Scale)
-	eval.SwitchKeys(ct0, swk, ctOut)
+	eval.SwitchKeysFast(ct0, swk, ctOut)
+	return
+}
+
+// SwitchToSparseNew re-encrypts ct0 under the ephemeral sparse secret of the encapsulation key and returns the
+// result at level 0 in a newly created element.
+func (eval *Evaluator) SwitchToSparseNew(ct0 *ckks.Ciphertext) (ctOut *ckks.Ciphertext) {
+	ctOut = ckks.NewCiphertext(eval.params.Parameters, 1, 0, ct0.Scale)
+	eval.SwitchToSparse(ct0, ctOut)
 	return
 }
+
+// SwitchToSparse re-encrypts ct0 under the ephemeral sparse secret of the encapsulation key and returns the
+// result in ctOut, which must be at level 0: the key only switches the first prime, which is all the
+// bootstrapping keeps before the ModRaise.
+func (eval *Evaluator) SwitchToSparse(ct0 *ckks.Ciphertext, ctOut *ckks.Ciphertext) {
+
+	if eval.ek == nil {
+		panic("cannot SwitchToSparse: the encapsulation key is missing")
+	}
+
+	if ctOut.Level() != 0 {
+		panic("cannot SwitchToSparse: the output Ciphertext must be at level 0")
+	}
+
+	eval.SwitchKeysFast(ct0, eval.ek.DenseToSparse, ctOut)
+}
+
+// SwitchToDenseNew re-encrypts ct0, encrypted under the ephemeral sparse secret of the encapsulation key, under
+// the secret of the Evaluator, and returns the result in a newly created element.
+func (eval *Evaluator) SwitchToDenseNew(ct0 *ckks.Ciphertext) (ctOut *ckks.Ciphertext) {
+	ctOut = ckks.NewCiphertext(eval.params.Parameters, 1, ct0.Level(), ct0.Scale)
+	eval.SwitchToDense(ct0, ctOut)
+	return
+}
+
+// SwitchToDense re-encrypts ct0, encrypted under the ephemeral sparse secret of the encapsulation key, under the
+// secret of the Evaluator, and returns the result in ctOut.
+func (eval *Evaluator) SwitchToDense(ct0 *ckks.Ciphertext, ctOut *ckks.Ciphertext) {
+
+	if eval.ek == nil {
+		panic("cannot SwitchToDense: the encapsulation key is missing")
+	}
+
+	eval.SwitchKeysFast(ct0, eval.ek.SparseToDense, ctOut)
+}
@@ -3,6 +3,10 @@ package fckks
 import (
 	"fmt"
 	"testing"
+
+	"fast-ksw/ckks"
+	"fast-ksw/frlwe"
+	"fast-ksw/utils"
 )
 
 func BenchmarkFCKKS(b *testing.B) {
@@ -18,6 +22,7 @@ func BenchmarkFCKKS(b *testing.B) {
 
 		benchMulOld(testctx, b)
 		benchMulNew(testctx, b)
+		benchMulRelinRescale(testctx, b)
 
 		benchRotNew(testctx, b)
 		benchRotOld(testctx, b)
@@ -29,19 +34,41 @@ func BenchmarkFCKKS(b *testing.B) {
 
 func benchMulNew(testctx *testContext, b *testing.B) {
 	eval := testctx.eval
-	rlk := testctx.rlk
 
 	_, ct0 := newTestVectors(testctx, complex(-1, -1), complex(1, 1))
 	_, ct1 := newTestVectors(testctx, complex(-1, -1), complex(1, 1))
 
 	b.Run(fmt.Sprintf("MulNew logN:%d logQP:%d", testctx.params.LogN(), testctx.params.LogQP()), func(b *testing.B) {
 		for i := 0; i < b.N; i++ {
-			eval.MulRelinNew(ct0, ct1, rlk)
+			eval.MulRelinNew(ct0, ct1)
 		}
 
 	})
 }
 
+func benchMulRelinRescale(testctx *testContext, b *testing.B) {
+	eval := testctx.eval
+
+	_, ct0 := newTestVectors(testctx, complex(-1, -1), complex(1, 1))
+	_, ct1 := newTestVectors(testctx, complex(-1, -1), complex(1, 1))
+
+	b.Run(fmt.Sprintf("MulRelinThenRescale logN:%d logQP:%d", testctx.params.LogN(), testctx.params.LogQP()), func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := eval.MulRelinThenRescaleNew(ct0, ct1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run(fmt.Sprintf("MulRelinRescale logN:%d logQP:%d", testctx.params.LogN(), testctx.params.LogQP()), func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := eval.MulRelinRescaleNew(ct0, ct1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
 func benchRotNew(testctx *testContext, b *testing.B) {
 	eval := testctx.eval
 	rtk := testctx.rtk
@@ -50,7 +77,7 @@ func benchRotNew(testctx *testContext, b *testing.B) {
 
 	b.Run(fmt.Sprintf("RotateNew logN:%d logQP:%d", testctx.params.LogN(), testctx.params.LogQP()), func(b *testing.B) {
 		for i := 0; i < b.N; i++ {
-			eval.RotateNew(ct0, rtk)
+			eval.RotateWithKeyNew(ct0, rtk)
 		}
 
 	})
@@ -96,7 +123,7 @@ func benchKeySwitchNew(testctx *testContext, b *testing.B) {
 
 	b.Run(fmt.Sprintf("KeySwitchNew logN:%d logQP:%d", testctx.params.LogN(), testctx.params.LogQP()), func(b *testing.B) {
 		for i := 0; i < b.N; i++ {
-			eval.SwitchKeysNew(ct0, rlk.Value)
+			eval.SwitchKeysFastNew(ct0, rlk.Value)
 		}
 	})
 }
@@ -119,3 +146,67 @@ func benchKeySwtichOld(testctx *testContext, b *testing.B) {
 		}
 	})
 }
+
+func BenchmarkLinearTransform(b *testing.B) {
+
+	params := NewParametersFromLiteral(PN14QP470C2)
+	testctx, err := genTestParams(params)
+	if err != nil {
+		panic(err)
+	}
+
+	slots := params.Slots()
+	ecd := ckks.NewEncoder(params.Parameters)
+
+	diagonals := make(map[int][]complex128)
+	for k := 0; k < 16; k++ {
+		diagonals[k] = make([]complex128, slots)
+		for i := range diagonals[k] {
+			diagonals[k][i] = complex(utils.RandFloat64(-1, 1), utils.RandFloat64(-1, 1))
+		}
+	}
+
+	lt := NewLinearTransform(params, ecd, diagonals, params.MaxLevel(), params.DefaultScale(), params.LogSlots())
+	ltHoisted := NewLinearTransformHoisted(params, ecd, diagonals, params.MaxLevel(), params.DefaultScale(), params.LogSlots())
+
+	rotations := lt.Rotations()
+	for _, k := range ltHoisted.Rotations() {
+		if !utils.IsInSliceInt(k, rotations) {
+			rotations = append(rotations, k)
+		}
+	}
+
+	eval := NewEvaluator(params, frlwe.EvaluationKey{Rtks: testctx.kgen.GenRotKeys(rotations, testctx.sk)})
+
+	_, ct := newTestVectors(testctx, complex(-1, -1), complex(1, 1))
+	ctOut := ckks.NewCiphertext(params.Parameters, 1, ct.Level(), ct.Scale)
+
+	b.Run(fmt.Sprintf("PerRotation logN:%d logQP:%d", params.LogN(), params.LogQP()), func(b *testing.B) {
+		pts := make(map[int]*ckks.Plaintext)
+		for k, diag := range diagonals {
+			pts[k] = ecd.EncodeNew(diag, params.MaxLevel(), params.DefaultScale(), params.LogSlots())
+		}
+		ctRot := ckks.NewCiphertext(params.Parameters, 1, ct.Level(), ct.Scale)
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			eval.Evaluator.Mul(ct, pts[0], ctOut)
+			for k := 1; k < len(diagonals); k++ {
+				eval.Rotate(ct, k, ctRot)
+				eval.Evaluator.MulAndAdd(ctRot, pts[k], ctOut)
+			}
+		}
+	})
+
+	b.Run(fmt.Sprintf("BSGS logN:%d logQP:%d", params.LogN(), params.LogQP()), func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			eval.LinearTransform(ct, lt, ctOut)
+		}
+	})
+
+	b.Run(fmt.Sprintf("Hoisted logN:%d logQP:%d", params.LogN(), params.LogQP()), func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			eval.LinearTransformHoisted(ct, ltHoisted, ctOut)
+		}
+	})
+}
@@ -1,7 +1,12 @@
 package fckks
 
 import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
 	"math"
+	"math/big"
+	"math/cmplx"
 	"testing"
 
 	"fast-ksw/frlwe"
@@ -12,6 +17,7 @@ import (
 	"fast-ksw/utils"
 
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2b"
 )
 
 var (
@@ -85,6 +91,133 @@ var (
 		Gamma:        5,
 		// 1:3:3 2:4:4 4:5:6 6:7:9 8:8:10 10:9:12
 	}
+
+	PN14QP470CI = ParametersLiteral{
+		LogN: 14,
+		Q: []uint64{ // 36 x 12
+			0xffff00001, 0xfff9c0001, 0xfff8e0001, 0xfff840001,
+			0xfff700001, 0xfff640001, 0xfff4c0001, 0xfff3c0001,
+			0xfff280001, 0xfff100001, 0xffefe0001, 0xffee80001,
+		},
+		P: []uint64{ // 36 x 1
+			0x1002700001,
+		},
+		T: []uint64{ // 60 x 3
+			0xffffffffffc0001, 0xfffffffff840001,
+			0xfffffffff6a0001,
+		},
+
+		Sigma:        rlwe.DefaultSigma,
+		DefaultScale: 1 << 36,
+		LogSlots:     14,
+		Gamma:        3,
+		RingType:     ring.ConjugateInvariant,
+	}
+
+	// PN14QP470C2 uses the moduli of PN14QP470CI with logical levels of two 36-bit primes.
+	PN14QP470C2 = ParametersLiteral{
+		LogN: 14,
+		Q: []uint64{ // 72 x 6
+			0xffff00001, 0xfff9c0001, 0xfff8e0001, 0xfff840001,
+			0xfff700001, 0xfff640001, 0xfff4c0001, 0xfff3c0001,
+			0xfff280001, 0xfff100001, 0xffefe0001, 0xffee80001,
+		},
+		P: []uint64{ // 36 x 1
+			0x1002700001,
+		},
+		T: []uint64{ // 60 x 3
+			0xffffffffffc0001, 0xfffffffff840001,
+			0xfffffffff6a0001,
+		},
+
+		Sigma:          rlwe.DefaultSigma,
+		DefaultScale:   1 << 72,
+		LogSlots:       13,
+		Gamma:          3,
+		PrimesPerLevel: 2,
+	}
+
+	// PN12QP671 is used by the tests of the comparisons and of the inverses, with 14 logical levels of 40 bits.
+	PN12QP671 = ParametersLiteral{
+		LogN: 12,
+		Q: []uint64{
+			0x4000000120001, // 50
+
+			0x10000140001, 0xffffe80001, 0xffffc40001, 0x100003e0001, 0xffffb20001, // 40 x 14
+			0x10000500001, 0xffff940001, 0xffff8a0001, 0xffff820001, 0xffff780001,
+			0x10000960001, 0x10000a40001, 0xffff580001, 0x10000b60001,
+		},
+		P: []uint64{ // 61 x 1
+			0x1fffffffffe00001,
+		},
+		T: []uint64{ // 60 x 4
+			0xffffffffffc0001, 0xfffffffff840001, 0xfffffffff6a0001, 0xfffffffff5a0001,
+		},
+		Sigma:        rlwe.DefaultSigma,
+		DefaultScale: 1 << 40,
+		LogSlots:     11,
+		Gamma:        2,
+		RingType:     ring.Standard,
+	}
+
+	// PN12QP342 is used by the tests of the homomorphic DFT and of the matrix products, with 6 logical levels of 40 bits.
+	PN12QP342 = ParametersLiteral{
+		LogN: 12,
+		Q: []uint64{
+			0x4000000120001, // 50
+
+			0x10000140001, 0xffffe80001, 0xffffc40001, // 40 x 6
+			0x100003e0001, 0xffffb20001, 0x10000500001,
+		},
+		P: []uint64{ // 61 x 1
+			0x1fffffffffe00001,
+		},
+		T: []uint64{ // 60 x 4
+			0xffffffffffc0001, 0xfffffffff840001, 0xfffffffff6a0001, 0xfffffffff5a0001,
+		},
+		Sigma:        rlwe.DefaultSigma,
+		DefaultScale: 1 << 40,
+		LogSlots:     11,
+		Gamma:        2,
+		RingType:     ring.Standard,
+	}
+
+	// PN13QP590BTP is a small bootstrapping parameter set for the tests: 2^4 slots, a secret of Hamming
+	// weight 64 and 2 logical levels left after the bootstrapping.
+	PN13QP590BTP = BootstrappingParametersSet{
+		SchemeParams: ParametersLiteral{
+			LogN: 13,
+			Q: []uint64{
+				0x4000000120001, // 50, Q[0]
+
+				0x10000140001, 0xffffe80001, // 40 x 2, circuit
+
+				0xffffc40001, // 40, SlotsToCoeffs
+
+				0x2000000a0001, 0x2000000e0001, 0x1fffffc20001, 0x200000440001, // 45 x 8, EvalMod
+				0x200000500001, 0x200000620001, 0x1fffff980001, 0x2000006a0001,
+
+				0x10000000006e0001, // 60, CoeffsToSlots
+			},
+			P: []uint64{ // 61 x 1
+				0x1fffffffffe00001,
+			},
+			T: []uint64{ // 60 x 4
+				0xffffffffffc0001, 0xfffffffff840001, 0xfffffffff6a0001, 0xfffffffff5a0001,
+			},
+			Sigma:        rlwe.DefaultSigma,
+			DefaultScale: 1 << 40,
+			LogSlots:     4,
+			H:            64,
+			Gamma:        2,
+			RingType:     ring.Standard,
+		},
+		BootstrappingParams: BootstrappingParameters{
+			K:           12,
+			SineDegree:  31,
+			DoubleAngle: 3,
+		},
+	}
 )
 
 type testContext struct {
@@ -95,12 +228,17 @@ type testContext struct {
 	pk      *rlwe.PublicKey
 	rlk     *frlwe.RelinKey
 	rtk     *frlwe.RotationKey
+	cjk     *frlwe.ConjugationKey
 	enc     *Encryptor
 	dec     *Decryptor
+	evk     frlwe.EvaluationKey
+	evkOld  rlwe.EvaluationKey
 	eval    *Evaluator
 	evalOld ckks.Evaluator
 }
 
+var _ ckks.Evaluator = new(Evaluator)
+
 func genTestParams(params Parameters) (testctx *testContext, err error) {
 
 	testctx = new(testContext)
@@ -114,13 +252,51 @@ func genTestParams(params Parameters) (testctx *testContext, err error) {
 	testctx.rlk = testctx.kgen.GenRelinKey(testctx.sk)
 	testctx.rtk = testctx.kgen.GenRotKey(1, testctx.sk)
 
+	rtks := frlwe.NewRotationKeySet()
+	rtks.Add(testctx.rtk)
+
+	if params.RingType() == ring.Standard {
+		testctx.cjk = testctx.kgen.GenConjugationKey(testctx.sk)
+	}
+
 	testctx.enc = NewEncryptor(testctx.params, testctx.pk)
 	testctx.dec = NewDecryptor(testctx.params, testctx.sk)
-	testctx.eval = NewEvaluator(testctx.params)
+	testctx.evk = frlwe.EvaluationKey{Rlk: testctx.rlk, Rtks: rtks, Cjk: testctx.cjk}
+	testctx.eval = NewEvaluator(testctx.params, testctx.evk)
 
 	rlkOld := testctx.kgen.KeyGenerator.GenRelinearizationKey(testctx.sk, 1)
-	rtkOld := testctx.kgen.KeyGenerator.GenRotationKeysForInnerSum(testctx.sk)
-	testctx.evalOld = ckks.NewEvaluator(testctx.params.Parameters, rlwe.EvaluationKey{Rlk: rlkOld, Rtks: rtkOld})
+	rtkOld := testctx.kgen.KeyGenerator.GenRotationKeysForRotations([]int{1}, false, testctx.sk)
+	testctx.evkOld = rlwe.EvaluationKey{Rlk: rlkOld, Rtks: rtkOld}
+	testctx.evalOld = ckks.NewEvaluator(testctx.params.Parameters, testctx.evkOld)
+
+	return
+}
+
+// genTestContextWithKeys creates a testContext whose Evaluator has the rotation keys of rotations, generated once
+// each, and the relinearization key if withRlk.
+func genTestContextWithKeys(params Parameters, rotations []int, withRlk bool) (testctx *testContext) {
+
+	testctx = new(testContext)
+	testctx.params = params
+	testctx.ringQ = params.RingQ()
+	testctx.kgen = NewKeyGenerator(params)
+	testctx.sk, testctx.pk = testctx.kgen.GenKeyPair()
+	testctx.enc = NewEncryptor(params, testctx.pk)
+	testctx.dec = NewDecryptor(params, testctx.sk)
+
+	distinct := []int{}
+	for _, k := range rotations {
+		if !utils.IsInSliceInt(k, distinct) {
+			distinct = append(distinct, k)
+		}
+	}
+
+	testctx.evk.Rtks = testctx.kgen.GenRotKeys(distinct, testctx.sk)
+	if withRlk {
+		testctx.rlk = testctx.kgen.GenRelinKey(testctx.sk)
+		testctx.evk.Rlk = testctx.rlk
+	}
+	testctx.eval = NewEvaluator(params, testctx.evk)
 
 	return
 }
@@ -133,7 +309,11 @@ func newTestVectors(testctx *testContext, a, b complex128) (msg *Message, cipher
 	msg = NewMessage(params)
 
 	for i := 0; i < 1<<logSlots; i++ {
-		msg.Value[i] = complex(utils.RandFloat64(real(a), real(b)), utils.RandFloat64(imag(a), imag(b)))
+		if params.RingType() == ring.ConjugateInvariant {
+			msg.Value[i] = complex(utils.RandFloat64(real(a), real(b)), 0)
+		} else {
+			msg.Value[i] = complex(utils.RandFloat64(real(a), real(b)), utils.RandFloat64(imag(a), imag(b)))
+		}
 	}
 
 	ciphertext = testctx.enc.EncryptMsgNew(msg)
@@ -155,8 +335,196 @@ func TestFCKKS(t *testing.T) {
 
 		testEncrypt(testctx, t)
 		testEval(testctx, t)
+		testRescale(testctx, t)
+		testHybrid(testctx, t)
+		testPolynomialEvaluator(testctx, t)
+	}
+
+}
+
+func TestFCKKSConjugateInvariant(t *testing.T) {
+
+	params := NewParametersFromLiteral(PN14QP470CI)
+	testctx, err := genTestParams(params)
+	if err != nil {
+		panic(err)
+	}
+
+	testEncrypt(testctx, t)
+	testEval(testctx, t)
+	testRescale(testctx, t)
+	testHybrid(testctx, t)
+	testPolynomialEvaluator(testctx, t)
+	testInnerSum(testctx, t)
+	testLinearTransform(testctx, t)
+}
+
+func TestFCKKSCompositeLevels(t *testing.T) {
+
+	params := NewParametersFromLiteral(PN14QP470C2)
+	testctx, err := genTestParams(params)
+	if err != nil {
+		panic(err)
+	}
+
+	testCompositeLevels(testctx, t)
+	testPolynomialEvaluator(testctx, t)
+	testInnerSum(testctx, t)
+	testLinearTransform(testctx, t)
+}
+
+func TestFCKKSBootstrapping(t *testing.T) {
+
+	t.Run("ParametersSets", func(t *testing.T) {
+		params := NewParametersFromLiteral(BootstrappingPN16QP1127.SchemeParams)
+		require.LessOrEqual(t, BootstrappingPN16QP1127.BootstrappingParams.Depth(), params.MaxLogicalLevel())
+	})
+
+	// The same parameters with a dense ternary secret, bootstrapped with an ephemeral secret of Hamming weight 64
+	denseLiteral := PN13QP590BTP.SchemeParams
+	denseLiteral.SecretDistrib = frlwe.Ternary
+	denseLiteral.SecretProba = 1.0 / 3
+	denseBtpParams := PN13QP590BTP.BootstrappingParams
+	denseBtpParams.EphemeralSecretWeight = 64
+
+	for _, tc := range []struct {
+		name          string
+		paramsLiteral ParametersLiteral
+		btpParams     BootstrappingParameters
+	}{
+		{"SparseSecret", PN13QP590BTP.SchemeParams, PN13QP590BTP.BootstrappingParams},
+		{"EphemeralSecret", denseLiteral, denseBtpParams},
+	} {
+		params := NewParametersFromLiteral(tc.paramsLiteral)
+		require.Equal(t, params.MaxLogicalLevel()-tc.btpParams.Depth(), 2)
+
+		kgen := NewKeyGenerator(params)
+		sk, pk := kgen.GenKeyPair()
+		evk := frlwe.EvaluationKey{
+			Rlk:  kgen.GenRelinKey(sk),
+			Rtks: kgen.GenRotKeys(tc.btpParams.Rotations(params), sk),
+			Cjk:  kgen.GenConjugationKey(sk),
+		}
+
+		if tc.btpParams.EphemeralSecretWeight > 0 {
+			evk.Ek = kgen.GenEncapsulationKey(sk, kgen.GenSecretKeyWithHammingWeight(tc.btpParams.EphemeralSecretWeight))
+		}
+
+		testctx := &testContext{
+			params: params,
+			ringQ:  params.RingQ(),
+			kgen:   kgen,
+			sk:     sk,
+			pk:     pk,
+			enc:    NewEncryptor(params, pk),
+			dec:    NewDecryptor(params, sk),
+			evk:    evk,
+			eval:   NewEvaluator(params, evk),
+		}
+
+		t.Run(tc.name, func(t *testing.T) {
+			testBootstrapping(testctx, tc.btpParams, t)
+		})
+	}
+}
+
+func TestFCKKSHomomorphicDFT(t *testing.T) {
+
+	paramsLiteral := PN12QP342
+	params := NewParametersFromLiteral(paramsLiteral)
+
+	// The same ring with 2^4 slots
+	paramsLiteral.LogSlots = 4
+	paramsSparse := NewParametersFromLiteral(paramsLiteral)
+
+	testHomomorphicDFT(params, paramsSparse, t)
+}
+
+func TestFCKKSComparison(t *testing.T) {
+	params := NewParametersFromLiteral(PN12QP671)
+	testComparison(genTestContextWithKeys(params, params.RotationsForReduceMax(1, 4), true), t)
+}
+
+func TestFCKKSInverse(t *testing.T) {
+
+	params := NewParametersFromLiteral(PN12QP671)
+	testctx, err := genTestParams(params)
+	if err != nil {
+		panic(err)
+	}
+
+	testInverse(testctx, t)
+}
+
+func TestFCKKSMatrixMultiplication(t *testing.T) {
+
+	params := NewParametersFromLiteral(PN12QP342)
+
+	// Square and rectangular products, up to 45 x 45 matrices filling 2025 of the 2048 slots
+	sizes := [][3]int{{4, 4, 4}, {2, 3, 4}, {45, 45, 45}, {30, 45, 7}}
+
+	rotations := []int{}
+	for _, size := range sizes {
+		rotations = append(rotations, params.RotationsForMatrixMul(size[0], size[1], size[2])...)
+	}
+
+	testMatrixMultiplication(genTestContextWithKeys(params, rotations, true), sizes, t)
+}
+
+func TestFCKKSReshape(t *testing.T) {
+
+	params := NewParametersFromLiteral(PN12QP342)
+
+	// Square and rectangular matrices, up to a 45 x 45 matrix filling 2025 of the 2048 slots
+	sizes := [][2]int{{4, 4}, {3, 5}, {45, 45}, {30, 60}}
+
+	rotations := []int{}
+	for _, size := range sizes {
+		rows, cols := size[0], size[1]
+		rotations = append(rotations, params.RotationsForTranspose(rows, cols)...)
+		rotations = append(rotations, params.RotationsForRowToColumn(rows, cols)...)
+		rotations = append(rotations, params.RotationsForColumnToRow(rows, cols)...)
+		rotations = append(rotations, params.RotationsForReplicateRows(rows, cols)...)
+		rotations = append(rotations, params.RotationsForReplicateColumns(rows, cols)...)
+	}
+
+	testReshape(genTestContextWithKeys(params, rotations, false), sizes, t)
+}
+
+func TestFCKKSStatistics(t *testing.T) {
+	params := NewParametersFromLiteral(PN12QP671)
+	testStatistics(genTestContextWithKeys(params, params.RotationsForSum(), true), t)
+}
+
+// Known-answer hash of the encryption of a fixed message under PN15QP870, with the key generator
+// and the encryptor seeded with katSeedKeyGen and katSeedEncryptor.
+var (
+	katSeedKeyGen    = []byte("fast-ksw known-answer keygen seed")
+	katSeedEncryptor = []byte("fast-ksw known-answer encryptor seed")
+	katCiphertext    = "7206cbea147f726bba379aef808cd72329060ec2be9c9876633dabdaca940fe6"
+)
+
+func TestFCKKSKnownAnswer(t *testing.T) {
+
+	params := NewParametersFromLiteral(PN15QP870)
+
+	prngKeyGen, err := utils.NewKeyedPRNG(katSeedKeyGen)
+	require.NoError(t, err)
+	prngEncryptor, err := utils.NewKeyedPRNG(katSeedEncryptor)
+	require.NoError(t, err)
+
+	_, pk := NewKeyGeneratorWithPRNG(params, prngKeyGen).GenKeyPair()
+	enc := NewEncryptorWithPRNG(params, pk, prngEncryptor)
+
+	msg := NewMessage(params)
+	for i := range msg.Value {
+		msg.Value[i] = complex(float64(i%17)/16-0.5, float64(i%13)/12-0.5)
 	}
 
+	data, err := enc.EncryptMsgNew(msg).MarshalBinary()
+	require.NoError(t, err)
+	hash := blake2b.Sum256(data)
+	require.Equal(t, katCiphertext, hex.EncodeToString(hash[:]))
 }
 
 func testEncrypt(testctx *testContext, t *testing.T) {
@@ -174,6 +542,21 @@ func testEncrypt(testctx *testContext, t *testing.T) {
 			require.GreaterOrEqual(t, -math.Log2(params.DefaultScale())+float64(params.LogSlots())+8, math.Log2(math.Abs(imag(delta))))
 		}
 	})
+
+	t.Run("DecryptAfterLowerLevel", func(t *testing.T) {
+		// A constant whose encoding fits in the modulus of the logical level 0
+		q0, _ := new(big.Float).SetInt(params.QLvl(params.LevelQ(0))).Float64()
+		c := math.Min(0.5, q0/(16*params.DefaultScale()))
+		msg, ctxt := newTestVectors(testctx, complex(c, c), complex(c, c))
+		requireMessagesClose(t, params, msg.Value, dec.DecryptToMsgNew(testctx.eval.DropLevelNew(ctxt, params.MaxLogicalLevel())), 11)
+		requireMessagesClose(t, params, msg.Value, dec.DecryptToMsgNew(ctxt), 11)
+
+		// A constant whose encoding does not fit in the modulus of the logical level 0 does not corrupt the
+		// decryption of the following full-level ciphertext
+		msg, ctxt = newTestVectors(testctx, complex(4, 4), complex(4, 4))
+		dec.DecryptToMsgNew(testctx.eval.DropLevelNew(ctxt, params.MaxLogicalLevel()))
+		requireMessagesClose(t, params, msg.Value, dec.DecryptToMsgNew(ctxt), 11)
+	})
 }
 
 func testEval(testctx *testContext, t *testing.T) {
@@ -235,10 +618,21 @@ func testEval(testctx *testContext, t *testing.T) {
 		msg1, ct0 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
 		msg2, ct1 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
 
-		ctOut := eval.MulRelinNew(ct0, ct1, testctx.rlk)
+		ctOut := eval.MulRelinNew(ct0, ct1)
 
 		require.Equal(t, ctOut.Degree(), 1)
 
+		// The relinearized product is fully reduced modulo each prime
+		reduced := true
+		for _, pol := range ctOut.Value {
+			for i, qi := range params.RingQ().Modulus[:ctOut.Level()+1] {
+				for _, c := range pol.Coeffs[i] {
+					reduced = reduced && c < qi
+				}
+			}
+		}
+		require.True(t, reduced)
+
 		msgOut := dec.DecryptToMsgNew(ctOut)
 
 		for i := 0; i < slots; i++ {
@@ -251,7 +645,61 @@ func testEval(testctx *testContext, t *testing.T) {
 	t.Run("Rotate", func(t *testing.T) {
 		msg, ct := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
 		rot := int(testctx.rtk.Rotidx)
-		ctOut := eval.RotateNew(ct, testctx.rtk)
+		ctOut := eval.RotateNew(ct, rot)
+		msgOut := dec.DecryptToMsgNew(ctOut)
+
+		for i := 0; i < slots; i++ {
+			delta := msgOut.Value[i] - msg.Value[(i+rot)%slots]
+			require.GreaterOrEqual(t, -math.Log2(params.DefaultScale())+float64(params.LogSlots())+12, math.Log2(math.Abs(real(delta))))
+			require.GreaterOrEqual(t, -math.Log2(params.DefaultScale())+float64(params.LogSlots())+12, math.Log2(math.Abs(imag(delta))))
+		}
+	})
+
+	t.Run("KeySwitchingBackend", func(t *testing.T) {
+		require.IsType(t, &frlwe.KeySwitcher{}, eval.GetKeySwitcher())
+	})
+
+	t.Run("Relinearize", func(t *testing.T) {
+		msg1, ct0 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+		msg2, ct1 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+
+		ctOut := eval.MulNew(ct0, ct1)
+		require.Equal(t, ctOut.Degree(), 2)
+
+		eval.Relinearize(ctOut, ctOut)
+		require.Equal(t, ctOut.Degree(), 1)
+
+		msgOut := dec.DecryptToMsgNew(ctOut)
+
+		for i := 0; i < slots; i++ {
+			delta := msg1.Value[i]*msg2.Value[i] - msgOut.Value[i]
+			require.GreaterOrEqual(t, -math.Log2(params.DefaultScale())+float64(params.LogSlots())+12, math.Log2(math.Abs(real(delta))))
+			require.GreaterOrEqual(t, -math.Log2(params.DefaultScale())+float64(params.LogSlots())+12, math.Log2(math.Abs(imag(delta))))
+		}
+	})
+
+	t.Run("MulRelinAndAdd", func(t *testing.T) {
+		msg1, ct0 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+		msg2, ct1 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+
+		ctOut := eval.MulRelinNew(ct0, ct1)
+		eval.MulRelinAndAdd(ct0, ct1, ctOut)
+
+		require.Equal(t, ctOut.Degree(), 1)
+
+		msgOut := dec.DecryptToMsgNew(ctOut)
+
+		for i := 0; i < slots; i++ {
+			delta := 2*msg1.Value[i]*msg2.Value[i] - msgOut.Value[i]
+			require.GreaterOrEqual(t, -math.Log2(params.DefaultScale())+float64(params.LogSlots())+13, math.Log2(math.Abs(real(delta))))
+			require.GreaterOrEqual(t, -math.Log2(params.DefaultScale())+float64(params.LogSlots())+13, math.Log2(math.Abs(imag(delta))))
+		}
+	})
+
+	t.Run("RotateNegative", func(t *testing.T) {
+		msg, ct := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+		rot := int(testctx.rtk.Rotidx)
+		ctOut := eval.RotateNew(ct, rot-slots)
 		msgOut := dec.DecryptToMsgNew(ctOut)
 
 		for i := 0; i < slots; i++ {
@@ -261,4 +709,1595 @@ func testEval(testctx *testContext, t *testing.T) {
 		}
 	})
 
+	t.Run("Conjugate", func(t *testing.T) {
+
+		if params.RingType() != ring.Standard {
+			t.Skip("conjugation is the identity on the conjugate invariant ring")
+		}
+
+		msg, ct := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+		ctOut := eval.ConjugateNew(ct)
+		msgOut := dec.DecryptToMsgNew(ctOut)
+
+		for i := 0; i < slots; i++ {
+			delta := msgOut.Value[i] - cmplx.Conj(msg.Value[i])
+			require.GreaterOrEqual(t, -math.Log2(params.DefaultScale())+float64(params.LogSlots())+12, math.Log2(math.Abs(real(delta))))
+			require.GreaterOrEqual(t, -math.Log2(params.DefaultScale())+float64(params.LogSlots())+12, math.Log2(math.Abs(imag(delta))))
+		}
+	})
+
+	t.Run("SwitchKeys", func(t *testing.T) {
+		msg, ct := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+
+		skOut := testctx.kgen.GenSecretKey()
+		swk := testctx.kgen.KeyGenerator.GenSwitchingKey(testctx.sk, skOut)
+
+		// The key is converted on its first use, and the conversion is reused until it is released
+		for _, release := range []bool{false, true} {
+
+			ctOut := eval.SwitchKeysNew(ct, swk)
+			require.Contains(t, eval.swks, swk)
+			msgOut := NewDecryptor(params, skOut).DecryptToMsgNew(ctOut)
+
+			for i := 0; i < slots; i++ {
+				delta := msgOut.Value[i] - msg.Value[i]
+				require.GreaterOrEqual(t, -math.Log2(params.DefaultScale())+float64(params.LogSlots())+12, math.Log2(math.Abs(real(delta))))
+				require.GreaterOrEqual(t, -math.Log2(params.DefaultScale())+float64(params.LogSlots())+12, math.Log2(math.Abs(imag(delta))))
+			}
+
+			if release {
+				eval.ReleaseSwitchingKey(swk)
+				require.NotContains(t, eval.swks, swk)
+			}
+		}
+	})
+
+}
+
+func requireCiphertextsEqual(t *testing.T, ringQ *ring.Ring, ct0, ct1 *ckks.Ciphertext) {
+	require.Equal(t, ct0.Degree(), ct1.Degree())
+	require.Equal(t, ct0.Level(), ct1.Level())
+	for i := range ct0.Value {
+		require.True(t, ringQ.EqualLvl(ct0.Level(), ct0.Value[i], ct1.Value[i]))
+	}
+}
+
+func testHybrid(testctx *testContext, t *testing.T) {
+
+	params := testctx.params
+	slots := params.Slots()
+	ringQ := params.RingQ()
+	dec := testctx.dec
+	rot := int(testctx.rtk.Rotidx)
+
+	// Alternates between the two methods over the levels
+	costs := &CostTable{Classic: make([]float64, params.MaxLevel()+1), Fast: make([]float64, params.MaxLevel()+1)}
+	for level := range costs.Classic {
+		costs.Classic[level] = float64(level & 1)
+		costs.Fast[level] = float64(1 - level&1)
+	}
+
+	eval := NewHybridEvaluator(params, testctx.evk, testctx.evkOld, costs)
+
+	t.Run("HybridMatchesMethodAtEveryLevel", func(t *testing.T) {
+
+		msg1, ct0 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+		msg2, ct1 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+
+		for level := params.MaxLevel(); level >= 0; level-- {
+
+			var reference ckks.Evaluator = testctx.eval
+			if costs.Method(level) == Classic {
+				reference = testctx.evalOld
+			}
+
+			ctOut := eval.MulRelinNew(ct0, ct1)
+			requireCiphertextsEqual(t, ringQ, reference.MulRelinNew(ct0, ct1), ctOut)
+
+			// Without rescaling, the product can only be decrypted if Q is larger than its scale
+			if params.LogQLvl(level) > int(math.Log2(ctOut.Scale))+8 {
+				msgOut := dec.DecryptToMsgNew(ctOut)
+				for i := 0; i < slots; i++ {
+					delta := msg1.Value[i]*msg2.Value[i] - msgOut.Value[i]
+					require.GreaterOrEqual(t, -math.Log2(params.DefaultScale())+float64(params.LogSlots())+12, math.Log2(math.Abs(real(delta))))
+					require.GreaterOrEqual(t, -math.Log2(params.DefaultScale())+float64(params.LogSlots())+12, math.Log2(math.Abs(imag(delta))))
+				}
+			}
+
+			ctOut = eval.RotateNew(ct0, rot)
+			requireCiphertextsEqual(t, ringQ, reference.RotateNew(ct0, rot), ctOut)
+
+			msgOut := dec.DecryptToMsgNew(ctOut)
+			for i := 0; i < slots; i++ {
+				delta := msgOut.Value[i] - msg1.Value[(i+rot)%slots]
+				require.GreaterOrEqual(t, -math.Log2(params.DefaultScale())+float64(params.LogSlots())+12, math.Log2(math.Abs(real(delta))))
+				require.GreaterOrEqual(t, -math.Log2(params.DefaultScale())+float64(params.LogSlots())+12, math.Log2(math.Abs(imag(delta))))
+			}
+
+			// The methods taking frlwe keys use the frlwe.KeySwitcher at every level
+			requireCiphertextsEqual(t, ringQ, testctx.eval.RotateWithKeyNew(ct0, testctx.rtk), eval.RotateWithKeyNew(ct0, testctx.rtk))
+			requireCiphertextsEqual(t, ringQ, testctx.eval.SwitchKeysFastNew(ct0, testctx.rtk.Value), eval.SwitchKeysFastNew(ct0, testctx.rtk.Value))
+
+			if level > 0 {
+				eval.DropLevel(ct0, 1)
+				eval.DropLevel(ct1, 1)
+			}
+		}
+	})
+
+	t.Run("HybridMeasuredCostTable", func(t *testing.T) {
+
+		measured := MeasureCostTable(params, testctx.evk, testctx.evkOld, 1)
+		require.Len(t, measured.Classic, params.MaxLevel()+1)
+		require.Len(t, measured.Fast, params.MaxLevel()+1)
+		for level := range measured.Classic {
+			require.Greater(t, measured.Classic[level], 0.0)
+			require.Greater(t, measured.Fast[level], 0.0)
+		}
+
+		buf := new(bytes.Buffer)
+		require.NoError(t, WriteCostTable(buf, measured))
+		loaded, err := ReadCostTable(buf)
+		require.NoError(t, err)
+		require.Equal(t, measured, loaded)
+
+		require.Equal(t, loaded, NewHybridEvaluator(params, testctx.evk, testctx.evkOld, loaded).CostTable())
+		require.Nil(t, testctx.eval.CostTable())
+	})
+
+	t.Run("HybridCostTableMismatch", func(t *testing.T) {
+		require.Panics(t, func() {
+			NewHybridEvaluator(params, testctx.evk, testctx.evkOld, &CostTable{Classic: []float64{0}, Fast: []float64{0}})
+		})
+	})
+}
+
+// requireMessagesClose checks that each slot of msgOut is within 2^-(logScale-logSlots-bound) of want.
+func requireMessagesClose(t *testing.T, params Parameters, want []complex128, msgOut *Message, bound float64) {
+	for i := 0; i < params.Slots(); i++ {
+		delta := want[i] - msgOut.Value[i]
+		require.GreaterOrEqual(t, -math.Log2(params.DefaultScale())+float64(params.LogSlots())+bound, math.Log2(math.Abs(real(delta))))
+		require.GreaterOrEqual(t, -math.Log2(params.DefaultScale())+float64(params.LogSlots())+bound, math.Log2(math.Abs(imag(delta))))
+	}
+}
+
+// requireWithinRounding checks that the coefficients of ct0 and ct1 differ by at most one.
+func requireWithinRounding(t *testing.T, params Parameters, ct0, ct1 *ckks.Ciphertext) {
+	ringQ := params.RingQ()
+	level := ct0.Level()
+	require.Equal(t, level, ct1.Level())
+	diff := ringQ.NewPolyLvl(level)
+	for i := range ct0.Value {
+		ringQ.SubLvl(level, ct0.Value[i], ct1.Value[i], diff)
+		ringQ.InvNTTLvl(level, diff, diff)
+		for j := 0; j < level+1; j++ {
+			qj := ringQ.Modulus[j]
+			for _, c := range diff.Coeffs[j] {
+				require.True(t, c <= 1 || c == qj-1)
+			}
+		}
+	}
+}
+
+func testRescale(testctx *testContext, t *testing.T) {
+
+	params := testctx.params
+	slots := params.Slots()
+	dec := testctx.dec
+	eval := testctx.eval
+	maxLevel := params.MaxLevel()
+
+	t.Run("MulRelinThenRescale", func(t *testing.T) {
+		msg1, ct0 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+		msg2, ct1 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+
+		ctOut, err := eval.MulRelinThenRescaleNew(ct0, ct1)
+		require.NoError(t, err)
+		require.Equal(t, 1, ctOut.Degree())
+		require.Equal(t, maxLevel-1, ctOut.Level())
+		require.InDelta(t, math.Log2(params.DefaultScale()), math.Log2(ctOut.Scale), 1)
+
+		want := make([]complex128, slots)
+		for i := range want {
+			want[i] = msg1.Value[i] * msg2.Value[i]
+		}
+		requireMessagesClose(t, params, want, dec.DecryptToMsgNew(ctOut), 12)
+
+		ctLast := ckks.NewCiphertext(params.Parameters, 1, 0, ct0.Scale)
+		require.Error(t, eval.MulRelinThenRescale(eval.DropLevelNew(ct0, maxLevel), eval.DropLevelNew(ct1, maxLevel), ctLast))
+	})
+
+	t.Run("MulRelinRescale", func(t *testing.T) {
+		msg1, ct0 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+		msg2, ct1 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+
+		ctWant, err := eval.MulRelinThenRescaleNew(ct0, ct1)
+		require.NoError(t, err)
+
+		ctOut, err := eval.MulRelinRescaleNew(ct0, ct1)
+		require.NoError(t, err)
+		require.Equal(t, ctWant.Scale, ctOut.Scale)
+		requireWithinRounding(t, params, ctWant, ctOut)
+
+		want := make([]complex128, slots)
+		for i := range want {
+			want[i] = msg1.Value[i] * msg2.Value[i]
+		}
+		requireMessagesClose(t, params, want, dec.DecryptToMsgNew(ctOut), 12)
+
+		// In place
+		require.NoError(t, eval.MulRelinRescale(ct0, ct1, ct0))
+		requireWithinRounding(t, params, ctWant, ct0)
+
+		ctLast := ckks.NewCiphertext(params.Parameters, 1, 0, ct1.Scale)
+		require.Error(t, eval.MulRelinRescale(eval.DropLevelNew(ct1, maxLevel), eval.DropLevelNew(ct1, maxLevel), ctLast))
+	})
+
+	t.Run("AddDifferentLevelsAndScales", func(t *testing.T) {
+		msg0, ct0 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+		msg1, ct1 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+		msg2, ct2 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+
+		// The product is one level below ct0, at a scale close but not equal to the one of ct0
+		ctProd, err := eval.MulRelinThenRescaleNew(ct1, ct2)
+		require.NoError(t, err)
+		require.NotEqual(t, ct0.Scale, ctProd.Scale)
+
+		sum, diff := make([]complex128, slots), make([]complex128, slots)
+		for i := range sum {
+			sum[i] = msg0.Value[i] + msg1.Value[i]*msg2.Value[i]
+			diff[i] = msg1.Value[i]*msg2.Value[i] - msg0.Value[i]
+		}
+
+		ctOut := eval.AddNew(ct0, ctProd)
+		require.Equal(t, math.Max(ct0.Scale, ctProd.Scale), ctOut.Scale)
+		requireMessagesClose(t, params, sum, dec.DecryptToMsgNew(ctOut), 12)
+
+		ctOut = eval.SubNew(ctProd, ct0)
+		requireMessagesClose(t, params, diff, dec.DecryptToMsgNew(ctOut), 12)
+
+		// The inputs are left untouched
+		require.Equal(t, maxLevel, ct0.Level())
+		require.Equal(t, params.DefaultScale(), ct0.Scale)
+
+		eval.Add(ctProd, ct0, ctProd)
+		requireMessagesClose(t, params, sum, dec.DecryptToMsgNew(ctProd), 12)
+	})
+
+	t.Run("AddIntegerScaleRatio", func(t *testing.T) {
+		msg0, ct0 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+		msg1, ct1 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+
+		ct1 = eval.ScaleUpNew(ct1, 1<<10)
+		eval.DropLevel(ct1, 1)
+
+		sum := make([]complex128, slots)
+		for i := range sum {
+			sum[i] = msg0.Value[i] + msg1.Value[i]
+		}
+
+		// The ratio of the scales is an integer, so no level is consumed
+		ctOut := eval.AddNew(ct0, ct1)
+		require.Equal(t, maxLevel-1, ctOut.Level())
+		require.Equal(t, ct1.Scale, ctOut.Scale)
+		requireMessagesClose(t, params, sum, dec.DecryptToMsgNew(ctOut), 9)
+	})
+
+	t.Run("RescalingPolicyEager", func(t *testing.T) {
+		msg1, ct0 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+		msg2, ct1 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+
+		eval.SetRescalingPolicy(RescaleEager, params.DefaultScale())
+		defer eval.SetRescalingPolicy(RescaleManual, params.DefaultScale())
+
+		policy, minScale := eval.RescalingPolicy()
+		require.Equal(t, RescaleEager, policy)
+		require.Equal(t, params.DefaultScale(), minScale)
+
+		ctOut := eval.MulRelinNew(ct0, ct1)
+		require.Equal(t, maxLevel-1, ctOut.Level())
+
+		want := make([]complex128, slots)
+		for i := range want {
+			want[i] = msg1.Value[i] * msg2.Value[i]
+		}
+		requireMessagesClose(t, params, want, dec.DecryptToMsgNew(ctOut), 12)
+
+		// The same evaluation with the default policy leaves the product at the level of its operands
+		eval.SetRescalingPolicy(RescaleManual, params.DefaultScale())
+		require.Equal(t, maxLevel, eval.MulRelinNew(ct0, ct1).Level())
+	})
+
+	t.Run("RescalingPolicyLazy", func(t *testing.T) {
+		msg1, ct0 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+		msg2, ct1 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+		msg3, ct2 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+
+		eval.SetRescalingPolicy(RescaleLazy, params.DefaultScale())
+		defer eval.SetRescalingPolicy(RescaleManual, params.DefaultScale())
+
+		// Fresh operands are not rescaled
+		ctProd := eval.MulRelinNew(ct0, ct1)
+		require.Equal(t, maxLevel, ctProd.Level())
+
+		// The product is rescaled before being multiplied again, without being modified
+		ctOut := eval.MulRelinNew(ctProd, ct2)
+		require.Equal(t, maxLevel-1, ctOut.Level())
+		require.Equal(t, maxLevel, ctProd.Level())
+
+		eval.MulRelinAndAdd(ctProd, ct2, ctOut)
+		require.Equal(t, maxLevel-1, ctOut.Level())
+
+		want := make([]complex128, slots)
+		for i := range want {
+			want[i] = 2 * msg1.Value[i] * msg2.Value[i] * msg3.Value[i]
+		}
+		requireMessagesClose(t, params, want, dec.DecryptToMsgNew(ctOut), 13)
+
+		// A product of degree 2 is left as is and rejected by the multiplication
+		ctProd = eval.MulNew(ct0, ct1)
+		require.Equal(t, 2, ctProd.Degree())
+
+		pt := ckks.NewEncoder(params.Parameters).EncodeNew(msg3.Value, maxLevel, params.DefaultScale(), params.LogSlots())
+		require.PanicsWithValue(t, "cannot MulRelin: input elements must be of degree 0 or 1", func() { eval.MulNew(ctProd, pt) })
+	})
+
+	t.Run("SetRescalingPolicyInvalidScale", func(t *testing.T) {
+		require.Panics(t, func() { eval.SetRescalingPolicy(RescaleEager, 0) })
+	})
+}
+
+func testInnerSum(testctx *testContext, t *testing.T) {
+
+	params := testctx.params
+	slots := params.Slots()
+	dec := testctx.dec
+
+	batch, n := 3, 5
+	logSlotsStart := params.LogSlots() - 2
+
+	t.Run("RotationsForInnerSumLog", func(t *testing.T) {
+		require.Equal(t, []int{3, 6, 12}, params.RotationsForInnerSumLog(3, 5))
+		require.Equal(t, []int{-12, -6, -3}, params.RotationsForReplicateLog(3, 5))
+		require.Equal(t, []int{1, 2, 4}, params.RotationsForInnerSumLog(1, 8))
+		require.Empty(t, params.RotationsForInnerSumLog(1, 1))
+		require.Subset(t, params.Parameters.RotationsForInnerSumLog(3, 5), params.RotationsForInnerSumLog(3, 5))
+		require.Equal(t, []int{1 << logSlotsStart, 2 << logSlotsStart}, params.RotationsForTrace(logSlotsStart, params.LogSlots()))
+	})
+
+	rotations := append(params.RotationsForInnerSumLog(batch, n), params.RotationsForReplicateLog(batch, n)...)
+	rotations = append(rotations, params.RotationsForTrace(logSlotsStart, params.LogSlots())...)
+
+	eval := NewEvaluator(params, frlwe.EvaluationKey{Rtks: testctx.kgen.GenRotKeys(rotations, testctx.sk)})
+
+	// sum returns the message whose slot i is the sum of the slots i+j*batch of msg for 0 <= j < n
+	sum := func(msg *Message, batch, n int) []complex128 {
+		want := make([]complex128, slots)
+		for i := range want {
+			for j := 0; j < n; j++ {
+				want[i] += msg.Value[(((i+j*batch)%slots)+slots)%slots]
+			}
+		}
+		return want
+	}
+
+	t.Run("InnerSum", func(t *testing.T) {
+		msg, ct := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+
+		ctOut := eval.InnerSumNew(ct, batch, n)
+		require.Equal(t, ct.Level(), ctOut.Level())
+		require.Equal(t, ct.Scale, ctOut.Scale)
+		requireMessagesClose(t, params, sum(msg, batch, n), dec.DecryptToMsgNew(ctOut), 12)
+
+		// In place, on a power of two
+		eval.InnerSum(ct, batch, 4, ct)
+		requireMessagesClose(t, params, sum(msg, batch, 4), dec.DecryptToMsgNew(ct), 12)
+	})
+
+	t.Run("Replicate", func(t *testing.T) {
+		msg, ct := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+
+		requireMessagesClose(t, params, sum(msg, -batch, n), dec.DecryptToMsgNew(eval.ReplicateNew(ct, batch, n)), 12)
+	})
+
+	t.Run("Trace", func(t *testing.T) {
+		msg, ct := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+
+		ctOut := eval.TraceNew(eval.DropLevelNew(ct, 1), logSlotsStart, params.LogSlots())
+		require.Equal(t, params.LevelQ(params.MaxLogicalLevel()-1), ctOut.Level())
+		requireMessagesClose(t, params, sum(msg, 1<<logSlotsStart, 4), dec.DecryptToMsgNew(ctOut), 12)
+
+		require.Panics(t, func() { eval.TraceNew(ct, 2, 1) })
+	})
+}
+
+func testLinearTransform(testctx *testContext, t *testing.T) {
+
+	params := testctx.params
+	slots := params.Slots()
+	dec := testctx.dec
+
+	diagonals := make(map[int][]complex128)
+	for _, k := range []int{-1, 0, 1, 2, 3, 15, 16, 17, 33} {
+		diagonals[k] = make([]complex128, slots)
+		for i := range diagonals[k] {
+			if params.RingType() == ring.ConjugateInvariant {
+				diagonals[k][i] = complex(utils.RandFloat64(-0.5, 0.5), 0)
+			} else {
+				diagonals[k][i] = complex(utils.RandFloat64(-0.5, 0.5), utils.RandFloat64(-0.5, 0.5))
+			}
+		}
+	}
+
+	lt := NewLinearTransform(params, ckks.NewEncoder(params.Parameters), diagonals, params.MaxLevel(), params.DefaultScale(), params.LogSlots())
+
+	t.Run("Rotations", func(t *testing.T) {
+		index, babySteps, giantSteps := lt.bsgsIndex()
+		require.Len(t, lt.Vec, len(diagonals))
+		require.Contains(t, lt.Vec, slots-1)
+
+		var nbDiags int
+		for _, j := range giantSteps {
+			require.Zero(t, j%lt.N1)
+			nbDiags += len(index[j])
+		}
+		require.Equal(t, len(diagonals), nbDiags)
+		require.Len(t, lt.Rotations(), len(babySteps)+len(giantSteps)-2)
+		require.Less(t, len(lt.Rotations()), len(diagonals)-1)
+	})
+
+	ltHoisted := NewLinearTransformHoisted(params, ckks.NewEncoder(params.Parameters), diagonals, params.MaxLevel(), params.DefaultScale(), params.LogSlots())
+
+	rotations := lt.Rotations()
+	for _, k := range ltHoisted.Rotations() {
+		if !utils.IsInSliceInt(k, rotations) {
+			rotations = append(rotations, k)
+		}
+	}
+
+	eval := NewEvaluator(params, frlwe.EvaluationKey{Rtks: testctx.kgen.GenRotKeys(rotations, testctx.sk)})
+
+	t.Run("LinearTransform", func(t *testing.T) {
+		msg, ct := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+
+		want := make([]complex128, slots)
+		for k, diag := range diagonals {
+			for i := range want {
+				want[i] += diag[i] * msg.Value[(i+k+slots)%slots]
+			}
+		}
+
+		ctOut := eval.LinearTransformNew(ct, lt)
+		require.Equal(t, ct.Level(), ctOut.Level())
+		require.Equal(t, ct.Scale*lt.Scale, ctOut.Scale)
+		require.NoError(t, eval.Rescale(ctOut, params.DefaultScale(), ctOut))
+		requireMessagesClose(t, params, want, dec.DecryptToMsgNew(ctOut), 12)
+
+		// Encoded at the scale of the modulus of its level, the transform keeps the scale of ct whatever the policy
+		ltRescale := NewLinearTransform(params, ckks.NewEncoder(params.Parameters), diagonals, params.MaxLevel(), params.levelModulusFloat(params.MaxLevel()), params.LogSlots())
+		for _, policy := range []RescalingPolicy{RescaleManual, RescaleEager} {
+			eval.SetRescalingPolicy(policy, params.DefaultScale())
+			ctOut = eval.LinearTransformAndRescaleNew(ct, ltRescale)
+			require.Equal(t, params.LevelQ(params.MaxLogicalLevel()-1), ctOut.Level())
+			require.Equal(t, ct.Scale, ctOut.Scale)
+			requireMessagesClose(t, params, want, dec.DecryptToMsgNew(ctOut), 12)
+		}
+		eval.SetRescalingPolicy(RescaleManual, params.DefaultScale())
+
+		// A spare prime leaves the product off the last prime of its logical level
+		if params.PrimesPerLevel() > 1 {
+			require.Panics(t, func() { eval.LinearTransformAndRescaleNew(eval.Evaluator.DropLevelNew(ct, 1), ltRescale) })
+		}
+
+		// Below the level of the diagonals, with the eager rescaling policy
+		eval.SetRescalingPolicy(RescaleEager, params.DefaultScale())
+		defer eval.SetRescalingPolicy(RescaleManual, params.DefaultScale())
+
+		ct = eval.DropLevelNew(ct, 1)
+		eval.LinearTransform(ct, lt, ct)
+		require.Equal(t, params.LevelQ(params.MaxLogicalLevel()-2), ct.Level())
+		requireMessagesClose(t, params, want, dec.DecryptToMsgNew(ct), 12)
+	})
+
+	t.Run("LinearTransformReusedBabySteps", func(t *testing.T) {
+		msg, ct := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+
+		// A transform whose first baby step is a rotation, all its diagonals being baby steps, followed on the same
+		// Evaluator by lt, whose first baby step is the step 0 and reuses its buffers
+		diagonalsNoZero := map[int][]complex128{1: diagonals[1], 2: diagonals[2], 3: diagonals[3]}
+		ltNoZero := NewLinearTransformHoisted(params, ckks.NewEncoder(params.Parameters), diagonalsNoZero, params.MaxLevel(), params.DefaultScale(), params.LogSlots())
+		_, babySteps, _ := ltNoZero.bsgsIndex()
+		require.NotZero(t, babySteps[0])
+
+		eval := NewEvaluator(params, frlwe.EvaluationKey{Rtks: testctx.kgen.GenRotKeys(append(ltNoZero.Rotations(), lt.Rotations()...), testctx.sk)})
+
+		for _, transform := range []struct {
+			lt        LinearTransform
+			diagonals map[int][]complex128
+		}{{ltNoZero, diagonalsNoZero}, {lt, diagonals}} {
+
+			want := make([]complex128, slots)
+			for k, diag := range transform.diagonals {
+				for i := range want {
+					want[i] += diag[i] * msg.Value[(i+k+slots)%slots]
+				}
+			}
+
+			ctOut := eval.LinearTransformNew(ct, transform.lt)
+			require.NoError(t, eval.Rescale(ctOut, params.DefaultScale(), ctOut))
+			requireMessagesClose(t, params, want, dec.DecryptToMsgNew(ctOut), 12)
+		}
+	})
+
+	t.Run("LinearTransformHoisted", func(t *testing.T) {
+		msg, ct := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+
+		want := make([]complex128, slots)
+		for k, diag := range diagonals {
+			for i := range want {
+				want[i] += diag[i] * msg.Value[(i+k+slots)%slots]
+			}
+		}
+
+		require.Len(t, ltHoisted.Rotations(), len(diagonals)-1)
+		require.Panics(t, func() { eval.LinearTransformHoistedNew(ct, lt) })
+
+		ctOut := eval.LinearTransformHoistedNew(ct, ltHoisted)
+		require.Equal(t, ct.Level(), ctOut.Level())
+		require.Equal(t, ct.Scale*ltHoisted.Scale, ctOut.Scale)
+		require.NoError(t, eval.Rescale(ctOut, params.DefaultScale(), ctOut))
+		requireMessagesClose(t, params, want, dec.DecryptToMsgNew(ctOut), 12)
+
+		// The BSGS evaluation of the same diagonals, all baby steps
+		ctOut = eval.LinearTransformNew(ct, ltHoisted)
+		require.NoError(t, eval.Rescale(ctOut, params.DefaultScale(), ctOut))
+		requireMessagesClose(t, params, want, dec.DecryptToMsgNew(ctOut), 12)
+	})
+}
+
+func testPolynomialEvaluator(testctx *testContext, t *testing.T) {
+
+	params := testctx.params
+	slots := params.Slots()
+	dec := testctx.dec
+	pe := NewPolynomialEvaluator(testctx.eval)
+
+	// The slots of the results must be within 2^-logBound of the evaluation in the clear: with scales of at
+	// least 2^36, the evaluations of the test polynomials are precise to about 2^-15
+	logBound := 12.0
+	requireClose := func(want []complex128, ct *ckks.Ciphertext) {
+		msgOut := dec.DecryptToMsgNew(ct)
+		for i := range want {
+			require.Less(t, math.Abs(real(msgOut.Value[i]-want[i])), math.Exp2(-logBound))
+			require.Less(t, math.Abs(imag(msgOut.Value[i]-want[i])), math.Exp2(-logBound))
+		}
+	}
+
+	// randomCoeffs returns degree+1 coefficients of decreasing magnitude
+	randomCoeffs := func(degree int) []complex128 {
+		coeffs := make([]complex128, degree+1)
+		for i := range coeffs {
+			if params.RingType() == ring.ConjugateInvariant {
+				coeffs[i] = complex(utils.RandFloat64(-1, 1)/float64(i+1), 0)
+			} else {
+				coeffs[i] = complex(utils.RandFloat64(-1, 1), utils.RandFloat64(-1, 1)) / complex(float64(i+1), 0)
+			}
+		}
+		return coeffs
+	}
+
+	evaluate := func(pol *Polynomial, msg *Message) []complex128 {
+		want := make([]complex128, slots)
+		for i := range want {
+			want[i] = pol.Evaluate(msg.Value[i])
+		}
+		return want
+	}
+
+	t.Run("PolynomialEvaluate", func(t *testing.T) {
+		// T3 = 4x^3 - 3x, on [0, 4] mapped to [-1, 1]
+		pol := NewPolynomial(Chebyshev, []complex128{0, 0, 0, 1}, 0, 4)
+		require.InDelta(t, 4*0.125-1.5, real(pol.Evaluate(3)), 1e-12)
+		require.Equal(t, 3, pol.Degree())
+		require.Equal(t, 3, pol.Depth())
+
+		pol = NewPolynomial(Monomial, []complex128{1, 2, 3}, 0, 0)
+		require.Equal(t, complex(17, 0), pol.Evaluate(2))
+		require.Equal(t, 2, pol.Depth())
+
+		require.Panics(t, func() { NewPolynomial(Chebyshev, []complex128{1, 2}, 1, -1) })
+
+		// The interpolant of a polynomial of at most the degree of the interpolation is the polynomial itself
+		pol = ChebyshevInterpolation(func(x complex128) complex128 { return x*x - 1 }, 0, 4, 3)
+		require.InDelta(t, 3*3-1, real(pol.Evaluate(3)), 1e-12)
+	})
+
+	t.Run("PolynomialEvaluatorMonomial", func(t *testing.T) {
+		// Inputs in the unit disk, on which the monomials are bounded
+		msg, ct := newTestVectors(testctx, complex(-0.7, -0.7), complex(0.7, 0.7))
+		pol := NewPolynomial(Monomial, randomCoeffs(7), 0, 0)
+
+		ctOut, err := pe.EvaluateNew(ct, pol, params.DefaultScale())
+		require.NoError(t, err)
+		require.Equal(t, 1, ctOut.Degree())
+		require.Equal(t, params.LevelQ(params.MaxLogicalLevel()-3), ctOut.Level())
+		require.Equal(t, params.DefaultScale(), ctOut.Scale)
+		requireClose(evaluate(pol, msg), ctOut)
+	})
+
+	t.Run("PolynomialEvaluatorChebyshev", func(t *testing.T) {
+		// Real inputs, on which the Chebyshev polynomials are bounded
+		msg, ct := newTestVectors(testctx, -4, 4)
+
+		for _, degree := range []int{1, 2, 7, 8, 15} {
+			pol := NewPolynomial(Chebyshev, randomCoeffs(degree), -4, 4)
+
+			// The modulus of the logical level 0 leaves no room above the scale
+			if pol.Depth() > params.MaxLogicalLevel()-1 {
+				continue
+			}
+
+			ctOut, err := pe.EvaluateNew(ct, pol, params.DefaultScale())
+			require.NoError(t, err)
+			require.Equal(t, params.LevelQ(params.MaxLogicalLevel()-pol.Depth()), ctOut.Level())
+			require.Equal(t, params.DefaultScale(), ctOut.Scale)
+			requireClose(evaluate(pol, msg), ctOut)
+		}
+
+		// On [-1, 1], with no change of variable
+		pol := NewPolynomial(Chebyshev, randomCoeffs(4), -1, 1)
+		msg, ct = newTestVectors(testctx, -1, 1)
+		ctOut, err := pe.EvaluateNew(ct, pol, params.DefaultScale())
+		require.NoError(t, err)
+		require.Equal(t, params.LevelQ(params.MaxLogicalLevel()-3), ctOut.Level())
+		requireClose(evaluate(pol, msg), ctOut)
+	})
+
+	t.Run("PolynomialEvaluatorErrors", func(t *testing.T) {
+		_, ct := newTestVectors(testctx, complex(-1, -1), complex(1, 1))
+		pol := NewPolynomial(Monomial, randomCoeffs(7), 0, 0)
+
+		_, err := pe.EvaluateNew(testctx.eval.DropLevelNew(ct, params.MaxLogicalLevel()-2), pol, params.DefaultScale())
+		require.Error(t, err)
+
+		_, err = pe.EvaluateNew(ct, NewPolynomial(Monomial, []complex128{1}, 0, 0), params.DefaultScale())
+		require.Error(t, err)
+	})
+}
+
+func testCompositeLevels(testctx *testContext, t *testing.T) {
+
+	params := testctx.params
+	slots := params.Slots()
+	dec := testctx.dec
+	eval := testctx.eval
+
+	// Each slot of a product must be within 2^-logPrecision of the expected value, which is beyond
+	// what a chain of 36-bit primes rescaled one by one can reach.
+	logPrecision := 45.0
+
+	t.Run("LogicalLevels", func(t *testing.T) {
+		require.Equal(t, 2, params.PrimesPerLevel())
+		require.Equal(t, 5, params.MaxLogicalLevel())
+		for logicalLevel := 0; logicalLevel <= params.MaxLogicalLevel(); logicalLevel++ {
+			require.Equal(t, 2*logicalLevel+1, params.LevelQ(logicalLevel))
+			require.Equal(t, logicalLevel, params.LogicalLevel(params.LevelQ(logicalLevel)))
+		}
+		// A ciphertext with a spare prime belongs to the logical level below
+		require.Equal(t, 2, params.LogicalLevel(6))
+
+		// The remaining primes are given to the logical level 0
+		params := NewParametersFromLiteral(ParametersLiteral{
+			LogN: 10, Q: PN14QP470C2.Q[:7], P: PN14QP470C2.P, T: PN14QP470C2.T, Sigma: rlwe.DefaultSigma,
+			DefaultScale: 1 << 72, LogSlots: 9, Gamma: 3, PrimesPerLevel: 2,
+		})
+		require.Equal(t, 2, params.MaxLogicalLevel())
+		require.Equal(t, 2, params.LevelQ(0))
+		require.Equal(t, 6, params.LevelQ(2))
+
+		// The levels below LevelQ(0) belong to the logical level 0 without being its boundary
+		for levelQ := 0; levelQ <= params.MaxLevel(); levelQ++ {
+			require.GreaterOrEqual(t, params.LogicalLevel(levelQ), 0)
+			require.Equal(t, levelQ == 2 || levelQ == 4 || levelQ == 6, params.IsLogicalLevelBoundary(levelQ))
+		}
+
+		require.Panics(t, func() {
+			NewParametersFromLiteral(ParametersLiteral{
+				LogN: 10, Q: PN14QP470C2.Q[:1], P: PN14QP470C2.P, T: PN14QP470C2.T, Sigma: rlwe.DefaultSigma,
+				DefaultScale: 1 << 72, LogSlots: 9, Gamma: 3, PrimesPerLevel: 2,
+			})
+		})
+	})
+
+	t.Run("SparePrime", func(t *testing.T) {
+
+		_, ct := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+		require.Panics(t, func() { eval.DropLevelNew(ct, params.MaxLogicalLevel()+1) })
+
+		// Without its last prime, the product is left with a spare prime of the top logical level
+		ctProd := eval.MulRelinNew(ct, ct)
+		eval.Evaluator.DropLevel(ctProd, 1)
+		require.False(t, params.IsLogicalLevelBoundary(ctProd.Level()))
+		require.Equal(t, params.MaxLogicalLevel()-1, params.LogicalLevel(ctProd.Level()))
+
+		_, err := eval.RescaleNew(ctProd, params.DefaultScale())
+		require.Error(t, err)
+		require.Panics(t, func() { eval.DropLevel(ctProd, 1) })
+	})
+
+	t.Run("MulRelinThenRescaleAtEveryLevel", func(t *testing.T) {
+
+		msg1, ct0 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+		msg2, ct1 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+
+		for logicalLevel := params.MaxLogicalLevel(); logicalLevel > 0; logicalLevel-- {
+
+			require.Equal(t, params.LevelQ(logicalLevel), ct0.Level())
+
+			ctOut, err := eval.MulRelinThenRescaleNew(ct0, ct1)
+			require.NoError(t, err)
+			require.Equal(t, params.LevelQ(logicalLevel-1), ctOut.Level())
+			require.InDelta(t, math.Log2(params.DefaultScale()), math.Log2(ctOut.Scale), 1)
+
+			msgOut := dec.DecryptToMsgNew(ctOut)
+			for i := 0; i < slots; i++ {
+				delta := msg1.Value[i]*msg2.Value[i] - msgOut.Value[i]
+				require.GreaterOrEqual(t, -logPrecision, math.Log2(math.Abs(real(delta))))
+				require.GreaterOrEqual(t, -logPrecision, math.Log2(math.Abs(imag(delta))))
+			}
+
+			eval.DropLevel(ct0, 1)
+			eval.DropLevel(ct1, 1)
+		}
+
+		_, err := eval.RescaleNew(eval.MulRelinNew(ct0, ct1), params.DefaultScale())
+		require.Error(t, err)
+	})
+
+	t.Run("MulRelinRescaleAtEveryLevel", func(t *testing.T) {
+
+		_, ct0 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+		_, ct1 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+
+		for logicalLevel := params.MaxLogicalLevel(); logicalLevel > 0; logicalLevel-- {
+
+			ctWant, err := eval.MulRelinThenRescaleNew(ct0, ct1)
+			require.NoError(t, err)
+
+			ctOut, err := eval.MulRelinRescaleNew(ct0, ct1)
+			require.NoError(t, err)
+			require.Equal(t, params.LevelQ(logicalLevel-1), ctOut.Level())
+			require.Equal(t, ctWant.Scale, ctOut.Scale)
+			requireWithinRounding(t, params, ctWant, ctOut)
+
+			eval.DropLevel(ct0, 1)
+			eval.DropLevel(ct1, 1)
+		}
+	})
+
+	t.Run("AddAcrossLogicalLevels", func(t *testing.T) {
+
+		msg0, ct0 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+		msg1, ct1 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+		msg2, ct2 := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+
+		ctProd, err := eval.MulRelinThenRescaleNew(ct1, ct2)
+		require.NoError(t, err)
+
+		// The scales differ by a non-integer ratio, so the operand with the smaller scale consumes a
+		// whole logical level, which the product has already consumed
+		ctOut := eval.AddNew(ct0, ctProd)
+		require.Equal(t, params.LevelQ(params.MaxLogicalLevel()-1), ctOut.Level())
+		require.Equal(t, math.Max(ct0.Scale, ctProd.Scale), ctOut.Scale)
+
+		msgOut := dec.DecryptToMsgNew(ctOut)
+		for i := 0; i < slots; i++ {
+			delta := msg0.Value[i] + msg1.Value[i]*msg2.Value[i] - msgOut.Value[i]
+			require.GreaterOrEqual(t, -logPrecision, math.Log2(math.Abs(real(delta))))
+			require.GreaterOrEqual(t, -logPrecision, math.Log2(math.Abs(imag(delta))))
+		}
+	})
+
+	t.Run("RotateAtEveryLevel", func(t *testing.T) {
+
+		msg, ct := newTestVectors(testctx, complex(-0.5, -0.5), complex(0.5, 0.5))
+		rot := int(testctx.rtk.Rotidx)
+
+		for logicalLevel := params.MaxLogicalLevel(); logicalLevel >= 0; logicalLevel-- {
+
+			msgOut := dec.DecryptToMsgNew(eval.RotateNew(ct, rot))
+			for i := 0; i < slots; i++ {
+				delta := msgOut.Value[i] - msg.Value[(i+rot)%slots]
+				require.GreaterOrEqual(t, -logPrecision, math.Log2(math.Abs(real(delta))))
+				require.GreaterOrEqual(t, -logPrecision, math.Log2(math.Abs(imag(delta))))
+			}
+
+			if logicalLevel > 0 {
+				eval.DropLevel(ct, 1)
+			}
+		}
+	})
+}
+
+func testBootstrapping(testctx *testContext, btpParams BootstrappingParameters, t *testing.T) {
+
+	params := testctx.params
+	dec := testctx.dec
+
+	btp := NewBootstrapper(params, btpParams, testctx.evk)
+
+	t.Run("Errors", func(t *testing.T) {
+		require.Panics(t, func() { NewBootstrapper(params, btpParams, frlwe.EvaluationKey{Rlk: testctx.evk.Rlk}) })
+		// Depth 13 exceeds the 12 logical levels of the parameters
+		require.Panics(t, func() {
+			NewBootstrapper(params, BootstrappingParameters{K: 12, SineDegree: 31, DoubleAngle: 6}, testctx.evk)
+		})
+		if btpParams.EphemeralSecretWeight > 0 {
+			evk := testctx.evk
+			evk.Ek = nil
+			require.Panics(t, func() { NewBootstrapper(params, btpParams, evk) })
+		}
+	})
+
+	if btpParams.EphemeralSecretWeight > 0 {
+		t.Run("SwitchToSparse", func(t *testing.T) {
+			msg, ct := newTestVectors(testctx, complex(-1, -1), complex(1, 1))
+
+			// The encapsulation key only switches ciphertexts at level 0
+			require.Panics(t, func() { testctx.eval.SwitchToSparse(ct, ct) })
+
+			ctSparse := testctx.eval.SwitchToSparseNew(ct)
+			require.Equal(t, 0, ctSparse.Level())
+
+			ctDense := testctx.eval.SwitchToDenseNew(ctSparse)
+			requireMessagesClose(t, params, msg.Value, dec.DecryptToMsgNew(ctDense), 20)
+
+			require.Panics(t, func() { NewEvaluator(params, frlwe.EvaluationKey{}).SwitchToDenseNew(ctSparse) })
+		})
+	}
+
+	t.Run("Bootstrap", func(t *testing.T) {
+		msg, ct := newTestVectors(testctx, complex(-1, -1), complex(1, 1))
+		ct = testctx.eval.DropLevelNew(ct, params.MaxLogicalLevel())
+		require.Equal(t, 0, ct.Level())
+
+		ctOut := btp.BootstrapNew(ct)
+		require.Equal(t, btp.OutputLogicalLevel(), params.LogicalLevel(ctOut.Level()))
+		require.Equal(t, ct.Scale, ctOut.Scale)
+
+		msgOut := dec.DecryptToMsgNew(ctOut)
+		requireMessagesClose(t, params, msg.Value, msgOut, 20)
+
+		// The refreshed ciphertext can be multiplied again
+		want := make([]complex128, len(msg.Value))
+		for i := range want {
+			want[i] = msg.Value[i] * msg.Value[i]
+		}
+		ctSq := testctx.eval.MulRelinNew(ctOut, ctOut)
+		require.NoError(t, testctx.eval.Rescale(ctSq, params.DefaultScale(), ctSq))
+		requireMessagesClose(t, params, want, dec.DecryptToMsgNew(ctSq), 21)
+	})
+}
+
+func testHomomorphicDFT(params, paramsSparse Parameters, t *testing.T) {
+
+	maxLevel := params.MaxLogicalLevel()
+
+	// Full slots in bit-reversed order, and sparse slots in the natural order
+	full := [2]HomomorphicDFTMatrixLiteral{
+		{Type: CoeffsToSlots, LogSlots: params.LogSlots(), LogicalLevel: maxLevel, Depth: 3, BitReversed: true},
+		{Type: SlotsToCoeffs, LogSlots: params.LogSlots(), LogicalLevel: maxLevel - 3, Depth: 3, BitReversed: true},
+	}
+	sparse := [2]HomomorphicDFTMatrixLiteral{
+		{Type: CoeffsToSlots, LogSlots: paramsSparse.LogSlots(), LogicalLevel: maxLevel, Depth: 2, Scaling: 0.25},
+		{Type: SlotsToCoeffs, LogSlots: paramsSparse.LogSlots(), LogicalLevel: maxLevel - 2, Depth: 2, Scaling: 4},
+	}
+
+	t.Run("Rotations", func(t *testing.T) {
+		ecd := ckks.NewEncoder(paramsSparse.Parameters)
+		for _, lit := range sparse {
+			m := NewHomomorphicDFTMatrixFromLiteral(paramsSparse, ecd, lit)
+			require.Len(t, m.Matrices, lit.Depth)
+			require.Equal(t, lit.LogicalLevel-lit.Depth, m.OutputLogicalLevel())
+
+			var rotations []int
+			for i, lt := range m.Matrices {
+				require.Equal(t, paramsSparse.LevelQ(lit.LogicalLevel-i), lt.Level)
+				require.Equal(t, float64(paramsSparse.Q()[lt.Level]), lt.Scale)
+				for _, k := range lt.Rotations() {
+					if !utils.IsInSliceInt(k, rotations) {
+						rotations = append(rotations, k)
+					}
+				}
+			}
+			require.ElementsMatch(t, rotations, lit.Rotations(paramsSparse))
+		}
+
+		// The butterflies of three diagonals need fewer rotations than the dense matrix
+		dense := full[0]
+		dense.Depth = 1
+		require.Less(t, 2*len(full[0].Rotations(params)), len(dense.Rotations(params)))
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		ecd := ckks.NewEncoder(params.Parameters)
+		for _, lit := range []HomomorphicDFTMatrixLiteral{
+			{Type: CoeffsToSlots, LogSlots: params.LogSlots(), LogicalLevel: maxLevel, Depth: 0},
+			{Type: CoeffsToSlots, LogSlots: 3, LogicalLevel: maxLevel, Depth: 4},
+			{Type: CoeffsToSlots, LogSlots: params.LogSlots(), LogicalLevel: 2, Depth: 3},
+			{Type: CoeffsToSlots, LogSlots: params.LogSlots() + 1, LogicalLevel: maxLevel, Depth: 1},
+		} {
+			require.Panics(t, func() { NewHomomorphicDFTMatrixFromLiteral(params, ecd, lit) })
+		}
+	})
+
+	kgen := NewKeyGenerator(params)
+	sk, pk := kgen.GenKeyPair()
+
+	for _, p := range []struct {
+		params   Parameters
+		literals [2]HomomorphicDFTMatrixLiteral
+	}{
+		{params, full},
+		{paramsSparse, sparse},
+	} {
+
+		params, literals := p.params, p.literals
+		n := params.Slots()
+		isSparse := params.LogSlots() < params.MaxLogSlots()
+		ecd := ckks.NewEncoder(params.Parameters)
+		enc := NewEncryptor(params, pk)
+		dec := NewDecryptor(params, sk)
+
+		rotations := literals[0].Rotations(params)
+		for _, k := range literals[1].Rotations(params) {
+			if !utils.IsInSliceInt(k, rotations) {
+				rotations = append(rotations, k)
+			}
+		}
+		eval := NewEvaluator(params, frlwe.EvaluationKey{Rtks: kgen.GenRotKeys(rotations, sk), Cjk: kgen.GenConjugationKey(sk)})
+
+		ctsMatrices := NewHomomorphicDFTMatrixFromLiteral(params, ecd, literals[0])
+		stcMatrices := NewHomomorphicDFTMatrixFromLiteral(params, ecd, literals[1])
+
+		testctx := &testContext{params: params, enc: enc}
+
+		t.Run(fmt.Sprintf("CoeffsToSlots/LogSlots=%d", params.LogSlots()), func(t *testing.T) {
+
+			msg, ct := newTestVectors(testctx, complex(-1, -1), complex(1, 1))
+
+			// The coefficients of the encoding of msg, on the multiples of N/2n
+			rotGroup, roots := specialFFTRoots(n, int(params.RingQ().NthRoot))
+			w := make([]complex128, n)
+			copy(w, msg.Value)
+			ckks.SpecialiFFTVec(w, n, len(roots), rotGroup, roots)
+			if literals[0].BitReversed {
+				ckks.SliceBitReverseInPlaceComplex128(w, n)
+			}
+
+			ctReal, ctImag := eval.CoeffsToSlotsNew(ct, ctsMatrices)
+			require.Equal(t, params.LevelQ(literals[0].OutputLogicalLevel()), ctReal.Level())
+			require.Equal(t, ct.Scale, ctReal.Scale)
+
+			if isSparse {
+				require.Nil(t, ctImag)
+				// The 2n coefficients, times the scaling, are on the 2n slots of ctReal
+				pt := ckks.NewPlaintext(params.Parameters, ctReal.Level(), ctReal.Scale)
+				dec.Decrypt(ctReal, pt)
+				want := make([]complex128, 2*n)
+				for j := 0; j < n; j++ {
+					want[j], want[j+n] = complex(real(w[j])/4, 0), complex(imag(w[j])/4, 0)
+				}
+				have := ecd.Decode(pt, params.LogSlots()+1)
+				for j := range want {
+					require.Less(t, cmplx.Abs(want[j]-have[j]), math.Exp2(-20))
+				}
+			} else {
+				want := make([]complex128, n)
+				for j := range want {
+					want[j] = complex(real(w[j]), 0)
+				}
+				requireMessagesClose(t, params, want, dec.DecryptToMsgNew(ctReal), 15)
+				for j := range want {
+					want[j] = complex(imag(w[j]), 0)
+				}
+				requireMessagesClose(t, params, want, dec.DecryptToMsgNew(ctImag), 15)
+			}
+
+			ctOut := eval.SlotsToCoeffsNew(ctReal, ctImag, stcMatrices)
+			require.Equal(t, params.LevelQ(literals[1].OutputLogicalLevel()), ctOut.Level())
+			require.Equal(t, ct.Scale, ctOut.Scale)
+			requireMessagesClose(t, params, msg.Value, dec.DecryptToMsgNew(ctOut), 16)
+		})
+
+		t.Run(fmt.Sprintf("CoeffsToSlots/LogSlots=%d/Errors", params.LogSlots()), func(t *testing.T) {
+			_, ct := newTestVectors(testctx, complex(-1, -1), complex(1, 1))
+			require.Panics(t, func() { eval.CoeffsToSlotsNew(ct, stcMatrices) })
+			// ctImag must be nil exactly for sparse ciphertexts
+			ctImag := ct.CopyNew()
+			if isSparse {
+				require.Panics(t, func() { eval.CoeffsToSlots(ct, ctsMatrices, ct.CopyNew(), ctImag) })
+			} else {
+				require.Panics(t, func() { eval.CoeffsToSlots(ct, ctsMatrices, ct.CopyNew(), nil) })
+			}
+			require.Panics(t, func() { eval.CoeffsToSlotsNew(eval.DropLevelNew(ct, 1), ctsMatrices) })
+		})
+	}
+}
+
+func testComparison(testctx *testContext, t *testing.T) {
+
+	params := testctx.params
+	slots := params.Slots()
+	dec := testctx.dec
+
+	// Inputs and differences of absolute value at least 1/2, on which the sign is approximated within 2^-8
+	signParams, err := NewSignParameters(1, 8, 6)
+	require.NoError(t, err)
+	cmp := NewComparator(testctx.eval, signParams)
+
+	// encrypt returns the encryption of the real values
+	encrypt := func(values []float64) *ckks.Ciphertext {
+		msg := NewMessage(params)
+		for i, v := range values {
+			msg.Value[i] = complex(v, 0)
+		}
+		return testctx.enc.EncryptMsgNew(msg)
+	}
+
+	// requireClose checks that the real parts of the slots of ct are within 2^-logBound of want, and that their
+	// imaginary parts are negligible
+	requireClose := func(want []float64, ct *ckks.Ciphertext, logBound float64) {
+		msgOut := dec.DecryptToMsgNew(ct)
+		for i := range want {
+			require.Less(t, math.Abs(real(msgOut.Value[i])-want[i]), math.Exp2(-logBound))
+			require.Less(t, math.Abs(imag(msgOut.Value[i])), math.Exp2(-logBound))
+		}
+	}
+
+	// Pairs of values whose difference is in [1/2, 3/4] in absolute value
+	a, b := make([]float64, slots), make([]float64, slots)
+	for i := range a {
+		a[i] = utils.RandFloat64(-0.25, 0.25)
+		if b[i] = a[i] + utils.RandFloat64(0.5, 0.75); i&1 == 0 {
+			b[i] = a[i] - utils.RandFloat64(0.5, 0.75)
+		}
+	}
+	ctA, ctB := encrypt(a), encrypt(b)
+
+	t.Run("SignParameters", func(t *testing.T) {
+		require.LessOrEqual(t, signParams.Depth(), 6)
+
+		for _, lit := range []SignParameters{signParams, {N: 1, LogGap: 8, LogPrecision: 10}, {N: 4, LogGap: 10, LogPrecision: 20}} {
+			dg, df := lit.Compositions()
+			pols := NewComparator(testctx.eval, lit).pols
+			require.Len(t, pols, dg+df)
+			require.Equal(t, (dg+df)*lit.fPolynomial().Depth(), lit.Depth())
+
+			// f_N(1) = 1 and the approximation holds on the inputs of absolute value at least 2^-LogGap
+			require.InDelta(t, 1, real(lit.fPolynomial().Evaluate(1)), 1e-12)
+			gap := math.Exp2(-float64(lit.LogGap))
+			for i := 0; i <= 1024; i++ {
+				x := complex(gap+(1-gap)*float64(i)/1024, 0)
+				for _, pol := range pols {
+					x = pol.Evaluate(x)
+				}
+				require.Less(t, math.Abs(real(x)-1), math.Exp2(-float64(lit.LogPrecision)))
+			}
+		}
+
+		// The selected polynomials have the smallest depth
+		p, err := NewSignParameters(8, 10, 30)
+		require.NoError(t, err)
+		require.LessOrEqual(t, p.Depth(), 30)
+		for n := 1; n <= 4; n++ {
+			require.LessOrEqual(t, p.Depth(), SignParameters{N: n, LogGap: 8, LogPrecision: 10}.Depth())
+		}
+
+		_, err = NewSignParameters(20, 20, 10)
+		require.Error(t, err)
+		require.Panics(t, func() { SignParameters{N: 5, LogGap: 1, LogPrecision: 8}.Compositions() })
+	})
+
+	t.Run("Sign", func(t *testing.T) {
+		want := make([]float64, slots)
+		for i := range want {
+			want[i] = 1
+			if a[i] < b[i] {
+				want[i] = -1
+			}
+		}
+
+		diff := testctx.eval.SubNew(ctA, ctB)
+		ctOut, err := cmp.SignNew(diff)
+		require.NoError(t, err)
+		require.Equal(t, params.LevelQ(params.MaxLogicalLevel()-signParams.Depth()), ctOut.Level())
+		require.Equal(t, diff.Scale, ctOut.Scale)
+		requireClose(want, ctOut, 7)
+	})
+
+	t.Run("Compare", func(t *testing.T) {
+		want := make([]float64, slots)
+		for i := range want {
+			if a[i] > b[i] {
+				want[i] = 1
+			}
+		}
+
+		ctOut, err := cmp.CompareNew(ctA, ctB)
+		require.NoError(t, err)
+		require.Equal(t, params.LevelQ(params.MaxLogicalLevel()-signParams.Depth()), ctOut.Level())
+		requireClose(want, ctOut, 8)
+	})
+
+	t.Run("MaxMin", func(t *testing.T) {
+		wantMax, wantMin := make([]float64, slots), make([]float64, slots)
+		for i := range wantMax {
+			wantMax[i], wantMin[i] = math.Max(a[i], b[i]), math.Min(a[i], b[i])
+		}
+
+		ctMax, err := cmp.MaxNew(ctA, ctB)
+		require.NoError(t, err)
+		require.Equal(t, params.LevelQ(params.MaxLogicalLevel()-signParams.Depth()-1), ctMax.Level())
+		require.Equal(t, ctA.Scale, ctMax.Scale)
+		requireClose(wantMax, ctMax, 8)
+
+		ctMin, err := cmp.MinNew(ctA, ctB)
+		require.NoError(t, err)
+		requireClose(wantMin, ctMin, 8)
+	})
+
+	t.Run("ReduceMax", func(t *testing.T) {
+		// Values in {-1/2, 0, 1/2}, whose differences are 0 or at least 1/2 in absolute value
+		values := make([]float64, slots)
+		for i := range values {
+			values[i] = float64(utils.RandUint64()%3)/2 - 0.5
+		}
+
+		want := make([]float64, slots)
+		for i := range want {
+			want[i] = values[i]
+			for j := 1; j < 4; j++ {
+				want[i] = math.Max(want[i], values[(i+j)%slots])
+			}
+		}
+
+		ctOut, err := cmp.ReduceMaxNew(encrypt(values), 1, 4)
+		require.NoError(t, err)
+		require.Equal(t, params.LevelQ(params.MaxLogicalLevel()-2*(signParams.Depth()+1)), ctOut.Level())
+		requireClose(want, ctOut, 7)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		ct := testctx.eval.DropLevelNew(ctA, params.MaxLogicalLevel()-signParams.Depth()+1)
+
+		_, err := cmp.SignNew(ct)
+		require.Error(t, err)
+
+		// The product of MaxNew needs one more level than the comparison
+		ct = testctx.eval.DropLevelNew(ctA, params.MaxLogicalLevel()-signParams.Depth())
+		_, err = cmp.CompareNew(ct, ctB)
+		require.NoError(t, err)
+		_, err = cmp.MaxNew(ct, ctB)
+		require.Error(t, err)
+
+		_, err = cmp.ReduceMaxNew(ctA, 1, 3)
+		require.Error(t, err)
+	})
+}
+
+func testInverse(testctx *testContext, t *testing.T) {
+
+	params := testctx.params
+	slots := params.Slots()
+	dec := testctx.dec
+	eval := testctx.eval
+
+	// newInputs returns the encryption of real values in [a, b]
+	newInputs := func(a, b float64) ([]float64, *ckks.Ciphertext) {
+		values := make([]float64, slots)
+		msg := NewMessage(params)
+		for i := range values {
+			values[i] = utils.RandFloat64(a, b)
+			msg.Value[i] = complex(values[i], 0)
+		}
+		return values, testctx.enc.EncryptMsgNew(msg)
+	}
+
+	// requireRelativeError checks that the slots of ct are within a relative error of 2^-logBound of f(values)
+	requireRelativeError := func(f func(float64) float64, values []float64, ct *ckks.Ciphertext, logBound float64) {
+		msgOut := dec.DecryptToMsgNew(ct)
+		for i, v := range values {
+			require.Less(t, math.Abs(real(msgOut.Value[i])/f(v)-1), math.Exp2(-logBound))
+			require.Less(t, math.Abs(imag(msgOut.Value[i])), math.Exp2(-logBound))
+		}
+	}
+
+	inverse := func(x float64) float64 { return 1 / x }
+	invSqrt := func(x float64) float64 { return 1 / math.Sqrt(x) }
+
+	t.Run("NewtonParameters", func(t *testing.T) {
+		// e = 1/2 on [1, 3], and e^(2^(d+1)) <= 2^-20 for d = 4
+		p := NewtonParameters{A: 1, B: 3, LogPrecision: 20}
+		require.Equal(t, 4, p.InverseIterations())
+		require.Equal(t, 6, p.InverseDepth())
+
+		p.Iterations = 2
+		require.Equal(t, 2, p.InverseIterations())
+		require.Equal(t, 2, p.InvSqrtIterations())
+		require.Equal(t, 4+2*2, p.InvSqrtDepth())
+
+		// The error of the Newton iterations is squared at each iteration
+		p = NewtonParameters{A: 1, B: 16, LogPrecision: 30}
+		iterations := p.InvSqrtIterations()
+		p.LogPrecision = 60
+		require.Panics(t, func() { p.InvSqrtIterations() })
+		p.LogPrecision = 15
+		require.Less(t, p.InvSqrtIterations(), iterations)
+
+		require.Panics(t, func() { NewtonParameters{A: 0, B: 1, LogPrecision: 8}.InverseIterations() })
+		require.Panics(t, func() { NewtonParameters{A: 2, B: 1, LogPrecision: 8}.InverseIterations() })
+		require.Panics(t, func() { NewtonParameters{A: 1, B: 2}.InverseIterations() })
+
+		// A guess far from 1/sqrt(x) makes the iterations diverge
+		p = NewtonParameters{A: 1, B: 4, LogPrecision: 8, InitialGuess: NewPolynomial(Monomial, []complex128{2, 0}, 0, 0)}
+		require.Panics(t, func() { p.InvSqrtIterations() })
+	})
+
+	t.Run("Inverse", func(t *testing.T) {
+		for _, p := range []NewtonParameters{{A: 1, B: 8, LogPrecision: 24}, {A: 0.5, B: 1.5, Iterations: 2}} {
+			values, ct := newInputs(p.A, p.B)
+
+			ctOut, err := eval.InverseNew(ct, p)
+			require.NoError(t, err)
+			require.Equal(t, params.LevelQ(params.MaxLogicalLevel()-p.InverseDepth()), ctOut.Level())
+
+			// 2 iterations from e <= 1/2 give a relative error of at most 2^-8
+			logBound := 22.0
+			if p.Iterations > 0 {
+				logBound = 8
+			}
+			requireRelativeError(inverse, values, ctOut, logBound)
+		}
+	})
+
+	t.Run("InvSqrt", func(t *testing.T) {
+		p := NewtonParameters{A: 1, B: 16, LogPrecision: 24}
+		values, ct := newInputs(p.A, p.B)
+
+		ctOut, err := eval.InvSqrtNew(ct, p)
+		require.NoError(t, err)
+		require.Equal(t, params.LevelQ(params.MaxLogicalLevel()-p.InvSqrtDepth()), ctOut.Level())
+		requireRelativeError(invSqrt, values, ctOut, 22)
+
+		// From a linear guess, which needs more iterations
+		p = NewtonParameters{A: 1, B: 4, LogPrecision: 20, InitialGuess: NewPolynomial(Monomial, []complex128{7.0 / 6, -1.0 / 6}, 0, 0)}
+		require.Equal(t, 1, p.initialGuess().Depth())
+		values, ct = newInputs(p.A, p.B)
+
+		ctOut, err = eval.InvSqrtNew(ct, p)
+		require.NoError(t, err)
+		require.Equal(t, params.LevelQ(params.MaxLogicalLevel()-p.InvSqrtDepth()), ctOut.Level())
+		requireRelativeError(invSqrt, values, ctOut, 18)
+	})
+
+	t.Run("Sqrt", func(t *testing.T) {
+		p := NewtonParameters{A: 1, B: 16, LogPrecision: 24}
+		values, ct := newInputs(p.A, p.B)
+
+		ctOut, err := eval.SqrtNew(ct, p)
+		require.NoError(t, err)
+		require.Equal(t, params.LevelQ(params.MaxLogicalLevel()-p.InvSqrtDepth()-1), ctOut.Level())
+		requireRelativeError(math.Sqrt, values, ctOut, 22)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		p := NewtonParameters{A: 1, B: 16, LogPrecision: 24}
+		_, ct := newInputs(p.A, p.B)
+
+		ct = eval.DropLevelNew(ct, params.MaxLogicalLevel()-p.InvSqrtDepth())
+		_, err := eval.InvSqrtNew(ct, p)
+		require.NoError(t, err)
+		_, err = eval.SqrtNew(ct, p)
+		require.Error(t, err)
+
+		ct = eval.DropLevelNew(ct, 1)
+		_, err = eval.InvSqrtNew(ct, p)
+		require.Error(t, err)
+		_, err = eval.InverseNew(ct, NewtonParameters{A: 1, B: 16, Iterations: 20})
+		require.Error(t, err)
+	})
+}
+
+func testMatrixMultiplication(testctx *testContext, sizes [][3]int, t *testing.T) {
+
+	params := testctx.params
+
+	randomMatrix := func(rows, cols int) (m [][]complex128) {
+		m = make([][]complex128, rows)
+		for i := range m {
+			m[i] = make([]complex128, cols)
+			for j := range m[i] {
+				m[i][j] = complex(utils.RandFloat64(-1, 1), utils.RandFloat64(-1, 1))
+			}
+		}
+		return
+	}
+
+	for _, size := range sizes {
+
+		rows, inner, cols := size[0], size[1], size[2]
+
+		t.Run(fmt.Sprintf("MulNew/%dx%dx%d", rows, inner, cols), func(t *testing.T) {
+			mm := NewMatrixMultiplier(testctx.eval, rows, inner, cols, params.MaxLogicalLevel())
+			require.Equal(t, utils.MaxInt(utils.MaxInt(rows, inner), cols), mm.Dim())
+
+			a, b := randomMatrix(rows, inner), randomMatrix(inner, cols)
+			ctA := testctx.enc.EncryptMsgNew(mm.PackLeft(a))
+			ctB := testctx.enc.EncryptMsgNew(mm.PackRight(b))
+
+			ctOut, err := mm.MulNew(ctA, ctB)
+			require.NoError(t, err)
+			require.Equal(t, params.LevelQ(params.MaxLogicalLevel()-mm.Depth()), ctOut.Level())
+
+			product := mm.UnpackProduct(testctx.dec.DecryptToMsgNew(ctOut))
+			for i := 0; i < rows; i++ {
+				for j := 0; j < cols; j++ {
+					var want complex128
+					for k := 0; k < inner; k++ {
+						want += a[i][k] * b[k][j]
+					}
+					require.Less(t, cmplx.Abs(product[i][j]-want), math.Exp2(-20))
+				}
+			}
+
+			// The packing leaves the slots outside of the product to 0
+			msgOut := testctx.dec.DecryptToMsgNew(ctOut)
+			for i := rows * mm.Dim(); i < params.Slots(); i++ {
+				require.Less(t, cmplx.Abs(msgOut.Value[i]), math.Exp2(-20))
+			}
+		})
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		require.Panics(t, func() { NewMatrixMultiplier(testctx.eval, 46, 45, 45, params.MaxLogicalLevel()) })
+		require.Panics(t, func() { NewMatrixMultiplier(testctx.eval, 4, 4, 4, 2) })
+		require.Panics(t, func() { params.RotationsForMatrixMul(1, 64, 1) })
+
+		mm := NewMatrixMultiplier(testctx.eval, 2, 3, 4, params.MaxLogicalLevel()-1)
+		require.Panics(t, func() { mm.PackLeft(randomMatrix(3, 2)) })
+		require.Panics(t, func() { mm.PackRight(randomMatrix(3, 3)) })
+
+		ct := testctx.enc.EncryptMsgNew(mm.PackLeft(randomMatrix(2, 3)))
+		_, err := mm.MulNew(testctx.eval.DropLevelNew(ct, 2), ct)
+		require.Error(t, err)
+	})
+}
+
+func testReshape(testctx *testContext, sizes [][2]int, t *testing.T) {
+
+	params := testctx.params
+	slots := params.Slots()
+
+	// encrypt encrypts the first n slots of values, the other slots being zero
+	encrypt := func(n int) (values []complex128, ct *ckks.Ciphertext) {
+		msg := NewMessage(params)
+		for i := 0; i < n; i++ {
+			msg.Value[i] = complex(utils.RandFloat64(-1, 1), utils.RandFloat64(-1, 1))
+		}
+		return msg.Value, testctx.enc.EncryptMsgNew(msg)
+	}
+
+	// verify checks the slots of ct against want, for want(i) of the slots i
+	verify := func(t *testing.T, ct *ckks.Ciphertext, level int, want func(i int) complex128) {
+		require.Equal(t, level, ct.Level())
+		require.Equal(t, params.DefaultScale(), ct.Scale)
+		msg := testctx.dec.DecryptToMsgNew(ct)
+		for i := 0; i < slots; i++ {
+			require.Less(t, cmplx.Abs(msg.Value[i]-want(i)), math.Exp2(-20))
+		}
+	}
+
+	maxLevel := params.MaxLogicalLevel()
+	levelOut := params.LevelQ(maxLevel - 1)
+
+	for _, size := range sizes {
+
+		rows, cols := size[0], size[1]
+
+		// The permutations are encoded once for the inputs at the top logical level and for their results
+		rs := NewReshaper(testctx.eval, rows, cols, maxLevel)
+		rsBelow := NewReshaper(testctx.eval, rows, cols, maxLevel-1)
+		rsTransposed := NewReshaper(testctx.eval, cols, rows, maxLevel-1)
+
+		t.Run(fmt.Sprintf("Transpose/%dx%d", rows, cols), func(t *testing.T) {
+			values, ct := encrypt(rows * cols)
+			transposed, err := rs.TransposeNew(ct)
+			require.NoError(t, err)
+			verify(t, transposed, levelOut, func(i int) complex128 {
+				if i >= rows*cols {
+					return 0
+				}
+				return values[(i%rows)*cols+i/rows]
+			})
+
+			ctOut, err := rsTransposed.TransposeNew(transposed)
+			require.NoError(t, err)
+			verify(t, ctOut, params.LevelQ(maxLevel-2), func(i int) complex128 { return values[i] })
+		})
+
+		t.Run(fmt.Sprintf("RowToColumn/%dx%d", rows, cols), func(t *testing.T) {
+			values, ct := encrypt(rows)
+			column, err := rs.RowToColumnNew(ct)
+			require.NoError(t, err)
+			verify(t, column, levelOut, func(i int) complex128 {
+				if i%cols != 0 || i >= rows*cols {
+					return 0
+				}
+				return values[i/cols]
+			})
+
+			verify(t, testctx.eval.ReplicateColumnsNew(column, rows, cols), levelOut, func(i int) complex128 {
+				if i >= rows*cols {
+					return 0
+				}
+				return values[i/cols]
+			})
+
+			row, err := rsBelow.ColumnToRowNew(column)
+			require.NoError(t, err)
+			verify(t, row, params.LevelQ(maxLevel-2), func(i int) complex128 {
+				return values[i]
+			})
+
+			// An input above the logical level of the Reshaper is evaluated at its level
+			columnBelow, err := rsBelow.RowToColumnNew(ct)
+			require.NoError(t, err)
+			verify(t, columnBelow, params.LevelQ(maxLevel-2), func(i int) complex128 {
+				if i%cols != 0 || i >= rows*cols {
+					return 0
+				}
+				return values[i/cols]
+			})
+		})
+
+		t.Run(fmt.Sprintf("ReplicateRows/%dx%d", rows, cols), func(t *testing.T) {
+			values, ct := encrypt(cols)
+			verify(t, testctx.eval.ReplicateRowsNew(ct, rows, cols), ct.Level(), func(i int) complex128 {
+				if i >= rows*cols {
+					return 0
+				}
+				return values[i%cols]
+			})
+		})
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		require.Panics(t, func() { params.RotationsForTranspose(46, 45) })
+		require.Panics(t, func() { params.RotationsForRowToColumn(0, 4) })
+		require.Panics(t, func() { NewReshaper(testctx.eval, 46, 45, maxLevel) })
+		require.Panics(t, func() { NewReshaper(testctx.eval, 4, 4, 0) })
+
+		_, ct := encrypt(16)
+		rs := NewReshaper(testctx.eval, 4, 4, maxLevel)
+		_, err := rs.TransposeNew(testctx.eval.DropLevelNew(ct, 1))
+		require.Error(t, err)
+	})
+}
+
+func testStatistics(testctx *testContext, t *testing.T) {
+
+	params := testctx.params
+	eval := testctx.eval
+	maxLevel := params.MaxLogicalLevel()
+
+	// Three columns of n values: x in [-1, 1], y correlated with x and z in [0, 2]
+	n := 100
+	columns := make([][]float64, 3)
+	for i := range columns {
+		columns[i] = make([]float64, n)
+	}
+	for i := 0; i < n; i++ {
+		columns[0][i] = utils.RandFloat64(-1, 1)
+		columns[1][i] = 0.6*columns[0][i] + 0.4*utils.RandFloat64(-1, 1)
+		columns[2][i] = utils.RandFloat64(0, 2)
+	}
+
+	cts := make([]*ckks.Ciphertext, len(columns))
+	for i, column := range columns {
+		msg := NewMessage(params)
+		for j, v := range column {
+			msg.Value[j] = complex(v, 0)
+		}
+		cts[i] = testctx.enc.EncryptMsgNew(msg)
+	}
+
+	mean := func(x []float64) (m float64) {
+		for _, v := range x {
+			m += v
+		}
+		return m / float64(len(x))
+	}
+
+	covariance := func(x, y []float64) (c float64) {
+		mx, my := mean(x), mean(y)
+		for i := range x {
+			c += (x[i] - mx) * (y[i] - my)
+		}
+		return c / float64(len(x))
+	}
+
+	// requireReplicated checks that all the slots of ct hold want up to 2^-logBound
+	requireReplicated := func(t *testing.T, ct *ckks.Ciphertext, want float64, logBound float64) {
+		msg := testctx.dec.DecryptToMsgNew(ct)
+		for _, v := range msg.Value {
+			require.Less(t, cmplx.Abs(v-complex(want, 0)), math.Exp2(-logBound))
+		}
+	}
+
+	t.Run("SumNew", func(t *testing.T) {
+		ct := eval.SumNew(cts[0])
+		require.Equal(t, cts[0].Level(), ct.Level())
+		requireReplicated(t, ct, mean(columns[0])*float64(n), 20)
+	})
+
+	t.Run("MeanNew", func(t *testing.T) {
+		ct, err := eval.MeanNew(cts[2], n)
+		require.NoError(t, err)
+		require.Equal(t, params.LevelQ(maxLevel-1), ct.Level())
+		require.Equal(t, cts[2].Scale, ct.Scale)
+		requireReplicated(t, ct, mean(columns[2]), 20)
+	})
+
+	t.Run("VarianceNew", func(t *testing.T) {
+		ct, err := eval.VarianceNew(cts[1], n)
+		require.NoError(t, err)
+		require.Equal(t, params.LevelQ(maxLevel-2), ct.Level())
+		requireReplicated(t, ct, covariance(columns[1], columns[1]), 20)
+	})
+
+	t.Run("CovarianceMatrixNew", func(t *testing.T) {
+		cov, err := eval.CovarianceMatrixNew(cts, n)
+		require.NoError(t, err)
+		for i := range cov {
+			for j := range cov[i] {
+				require.Equal(t, params.LevelQ(maxLevel-2), cov[i][j].Level())
+				requireReplicated(t, cov[i][j], covariance(columns[i], columns[j]), 20)
+			}
+		}
+
+		ct, err := eval.CovarianceNew(cts[0], cts[2], n)
+		require.NoError(t, err)
+		requireReplicated(t, ct, covariance(columns[0], columns[2]), 20)
+	})
+
+	// The variances are in [0.05, 0.5]
+	p := NewtonParameters{A: 0.05, B: 0.5, LogPrecision: 16}
+
+	t.Run("CorrelationMatrixNew", func(t *testing.T) {
+		corr, err := eval.CorrelationMatrixNew(cts, n, p)
+		require.NoError(t, err)
+		for i := range corr {
+			for j := range corr[i] {
+				require.Equal(t, params.LevelQ(maxLevel-p.CorrelationDepth()), corr[i][j].Level())
+				want := covariance(columns[i], columns[j]) / math.Sqrt(covariance(columns[i], columns[i])*covariance(columns[j], columns[j]))
+				requireReplicated(t, corr[i][j], want, 12)
+			}
+		}
+
+		ct, err := eval.CorrelationNew(cts[0], cts[1], n, p)
+		require.NoError(t, err)
+		requireReplicated(t, ct, covariance(columns[0], columns[1])/math.Sqrt(covariance(columns[0], columns[0])*covariance(columns[1], columns[1])), 12)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		_, err := eval.MeanNew(cts[0], 0)
+		require.Error(t, err)
+
+		_, err = eval.VarianceNew(eval.DropLevelNew(cts[0], maxLevel-1), n)
+		require.Error(t, err)
+
+		_, err = eval.CorrelationNew(cts[0], eval.DropLevelNew(cts[1], maxLevel-p.CorrelationDepth()+1), n, p)
+		require.Error(t, err)
+	})
 }
@@ -0,0 +1,240 @@
+package fckks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"fast-ksw/frlwe"
+	"fast-ksw/ring"
+	"fast-ksw/rlwe"
+	"fast-ksw/utils"
+)
+
+// KeySwitchingMethod identifies one of the key-switching methods of a hybrid Evaluator.
+type KeySwitchingMethod int
+
+const (
+	// Classic is the hybrid key switching of rlwe.KeySwitcher.
+	Classic KeySwitchingMethod = iota
+	// Fast is the key switching over the auxiliary modulus T of frlwe.KeySwitcher.
+	Fast
+)
+
+// CostTable stores, for each level, the cost in nanoseconds of a key switch with the Classic and
+// with the Fast method. It can be saved as a profile with WriteCostTable and loaded with ReadCostTable.
+type CostTable struct {
+	Classic []float64
+	Fast    []float64
+}
+
+// Method returns the cheaper key-switching method at the given level.
+func (ct *CostTable) Method(level int) KeySwitchingMethod {
+	if ct.Classic[level] < ct.Fast[level] {
+		return Classic
+	}
+	return Fast
+}
+
+// WriteCostTable writes ct on w in JSON.
+func WriteCostTable(w io.Writer, ct *CostTable) error {
+	return json.NewEncoder(w).Encode(ct)
+}
+
+// ReadCostTable reads a CostTable written by WriteCostTable from r.
+func ReadCostTable(r io.Reader) (ct *CostTable, err error) {
+	ct = new(CostTable)
+	if err = json.NewDecoder(r).Decode(ct); err != nil {
+		return nil, err
+	}
+	return ct, nil
+}
+
+// MeasureCostTable measures the cost of a key switch with each method at every level, using the
+// relinearization keys of evk and evkClassic. Each entry is the fastest of nbRuns key switches.
+func MeasureCostTable(params Parameters, evk frlwe.EvaluationKey, evkClassic rlwe.EvaluationKey, nbRuns int) *CostTable {
+
+	rlk, ok := newHybridKeySet(params, evk, evkClassic).GetRelinearizationKey()
+	if !ok {
+		panic("cannot MeasureCostTable: both relinearization keys are needed")
+	}
+
+	prng, err := utils.NewPRNG()
+	if err != nil {
+		panic(err)
+	}
+
+	ksw := newHybridKeySwitcher(params, nil)
+	ringQ := params.RingQ()
+
+	cx := ringQ.NewPoly()
+	ring.NewUniformSampler(prng, ringQ).Read(cx)
+	c0, c1 := ringQ.NewPoly(), ringQ.NewPoly()
+
+	measure := func(backend rlwe.KeySwitchingBackend, swk rlwe.KeySwitchingKey, level int) float64 {
+		best := math.Inf(1)
+		for i := 0; i < nbRuns; i++ {
+			start := time.Now()
+			backend.Switch(level, cx, swk, c0, c1)
+			best = math.Min(best, float64(time.Since(start).Nanoseconds()))
+		}
+		return best
+	}
+
+	hk := rlk.(*hybridKey)
+
+	ct := &CostTable{Classic: make([]float64, params.MaxLevel()+1), Fast: make([]float64, params.MaxLevel()+1)}
+	for level := 0; level <= params.MaxLevel(); level++ {
+		ct.Classic[level] = measure(ksw.classic, hk.classic, level)
+		ct.Fast[level] = measure(ksw.fast, hk.fast, level)
+	}
+
+	return ct
+}
+
+// NewHybridEvaluator creates a new Evaluator holding both the frlwe keys of evk and the rlwe keys of
+// evkClassic. At each key switch, it uses the method that is the cheaper at the level of the
+// ciphertext according to costs. If costs is nil, the table is measured at construction with
+// MeasureCostTable. The keys for an operation must be present in both evk and evkClassic.
+// RotateWithKey and SwitchKeysFast, whose keys are given as frlwe keys, always use the frlwe.KeySwitcher.
+func NewHybridEvaluator(params Parameters, evk frlwe.EvaluationKey, evkClassic rlwe.EvaluationKey, costs *CostTable) (eval *Evaluator) {
+
+	if costs == nil {
+		costs = MeasureCostTable(params, evk, evkClassic, 3)
+	}
+
+	if len(costs.Classic) != params.MaxLevel()+1 || len(costs.Fast) != params.MaxLevel()+1 {
+		panic("cannot NewHybridEvaluator: the cost table does not match the levels of the parameters")
+	}
+
+	eval = newEvaluator(params, newHybridKeySwitcher(params, costs), newHybridKeySet(params, evk, evkClassic))
+	eval.ek = evk.Ek
+	eval.costs = costs
+
+	return
+}
+
+// CostTable returns the cost table of a hybrid Evaluator, or nil if the Evaluator is not hybrid.
+func (eval *Evaluator) CostTable() *CostTable {
+	return eval.costs
+}
+
+// hybridKey holds the key of the same switch for both methods.
+type hybridKey struct {
+	classic *rlwe.SwitchingKey
+	fast    rlwe.KeySwitchingKey
+}
+
+// LevelQ returns the maximum level of the ciphertexts both keys can switch.
+func (hk *hybridKey) LevelQ() int {
+	return utils.MinInt(hk.classic.LevelQ(), hk.fast.LevelQ())
+}
+
+// fastKey returns the frlwe key of swk if it is a hybrid key, and swk otherwise.
+func fastKey(swk rlwe.KeySwitchingKey) rlwe.KeySwitchingKey {
+	if hk, isHybrid := swk.(*hybridKey); isHybrid {
+		return hk.fast
+	}
+	return swk
+}
+
+// hybridKeySet pairs the keys of an frlwe.EvaluationKey and of an rlwe.EvaluationKey.
+type hybridKeySet struct {
+	fast    *frlwe.EvaluationKeySet
+	classic rlwe.EvaluationKey
+}
+
+func newHybridKeySet(params Parameters, evk frlwe.EvaluationKey, evkClassic rlwe.EvaluationKey) *hybridKeySet {
+	return &hybridKeySet{fast: frlwe.NewEvaluationKeySet(params.frlweParams, evk), classic: evkClassic}
+}
+
+func (hks *hybridKeySet) pair(classic, fast rlwe.KeySwitchingKey, okClassic, okFast bool) (rlwe.KeySwitchingKey, bool) {
+	if !okClassic || !okFast {
+		return nil, false
+	}
+	return &hybridKey{classic: classic.(*rlwe.SwitchingKey), fast: fast}, true
+}
+
+func (hks *hybridKeySet) GetRelinearizationKey() (rlwe.KeySwitchingKey, bool) {
+	classic, okClassic := hks.classic.GetRelinearizationKey()
+	fast, okFast := hks.fast.GetRelinearizationKey()
+	return hks.pair(classic, fast, okClassic, okFast)
+}
+
+func (hks *hybridKeySet) GetGaloisKey(galEl uint64) (rlwe.KeySwitchingKey, bool) {
+	classic, okClassic := hks.classic.GetGaloisKey(galEl)
+	fast, okFast := hks.fast.GetGaloisKey(galEl)
+	return hks.pair(classic, fast, okClassic, okFast)
+}
+
+func (hks *hybridKeySet) GaloisElements() (galEls []uint64) {
+	for _, galEl := range hks.fast.GaloisElements() {
+		if _, ok := hks.classic.GetGaloisKey(galEl); ok {
+			galEls = append(galEls, galEl)
+		}
+	}
+	return
+}
+
+// hybridKeySwitcher implements rlwe.KeySwitchingBackend by delegating each call to the
+// rlwe.KeySwitcher or to the frlwe.KeySwitcher, according to the cost table at the level of the call.
+type hybridKeySwitcher struct {
+	classic *rlwe.KeySwitcher
+	fast    *frlwe.KeySwitcher
+	costs   *CostTable
+}
+
+func newHybridKeySwitcher(params Parameters, costs *CostTable) *hybridKeySwitcher {
+	return &hybridKeySwitcher{
+		classic: rlwe.NewKeySwitcher(params.Parameters.Parameters),
+		fast:    frlwe.NewKeySwitcher(params.frlweParams),
+		costs:   costs,
+	}
+}
+
+func (ksw *hybridKeySwitcher) backend(levelQ int) rlwe.KeySwitchingBackend {
+	if ksw.costs.Method(levelQ) == Classic {
+		return ksw.classic
+	}
+	return ksw.fast
+}
+
+func (ksw *hybridKeySwitcher) key(levelQ int, swk rlwe.KeySwitchingKey) rlwe.KeySwitchingKey {
+	hk, ok := swk.(*hybridKey)
+	if !ok {
+		panic(fmt.Sprintf("cannot switch keys: key is a %T and not a hybrid key", swk))
+	}
+	if ksw.costs.Method(levelQ) == Classic {
+		return hk.classic
+	}
+	return hk.fast
+}
+
+func (ksw *hybridKeySwitcher) Switch(levelQ int, cx *ring.Poly, swk rlwe.KeySwitchingKey, c0, c1 *ring.Poly) {
+	ksw.backend(levelQ).Switch(levelQ, cx, ksw.key(levelQ, swk), c0, c1)
+}
+
+func (ksw *hybridKeySwitcher) SwitchNoModDown(levelQ int, cx *ring.Poly, swk rlwe.KeySwitchingKey, c0Q, c0P, c1Q, c1P *ring.Poly) {
+	ksw.backend(levelQ).SwitchNoModDown(levelQ, cx, ksw.key(levelQ, swk), c0Q, c0P, c1Q, c1P)
+}
+
+// NewDecomposition allocates a Decomposition for both methods.
+func (ksw *hybridKeySwitcher) NewDecomposition() *rlwe.Decomposition {
+	return &rlwe.Decomposition{QP: ksw.classic.NewDecomposition().QP, T: ksw.fast.NewDecomposition().T}
+}
+
+// Decompose computes the decomposition of the method of levelQ, so the hoisted key switches
+// must be called at the same level as Decompose.
+func (ksw *hybridKeySwitcher) Decompose(levelQ int, cx *ring.Poly, decomp *rlwe.Decomposition) {
+	ksw.backend(levelQ).Decompose(levelQ, cx, decomp)
+}
+
+func (ksw *hybridKeySwitcher) SwitchHoisted(levelQ int, decomp *rlwe.Decomposition, swk rlwe.KeySwitchingKey, c0Q, c1Q, c0P, c1P *ring.Poly) {
+	ksw.backend(levelQ).SwitchHoisted(levelQ, decomp, ksw.key(levelQ, swk), c0Q, c1Q, c0P, c1P)
+}
+
+func (ksw *hybridKeySwitcher) SwitchHoistedNoModDown(levelQ int, decomp *rlwe.Decomposition, swk rlwe.KeySwitchingKey, c0Q, c1Q, c0P, c1P *ring.Poly) {
+	ksw.backend(levelQ).SwitchHoistedNoModDown(levelQ, decomp, ksw.key(levelQ, swk), c0Q, c1Q, c0P, c1P)
+}
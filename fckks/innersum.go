@@ -0,0 +1,137 @@
+package fckks
+
+import (
+	"sort"
+
+	"fast-ksw/ckks"
+	"fast-ksw/utils"
+)
+
+// RotationsForInnerSumLog returns the rotations used by Evaluator.InnerSum with parameters batch and n, sorted.
+// The keys are generated with frlwe.KeyGenerator.GenRotKeys. It shadows ckks.Parameters.RotationsForInnerSumLog,
+// whose rotations are a superset of the ones of the rotate-and-add procedure.
+func (p Parameters) RotationsForInnerSumLog(batch, n int) (rotations []int) {
+
+	rotIndex := make(map[int]bool)
+
+	for i := 0; n>>i > 0; i++ {
+		if k := innerSumOffset(n, i) * batch; (n>>i)&1 == 1 && k != 0 {
+			rotIndex[k] = true
+		}
+		if n>>(i+1) > 0 {
+			rotIndex[(1<<i)*batch] = true
+		}
+	}
+
+	rotations = make([]int, 0, len(rotIndex))
+	for k := range rotIndex {
+		rotations = append(rotations, k)
+	}
+	sort.Ints(rotations)
+
+	return
+}
+
+// RotationsForReplicateLog returns the rotations used by Evaluator.Replicate with parameters batch and n, sorted.
+// It shadows ckks.Parameters.RotationsForReplicateLog as RotationsForInnerSumLog does.
+func (p Parameters) RotationsForReplicateLog(batch, n int) (rotations []int) {
+	return p.RotationsForInnerSumLog(-batch, n)
+}
+
+// innerSumOffset returns, in units of batch, the rotation applied to the partial sum of 2^i
+// elements when the bit i of n is set: the number of elements accounted for by the higher bits of n.
+func innerSumOffset(n, i int) int {
+	return n - (n & ((2 << i) - 1))
+}
+
+// InnerSumNew sums n elements of ctIn spaced by batch as in InnerSum and returns the result in a
+// newly created element.
+func (eval *Evaluator) InnerSumNew(ctIn *ckks.Ciphertext, batch, n int) (ctOut *ckks.Ciphertext) {
+	ctOut = ckks.NewCiphertext(eval.params.Parameters, 1, ctIn.Level(), ctIn.Scale)
+	eval.InnerSum(ctIn, batch, n, ctOut)
+	return
+}
+
+// InnerSum sets each slot i of ctOut to the sum of the slots i + j*batch of ctIn for 0 <= j < n
+// (indexes taken modulo the number of slots), with O(log(n)) rotations and additions.
+// The rotation keys of Parameters.RotationsForInnerSumLog(batch, n) must be in the EvaluationKey.
+// ctIn and ctOut can be the same ciphertext.
+func (eval *Evaluator) InnerSum(ctIn *ckks.Ciphertext, batch, n int, ctOut *ckks.Ciphertext) {
+
+	if ctIn.Degree() != 1 || ctOut.Degree() != 1 {
+		panic("cannot InnerSum: input and output Ciphertext must be of degree 1")
+	}
+
+	if n < 1 {
+		panic("cannot InnerSum: n must be at least 1")
+	}
+
+	level := utils.MinInt(ctIn.Level(), ctOut.Level())
+
+	// Partial sum of the 2^i first elements
+	ctSum := ciphertextAtLevel(eval.ctxRotate[0], 1, level)
+	ctSum.Copy(ctIn)
+
+	ctRot := ciphertextAtLevel(eval.ctxRotate[1], 1, level)
+
+	for i := range ctOut.Value {
+		ctOut.Value[i].Coeffs = ctOut.Value[i].Coeffs[:level+1]
+	}
+
+	var accumulated bool
+	for i := 0; n>>i > 0; i++ {
+
+		// Adds the partial sum, rotated past the elements of the higher bits of n
+		if (n>>i)&1 == 1 {
+			k := innerSumOffset(n, i) * batch
+			if !accumulated {
+				eval.Rotate(ctSum, k, ctOut)
+				accumulated = true
+			} else if k == 0 {
+				eval.Evaluator.Add(ctOut, ctSum, ctOut)
+			} else {
+				eval.Rotate(ctSum, k, ctRot)
+				eval.Evaluator.Add(ctOut, ctRot, ctOut)
+			}
+		}
+
+		if n>>(i+1) > 0 {
+			eval.Rotate(ctSum, (1<<i)*batch, ctRot)
+			eval.Evaluator.Add(ctSum, ctRot, ctSum)
+		}
+	}
+}
+
+// ReplicateNew replicates ctIn as in Replicate and returns the result in a newly created element.
+func (eval *Evaluator) ReplicateNew(ctIn *ckks.Ciphertext, batch, n int) (ctOut *ckks.Ciphertext) {
+	ctOut = ckks.NewCiphertext(eval.params.Parameters, 1, ctIn.Level(), ctIn.Scale)
+	eval.Replicate(ctIn, batch, n, ctOut)
+	return
+}
+
+// Replicate sets each slot i of ctOut to the sum of the slots i - j*batch of ctIn for 0 <= j < n,
+// so that a vector of batch values followed by zeros is copied n times.
+// The rotation keys of Parameters.RotationsForReplicateLog(batch, n) must be in the EvaluationKey.
+func (eval *Evaluator) Replicate(ctIn *ckks.Ciphertext, batch, n int, ctOut *ckks.Ciphertext) {
+	eval.InnerSum(ctIn, -batch, n, ctOut)
+}
+
+// TraceNew applies the trace of Trace to ctIn and returns the result in a newly created element.
+func (eval *Evaluator) TraceNew(ctIn *ckks.Ciphertext, logSlotsStart, logSlotsEnd int) (ctOut *ckks.Ciphertext) {
+	ctOut = ckks.NewCiphertext(eval.params.Parameters, 1, ctIn.Level(), ctIn.Scale)
+	eval.Trace(ctIn, logSlotsStart, logSlotsEnd, ctOut)
+	return
+}
+
+// Trace sets each slot i of ctOut to the sum of the slots i + j*2^logSlotsStart of ctIn for
+// 0 <= j < 2^(logSlotsEnd-logSlotsStart). With logSlotsEnd equal to the LogSlots of the parameters, each
+// slot is the sum of all the slots congruent to it modulo 2^logSlotsStart. It uses logSlotsEnd-logSlotsStart
+// rotations, whose keys are given by ckks.Parameters.RotationsForTrace(logSlotsStart, logSlotsEnd).
+func (eval *Evaluator) Trace(ctIn *ckks.Ciphertext, logSlotsStart, logSlotsEnd int, ctOut *ckks.Ciphertext) {
+
+	if logSlotsStart < 0 || logSlotsEnd < logSlotsStart {
+		panic("cannot Trace: invalid logSlotsStart or logSlotsEnd")
+	}
+
+	eval.InnerSum(ctIn, 1<<logSlotsStart, 1<<(logSlotsEnd-logSlotsStart), ctOut)
+}
@@ -0,0 +1,211 @@
+package fckks
+
+import (
+	"errors"
+	"math"
+
+	"fast-ksw/ckks"
+)
+
+// initialGuessDegree is the degree of the default initial guess of InvSqrtNew.
+const initialGuessDegree = 7
+
+// NewtonParameters are the parameters of the iterative methods of InverseNew, InvSqrtNew and SqrtNew, which
+// approximate 1/x, 1/sqrt(x) and sqrt(x) on the slots x in [A, B].
+type NewtonParameters struct {
+	// A and B bound the inputs, with 0 < A < B.
+	A, B float64
+	// Iterations is the number of iterations. If it is 0, the smallest number of iterations achieving LogPrecision
+	// on [A, B] is used.
+	Iterations int
+	// LogPrecision is the log2 of the inverse of the relative error of the result, used if Iterations is 0.
+	LogPrecision int
+	// InitialGuess is the polynomial approximating 1/sqrt(x) on [A, B] from which the Newton iterations of InvSqrtNew
+	// start. If it is nil, the Chebyshev interpolant of degree 7 of 1/sqrt(x) on [A, B] is used.
+	InitialGuess *Polynomial
+}
+
+// InverseIterations returns the number of iterations of InverseNew.
+func (p NewtonParameters) InverseIterations() (iterations int) {
+
+	p.check()
+
+	if p.Iterations > 0 {
+		return p.Iterations
+	}
+
+	// The relative error after d iterations is e^(2^(d+1)), for e the largest |1 - x/m| with m the middle of [A, B]
+	logErr := -math.Log2((p.B - p.A) / (p.B + p.A))
+	for float64(int(2)<<iterations)*logErr < float64(p.LogPrecision) {
+		iterations++
+	}
+
+	return
+}
+
+// InvSqrtIterations returns the number of iterations of InvSqrtNew. If Iterations is 0, they are found by running
+// the iterations in the clear from the initial guess on points of [A, B].
+func (p NewtonParameters) InvSqrtIterations() (iterations int) {
+
+	p.check()
+
+	if p.Iterations > 0 {
+		return p.Iterations
+	}
+
+	guess := p.initialGuess()
+
+	x := make([]float64, approximationSamples+1)
+	y := make([]float64, approximationSamples+1)
+	for i := range x {
+		x[i] = p.A + (p.B-p.A)*float64(i)/approximationSamples
+		y[i] = real(guess.Evaluate(complex(x[i], 0)))
+	}
+
+	bound := math.Exp2(-float64(p.LogPrecision))
+	for ; iterations <= 64; iterations++ {
+
+		var maxErr float64
+		for i := range x {
+			maxErr = math.Max(maxErr, math.Abs(y[i]*math.Sqrt(x[i])-1))
+		}
+
+		if maxErr <= bound {
+			return
+		}
+
+		for i := range y {
+			y[i] *= (3 - x[i]*y[i]*y[i]) / 2
+		}
+	}
+
+	panic("cannot InvSqrtIterations: the Newton iterations do not converge from the initial guess")
+}
+
+// InverseDepth returns the number of logical levels consumed by InverseNew.
+func (p NewtonParameters) InverseDepth() int {
+	if iterations := p.InverseIterations(); iterations > 0 {
+		return iterations + 2
+	}
+	return 1
+}
+
+// InvSqrtDepth returns the number of logical levels consumed by InvSqrtNew. SqrtNew consumes one more.
+func (p NewtonParameters) InvSqrtDepth() int {
+	return p.initialGuess().Depth() + 2*p.InvSqrtIterations()
+}
+
+// check panics if the NewtonParameters are invalid.
+func (p NewtonParameters) check() {
+	if p.A <= 0 || p.B <= p.A || p.Iterations < 0 || (p.Iterations == 0 && p.LogPrecision < 1) {
+		panic("cannot NewtonParameters: invalid interval, number of iterations or precision")
+	}
+}
+
+// initialGuess returns the initial guess of InvSqrtNew.
+func (p NewtonParameters) initialGuess() *Polynomial {
+
+	if p.InitialGuess != nil {
+		return p.InitialGuess
+	}
+
+	return ChebyshevInterpolation(func(x complex128) complex128 {
+		return complex(1/math.Sqrt(real(x)), 0)
+	}, p.A, p.B, initialGuessDegree)
+}
+
+// InverseNew approximates 1/x on the slots x of ctIn, in [A, B], with the division algorithm of Goldschmidt, and
+// returns the result in a newly created element, InverseDepth() logical levels below. With m the middle of [A, B]
+// and e = 1 - x/m, it computes (1/m) * (1 + e) * (1 + e^2) * (1 + e^4) * ... * (1 + e^(2^d)) for d iterations.
+// It returns an error if ctIn is not of degree 1 or has not enough levels.
+func (eval *Evaluator) InverseNew(ctIn *ckks.Ciphertext, p NewtonParameters) (ctOut *ckks.Ciphertext, err error) {
+
+	if err = eval.checkInput("Inverse", ctIn, p.InverseDepth()); err != nil {
+		return nil, err
+	}
+
+	pe := NewPolynomialEvaluator(eval)
+
+	// e = 1 - x/m and a = (1 + e)/m, at the same level
+	c := 2 / (p.A + p.B)
+
+	e, err := pe.EvaluateNew(ctIn, NewPolynomial(Monomial, []complex128{1, complex(-c, 0)}, 0, 0), ctIn.Scale)
+	if err != nil {
+		return nil, err
+	}
+
+	if ctOut, err = pe.EvaluateNew(ctIn, NewPolynomial(Monomial, []complex128{complex(2*c, 0), complex(-c*c, 0)}, 0, 0), ctIn.Scale); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < p.InverseIterations(); i++ {
+		e = eval.mulRescaleLogicalLevelNew(e, e)
+		ctOut = eval.mulRescaleLogicalLevelNew(ctOut, eval.AddConstNew(e, 1))
+	}
+
+	return ctOut, nil
+}
+
+// InvSqrtNew approximates 1/sqrt(x) on the slots x of ctIn, in [A, B], with Newton iterations
+// y <- y * (3 - x * y^2) / 2 from the initial guess, and returns the result in a newly created element,
+// InvSqrtDepth() logical levels below. Each iteration consumes two logical levels, computing (x/2) * y^3
+// as (x/2 * y) * y^2.
+// It returns an error if ctIn is not of degree 1 or has not enough levels.
+func (eval *Evaluator) InvSqrtNew(ctIn *ckks.Ciphertext, p NewtonParameters) (ctOut *ckks.Ciphertext, err error) {
+
+	if err = eval.checkInput("InvSqrt", ctIn, p.InvSqrtDepth()); err != nil {
+		return nil, err
+	}
+
+	if ctOut, err = NewPolynomialEvaluator(eval).EvaluateNew(ctIn, p.initialGuess(), ctIn.Scale); err != nil {
+		return nil, err
+	}
+
+	// x/2, by doubling the scale of x
+	xHalf := &ckks.Ciphertext{Ciphertext: ctIn.Ciphertext, Scale: 2 * ctIn.Scale}
+
+	for i := 0; i < p.InvSqrtIterations(); i++ {
+
+		t := eval.mulRescaleLogicalLevelNew(eval.mulRescaleLogicalLevelNew(xHalf, ctOut), eval.mulRescaleLogicalLevelNew(ctOut, ctOut))
+
+		// 3/2 * y, brought to the scale of t on a buffer
+		y := eval.scaleTo(ctOut, 1.5*t.Scale)
+		y.Scale = t.Scale
+
+		ctOut = ckks.NewCiphertext(eval.params.Parameters, 1, t.Level(), t.Scale)
+		eval.Evaluator.Sub(y, t, ctOut)
+	}
+
+	return ctOut, nil
+}
+
+// SqrtNew approximates sqrt(x) on the slots x of ctIn, in [A, B], as x * InvSqrtNew(x), and returns the result in
+// a newly created element, InvSqrtDepth()+1 logical levels below.
+// It returns an error if ctIn is not of degree 1 or has not enough levels.
+func (eval *Evaluator) SqrtNew(ctIn *ckks.Ciphertext, p NewtonParameters) (ctOut *ckks.Ciphertext, err error) {
+
+	if err = eval.checkInput("Sqrt", ctIn, p.InvSqrtDepth()+1); err != nil {
+		return nil, err
+	}
+
+	if ctOut, err = eval.InvSqrtNew(ctIn, p); err != nil {
+		return nil, err
+	}
+
+	return eval.mulRescaleLogicalLevelNew(ctIn, ctOut), nil
+}
+
+// checkInput returns an error for the operation op if ctIn is not of degree 1 or has less than depth logical
+// levels.
+func (eval *Evaluator) checkInput(op string, ctIn *ckks.Ciphertext, depth int) error {
+
+	if ctIn.Degree() != 1 {
+		return errors.New("cannot " + op + ": input Ciphertext must be of degree 1")
+	}
+
+	if eval.params.LogicalLevel(ctIn.Level()) < depth {
+		return errors.New("cannot " + op + ": input Ciphertext has not enough levels")
+	}
+
+	return nil
+}
@@ -2,8 +2,17 @@ package fckks
 
 import (
 	"fast-ksw/frlwe"
+	"fast-ksw/utils"
 )
 
 func NewKeyGenerator(params Parameters) (kgen *frlwe.KeyGenerator) {
 	return frlwe.NewKeyGenerator(params.frlweParams)
 }
+
+func NewKeyGeneratorWithPRNG(params Parameters, prng utils.PRNG) (kgen *frlwe.KeyGenerator) {
+	return frlwe.NewKeyGeneratorWithPRNG(params.frlweParams, prng)
+}
+
+func NewParallelKeyGenerator(params Parameters, seed []byte, nbWorkers int) (pkgen *frlwe.ParallelKeyGenerator) {
+	return frlwe.NewParallelKeyGenerator(params.frlweParams, seed, nbWorkers)
+}
@@ -0,0 +1,469 @@
+package fckks
+
+import (
+	"fmt"
+	"sort"
+
+	"fast-ksw/ckks"
+	"fast-ksw/ring"
+	"fast-ksw/rlwe"
+	"fast-ksw/utils"
+)
+
+// LinearTransform is a plaintext matrix acting on the slots of a ciphertext, stored by its non-zero
+// diagonals: the diagonal k holds the entries M[i][i+k] for 0 <= i < 2^LogSlots. The diagonals are
+// encoded as ckks plaintexts on both Q and P, so that they can multiply the key-switched ciphertexts
+// before their division by P, and are pre-rotated for the baby-step giant-step evaluation with N1 baby steps.
+type LinearTransform struct {
+	LogSlots int
+	N1       int
+	Level    int
+	Scale    float64
+	Vec      map[int]rlwe.PolyQP
+}
+
+// NewLinearTransform encodes the diagonals of a matrix, indexed by their diagonal k (taken modulo the
+// number of slots), on a LinearTransform at the given level and scale. The number of baby steps is
+// chosen to minimize the number of rotations of the evaluation.
+func NewLinearTransform(params Parameters, ecd ckks.Encoder, diagonals map[int][]complex128, level int, scale float64, logSlots int) LinearTransform {
+
+	diags := make([]int, 0, len(diagonals))
+	for k := range diagonals {
+		diags = append(diags, k)
+	}
+
+	return newLinearTransform(params, ecd, diagonals, level, scale, logSlots, findBestBSGSSplit(diags, 1<<logSlots))
+}
+
+// NewLinearTransformHoisted encodes the diagonals of a matrix as in NewLinearTransform, for the
+// evaluation with Evaluator.LinearTransformHoisted, in which all the rotations are baby steps.
+func NewLinearTransformHoisted(params Parameters, ecd ckks.Encoder, diagonals map[int][]complex128, level int, scale float64, logSlots int) LinearTransform {
+	return newLinearTransform(params, ecd, diagonals, level, scale, logSlots, 1<<logSlots)
+}
+
+func newLinearTransform(params Parameters, ecd ckks.Encoder, diagonals map[int][]complex128, level int, scale float64, logSlots, n1 int) LinearTransform {
+
+	slots := 1 << logSlots
+
+	lt := LinearTransform{LogSlots: logSlots, N1: n1, Level: level, Scale: scale}
+	lt.Vec = make(map[int]rlwe.PolyQP, len(diagonals))
+
+	ringQP := params.RingQP()
+	levelP := params.PCount() - 1
+
+	values := make([]complex128, slots)
+	for k, diag := range diagonals {
+
+		if len(diag) != slots {
+			panic(fmt.Sprintf("cannot NewLinearTransform: diagonal %d has %d values instead of %d", k, len(diag), slots))
+		}
+
+		k = ((k % slots) + slots) % slots
+		if _, ok := lt.Vec[k]; ok {
+			panic(fmt.Sprintf("cannot NewLinearTransform: diagonal %d is given twice", k))
+		}
+
+		// The diagonal k = j + i is evaluated as rot_j(rot_-j(diag) * rot_i(ct))
+		j := k - k%lt.N1
+		for t := range values {
+			values[t] = diag[(t-j+slots)%slots]
+		}
+
+		lt.Vec[k] = ringQP.NewPolyLvl(level, levelP)
+		ecd.Embed(values, logSlots, scale, true, lt.Vec[k])
+	}
+
+	return lt
+}
+
+// Rotations returns the rotations whose keys are needed to evaluate lt with Evaluator.LinearTransform.
+// The keys are generated with frlwe.KeyGenerator.GenRotKeys.
+func (lt LinearTransform) Rotations() (rotations []int) {
+	diags := make([]int, 0, len(lt.Vec))
+	for k := range lt.Vec {
+		diags = append(diags, k)
+	}
+	return bsgsRotations(diags, lt.N1)
+}
+
+// RotationsForLinearTransform returns the rotations used by Evaluator.LinearTransform to evaluate the LinearTransform
+// of the given diagonals created by NewLinearTransform for 2^logSlots slots, without encoding it.
+// The keys are generated with frlwe.KeyGenerator.GenRotKeys.
+func (p Parameters) RotationsForLinearTransform(diagonals []int, logSlots int) []int {
+
+	slots := 1 << logSlots
+
+	diags := make([]int, len(diagonals))
+	for i, k := range diagonals {
+		diags[i] = ((k % slots) + slots) % slots
+	}
+
+	return bsgsRotations(diags, findBestBSGSSplit(diags, slots))
+}
+
+// bsgsRotations returns the non-zero baby steps and giant steps of the evaluation of the diagonals diags
+// with n1 baby steps.
+func bsgsRotations(diags []int, n1 int) (rotations []int) {
+
+	_, babySteps, giantSteps := bsgsIndex(diags, n1)
+
+	for _, i := range babySteps {
+		if i != 0 {
+			rotations = append(rotations, i)
+		}
+	}
+
+	for _, j := range giantSteps {
+		if j != 0 {
+			rotations = append(rotations, j)
+		}
+	}
+
+	return
+}
+
+// bsgsIndex returns, for each giant step j, the baby steps i such that j+i is a diagonal of lt,
+// along with the sorted lists of all the baby steps and of all the giant steps.
+func (lt LinearTransform) bsgsIndex() (index map[int][]int, babySteps, giantSteps []int) {
+	diags := make([]int, 0, len(lt.Vec))
+	for k := range lt.Vec {
+		diags = append(diags, k)
+	}
+	return bsgsIndex(diags, lt.N1)
+}
+
+func bsgsIndex(diags []int, n1 int) (index map[int][]int, babySteps, giantSteps []int) {
+
+	index = make(map[int][]int)
+	isBabyStep := make(map[int]bool)
+
+	for _, k := range diags {
+		j, i := k-k%n1, k%n1
+		if _, ok := index[j]; !ok {
+			giantSteps = append(giantSteps, j)
+		}
+		index[j] = append(index[j], i)
+		if !isBabyStep[i] {
+			isBabyStep[i] = true
+			babySteps = append(babySteps, i)
+		}
+	}
+
+	for j := range index {
+		sort.Ints(index[j])
+	}
+	sort.Ints(babySteps)
+	sort.Ints(giantSteps)
+
+	return
+}
+
+// findBestBSGSSplit returns the power of two number of baby steps minimizing the number of rotations
+// needed to evaluate the diagonals diags, given modulo slots.
+func findBestBSGSSplit(diags []int, slots int) (n1 int) {
+
+	normalized := make([]int, len(diags))
+	for i, k := range diags {
+		normalized[i] = ((k % slots) + slots) % slots
+	}
+
+	best := -1
+	for m := 1; m <= slots; m <<= 1 {
+		_, babySteps, giantSteps := bsgsIndex(normalized, m)
+		if nbRotations := len(babySteps) + len(giantSteps); best < 0 || nbRotations < best {
+			best, n1 = nbRotations, m
+		}
+	}
+
+	return
+}
+
+// linearTransformBuffers holds the memory used by Evaluator.LinearTransform, allocated on first use.
+// The baby steps are grown to the largest number of baby steps evaluated so far.
+type linearTransformBuffers struct {
+	decomp    *rlwe.Decomposition
+	poolQ     [2]*ring.Poly
+	poolQP    [6]rlwe.PolyQP
+	babySteps [][2]rlwe.PolyQP
+	convQP    *ring.BasisExtender
+}
+
+func (eval *Evaluator) linearTransformBuffers() *linearTransformBuffers {
+
+	if eval.ltBuffers == nil {
+
+		params := eval.params
+		buf := new(linearTransformBuffers)
+
+		buf.decomp = eval.GetKeySwitcher().NewDecomposition()
+		for i := range buf.poolQ {
+			buf.poolQ[i] = params.RingQ().NewPoly()
+		}
+		for i := range buf.poolQP {
+			buf.poolQP[i] = params.RingQP().NewPoly()
+		}
+		buf.convQP = ring.NewBasisExtender(params.RingQ(), params.RingP())
+
+		eval.ltBuffers = buf
+	}
+
+	return eval.ltBuffers
+}
+
+// rotatedBabySteps returns n buffers for the baby steps of LinearTransform, allocating the missing ones.
+func (buf *linearTransformBuffers) rotatedBabySteps(ringQP *rlwe.RingQP, n int) [][2]rlwe.PolyQP {
+	for len(buf.babySteps) < n {
+		buf.babySteps = append(buf.babySteps, [2]rlwe.PolyQP{ringQP.NewPoly(), ringQP.NewPoly()})
+	}
+	return buf.babySteps[:n]
+}
+
+// galoisKeyAndIndex returns the key and the NTT permutation index of the rotation by k.
+func (eval *Evaluator) galoisKeyAndIndex(k int) (rlwe.KeySwitchingKey, []uint64) {
+
+	galEl := eval.params.GaloisElementForColumnRotationBy(k)
+
+	swk, ok := eval.evks.GetGaloisKey(galEl)
+	if !ok {
+		panic(fmt.Sprintf("cannot LinearTransform: rotation key k=%d not available", k))
+	}
+
+	return swk, eval.permuteNTTIndex[galEl]
+}
+
+// LinearTransformNew evaluates lt on ctIn as in LinearTransform and returns the result in a newly created element.
+func (eval *Evaluator) LinearTransformNew(ctIn *ckks.Ciphertext, lt LinearTransform) (ctOut *ckks.Ciphertext) {
+	ctOut = ckks.NewCiphertext(eval.params.Parameters, 1, utils.MinInt(ctIn.Level(), lt.Level), ctIn.Scale)
+	eval.LinearTransform(ctIn, lt, ctOut)
+	return
+}
+
+// LinearTransform multiplies the matrix lt with the vector encrypted in ctIn and returns the result in ctOut,
+// at the scale ctIn.Scale * lt.Scale. The product is rescaled according to the rescaling policy, as in Mul.
+//
+// The evaluation uses the baby-step giant-step algorithm: the baby-step rotations of ctIn share a single
+// gadget decomposition and are not divided by P. Each giant step sums the products of its diagonals with
+// the baby steps over QP, divides only the second polynomial of the sum by P to key switch it, and adds the
+// rotation, still multiplied by P, on the accumulator. The accumulator is divided by P once at the end.
+// The keys of lt.Rotations() must be in the EvaluationKey.
+func (eval *Evaluator) LinearTransform(ctIn *ckks.Ciphertext, lt LinearTransform, ctOut *ckks.Ciphertext) {
+
+	if ctIn.Degree() != 1 || ctOut.Degree() != 1 {
+		panic("cannot LinearTransform: input and output Ciphertext must be of degree 1")
+	}
+
+	eval.linearTransform(eval.rescaleOperand(ctIn, 0).(*ckks.Ciphertext), lt, ctOut)
+	eval.rescaleProduct(ctOut)
+}
+
+// LinearTransformAndRescaleNew evaluates lt on ctIn as in LinearTransformAndRescale and returns the result in a
+// newly created element.
+func (eval *Evaluator) LinearTransformAndRescaleNew(ctIn *ckks.Ciphertext, lt LinearTransform) (ctOut *ckks.Ciphertext) {
+	params := eval.params
+	level := utils.MinInt(ctIn.Level(), lt.Level)
+	ctOut = ckks.NewCiphertext(params.Parameters, 1, params.LevelQ(params.LogicalLevel(level)-1), ctIn.Scale)
+	eval.LinearTransformAndRescale(ctIn, lt, ctOut)
+	return
+}
+
+// LinearTransformAndRescale multiplies the matrix lt with the vector encrypted in ctIn as LinearTransform does, but
+// whatever the rescaling policy, and divides the product by the primes of its last logical level in ctOut. With lt
+// encoded at the scale Parameters.LevelModulus(lt.Level), as the homomorphic DFT does, ctOut has the scale of ctIn.
+// The product is evaluated at the smallest level of ctIn and lt, which must be the last prime of a logical level
+// above 0. The keys of lt.Rotations() must be in the EvaluationKey.
+func (eval *Evaluator) LinearTransformAndRescale(ctIn *ckks.Ciphertext, lt LinearTransform, ctOut *ckks.Ciphertext) {
+
+	params := eval.params
+
+	if ctIn.Degree() != 1 || ctOut.Degree() != 1 {
+		panic("cannot LinearTransformAndRescale: input and output Ciphertext must be of degree 1")
+	}
+
+	level := utils.MinInt(ctIn.Level(), lt.Level)
+	if params.LogicalLevel(level) < 1 || !params.IsLogicalLevelBoundary(level) {
+		panic("cannot LinearTransformAndRescale: the product must be at the last prime of a logical level above 0")
+	}
+
+	ctTmp := ciphertextAtLevel(eval.ctxPool, 1, level)
+	eval.linearTransform(ctIn, lt, ctTmp)
+	eval.rescaleLogicalLevel(ctTmp, ctOut)
+}
+
+// linearTransform evaluates lt on ctIn as LinearTransform does, regardless of the rescaling policy.
+func (eval *Evaluator) linearTransform(ctIn *ckks.Ciphertext, lt LinearTransform, ctOut *ckks.Ciphertext) {
+
+	params := eval.params
+	ringQ := params.RingQ()
+	ringQP := params.RingQP()
+	ksw := eval.GetKeySwitcher()
+	buf := eval.linearTransformBuffers()
+
+	levelQ := utils.MinInt(utils.MinInt(ctIn.Level(), lt.Level), ctOut.Level())
+	levelP := params.PCount() - 1
+
+	index, babySteps, giantSteps := lt.bsgsIndex()
+
+	pc0, c1Q := buf.poolQ[0], buf.poolQ[1]
+	tmp := [2]rlwe.PolyQP{buf.poolQP[0], buf.poolQP[1]}
+	acc := [2]rlwe.PolyQP{buf.poolQP[2], buf.poolQP[3]}
+	ks := [2]rlwe.PolyQP{buf.poolQP[4], buf.poolQP[5]}
+
+	// Baby steps, multiplied by P: the step 0 is P*ctIn, which is 0 mod P
+	ringQ.MulScalarBigintLvl(levelQ, ctIn.Value[0], params.RingP().ModulusBigint, pc0)
+	ringQ.MulScalarBigintLvl(levelQ, ctIn.Value[1], params.RingP().ModulusBigint, c1Q)
+
+	ksw.Decompose(levelQ, ctIn.Value[1], buf.decomp)
+
+	// The baby step i is at the position of i in the sorted babySteps
+	rotated := buf.rotatedBabySteps(ringQP, len(babySteps))
+	for b, i := range babySteps {
+
+		ct := rotated[b]
+
+		if i == 0 {
+			// The buffers are reused: their P parts may hold a rotation of a previous evaluation
+			ring.CopyValuesLvl(levelQ, pc0, ct[0].Q)
+			ring.CopyValuesLvl(levelQ, c1Q, ct[1].Q)
+			ct[0].P.Zero()
+			ct[1].P.Zero()
+		} else {
+			swk, permIndex := eval.galoisKeyAndIndex(i)
+			ksw.SwitchHoistedNoModDown(levelQ, buf.decomp, swk, ks[0].Q, ks[1].Q, ks[0].P, ks[1].P)
+			ringQ.AddLvl(levelQ, ks[0].Q, pc0, ks[0].Q)
+			ringQP.PermuteNTTWithIndexLvl(levelQ, levelP, ks[0], permIndex, ct[0])
+			ringQP.PermuteNTTWithIndexLvl(levelQ, levelP, ks[1], permIndex, ct[1])
+		}
+	}
+
+	for n, j := range giantSteps {
+
+		// Inner sum of the giant step over QP
+		for m, i := range index[j] {
+			diag := lt.Vec[j+i]
+			ct := rotated[sort.SearchInts(babySteps, i)]
+			for c := range tmp {
+				if m == 0 {
+					ringQP.MulCoeffsMontgomeryLvl(levelQ, levelP, diag, ct[c], tmp[c])
+				} else {
+					ringQP.MulCoeffsMontgomeryAndAddLvl(levelQ, levelP, diag, ct[c], tmp[c])
+				}
+			}
+		}
+
+		if j == 0 {
+			for c := range acc {
+				if n == 0 {
+					ringQP.CopyValuesLvl(levelQ, levelP, tmp[c], acc[c])
+				} else {
+					ringQP.AddLvl(levelQ, levelP, acc[c], tmp[c], acc[c])
+				}
+			}
+			continue
+		}
+
+		// Giant-step rotation: only the second polynomial needs to be brought back to Q
+		buf.convQP.ModDownQPtoQNTT(levelQ, levelP, tmp[1].Q, tmp[1].P, c1Q)
+		c1Q.IsNTT = true
+
+		swk, permIndex := eval.galoisKeyAndIndex(j)
+		ksw.SwitchNoModDown(levelQ, c1Q, swk, ks[0].Q, ks[0].P, ks[1].Q, ks[1].P)
+		ringQP.AddLvl(levelQ, levelP, ks[0], tmp[0], ks[0])
+
+		for c := range acc {
+			if n == 0 {
+				ringQP.PermuteNTTWithIndexLvl(levelQ, levelP, ks[c], permIndex, acc[c])
+			} else {
+				ringQP.PermuteNTTWithIndexLvl(levelQ, levelP, ks[c], permIndex, tmp[c])
+				ringQP.AddLvl(levelQ, levelP, acc[c], tmp[c], acc[c])
+			}
+		}
+	}
+
+	for c := range acc {
+		ctOut.Value[c].Coeffs = ctOut.Value[c].Coeffs[:levelQ+1]
+		buf.convQP.ModDownQPtoQNTT(levelQ, levelP, acc[c].Q, acc[c].P, ctOut.Value[c])
+		ctOut.Value[c].IsNTT = true
+	}
+
+	ctOut.Scale = ctIn.Scale * lt.Scale
+}
+
+// LinearTransformHoistedNew evaluates lt on ctIn as in LinearTransformHoisted and returns the result
+// in a newly created element.
+func (eval *Evaluator) LinearTransformHoistedNew(ctIn *ckks.Ciphertext, lt LinearTransform) (ctOut *ckks.Ciphertext) {
+	ctOut = ckks.NewCiphertext(eval.params.Parameters, 1, utils.MinInt(ctIn.Level(), lt.Level), ctIn.Scale)
+	eval.LinearTransformHoisted(ctIn, lt, ctOut)
+	return
+}
+
+// LinearTransformHoisted multiplies the matrix lt, created with NewLinearTransformHoisted, with the vector
+// encrypted in ctIn and returns the result in ctOut as LinearTransform does, with the frlwe key switching
+// whatever the backend of the Evaluator.
+//
+// All the rotations share a single decomposition of ctIn in T. The key switch of each rotation is multiplied
+// by its diagonal as soon as it is extended from T to QP, and summed there, so that each polynomial of ctOut
+// is divided by P only once. The parts of the rotations that need no key switch are summed over Q alone.
+// The keys of lt.Rotations() must be in the EvaluationKey.
+func (eval *Evaluator) LinearTransformHoisted(ctIn *ckks.Ciphertext, lt LinearTransform, ctOut *ckks.Ciphertext) {
+
+	if ctIn.Degree() != 1 || ctOut.Degree() != 1 {
+		panic("cannot LinearTransformHoisted: input and output Ciphertext must be of degree 1")
+	}
+
+	if lt.N1 < 1<<lt.LogSlots {
+		panic("cannot LinearTransformHoisted: lt must be created with NewLinearTransformHoisted")
+	}
+
+	ctIn = eval.rescaleOperand(ctIn, 0).(*ckks.Ciphertext)
+
+	buf := eval.linearTransformBuffers()
+	eval.ksw.Decompose(utils.MinInt(utils.MinInt(ctIn.Level(), lt.Level), ctOut.Level()), ctIn.Value[1], buf.decomp)
+	eval.linearTransformHoisted(ctIn, buf.decomp, lt, ctOut)
+
+	eval.rescaleProduct(ctOut)
+}
+
+// linearTransformHoisted evaluates lt on ctIn as LinearTransformHoisted does, regardless of the rescaling policy,
+// with decomp the decomposition of ctIn.Value[1] in T at the level of the result. Several linear transforms of the
+// same ciphertext can thus share its decomposition.
+func (eval *Evaluator) linearTransformHoisted(ctIn *ckks.Ciphertext, decomp *rlwe.Decomposition, lt LinearTransform, ctOut *ckks.Ciphertext) {
+
+	params := eval.params
+	ringQ := params.RingQ()
+	buf := eval.linearTransformBuffers()
+
+	levelQ := utils.MinInt(utils.MinInt(ctIn.Level(), lt.Level), ctOut.Level())
+	levelP := params.PCount() - 1
+
+	// Sums of diag * phi(ks) over QP, and of diag * phi(ctIn) over Q
+	accQP := [2]rlwe.PolyQP{buf.poolQP[0], buf.poolQP[1]}
+	accQ := buf.poolQ
+
+	for c := range accQP {
+		accQP[c].Q.Zero()
+		accQP[c].P.Zero()
+		accQ[c].Zero()
+	}
+
+	for k, diag := range lt.Vec {
+
+		if k == 0 {
+			ringQ.MulCoeffsMontgomeryAndAddLvl(levelQ, diag.Q, ctIn.Value[0], accQ[0])
+			ringQ.MulCoeffsMontgomeryAndAddLvl(levelQ, diag.Q, ctIn.Value[1], accQ[1])
+			continue
+		}
+
+		swk, index := eval.galoisKeyAndIndex(k)
+		eval.ksw.SwitchHoistedPermuteAndMulAddNoModDown(levelQ, decomp, fastKey(swk), index, diag, accQP[0], accQP[1])
+		ringQ.PermuteNTTWithIndexAndMulCoeffsMontgomeryAndAddLvl(levelQ, ctIn.Value[0], index, diag.Q, accQ[0])
+	}
+
+	for c := range accQP {
+		ctOut.Value[c].Coeffs = ctOut.Value[c].Coeffs[:levelQ+1]
+		buf.convQP.ModDownQPtoQNTT(levelQ, levelP, accQP[c].Q, accQP[c].P, ctOut.Value[c])
+		ringQ.AddLvl(levelQ, ctOut.Value[c], accQ[c], ctOut.Value[c])
+		ctOut.Value[c].IsNTT = true
+	}
+
+	ctOut.Scale = ctIn.Scale * lt.Scale
+}
@@ -0,0 +1,278 @@
+package fckks
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"fast-ksw/ckks"
+	"fast-ksw/rlwe"
+)
+
+// MatrixMultiplier multiplies encrypted matrices with the algorithm of Jiang, Kim, Lauter and Song, "Secure
+// Outsourced Matrix Computation and Application to Neural Networks". The product of a Rows x Inner matrix A by
+// an Inner x Cols matrix B is computed on their padding to Dim() x Dim() matrices with Dim() the largest of the
+// three sizes, packed row-major in the first Dim()^2 slots, as
+//
+//	A * B = sum_{k=0}^{Dim()-1} phi^k(sigma(A)) * psi^k(tau(B)),
+//
+// where sigma(A)[i][j] = A[i][i+j], tau(B)[i][j] = B[i+j][j], and phi^k and psi^k shift the columns and the rows
+// by k, all the indexes being taken modulo Dim(). The permutations are linear transforms on the slots, evaluated
+// with hoisted frlwe rotations: the 2*Dim() shifts of sigma(A) and of tau(B) share one decomposition each, and the
+// products are summed before a single relinearization, fused with the rescaling.
+//
+// The multiplication consumes 3 logical levels. The matrices are encoded for ciphertexts at the logical level given
+// at the creation of the MatrixMultiplier, so that the rescalings divide exactly by the scales of the matrices, and
+// the product has the scale ctA.Scale * ctB.Scale / Q, where Q is the modulus of the logical level it is rescaled
+// from.
+type MatrixMultiplier struct {
+	*Evaluator
+	Rows, Inner, Cols int
+	LogicalLevel      int
+
+	dim        int
+	sigma, tau LinearTransform
+	phi, psi   []LinearTransform
+	decomp     [2]*rlwe.Decomposition
+}
+
+// NewMatrixMultiplier creates a new MatrixMultiplier of Rows x Inner by Inner x Cols matrices for the ciphertexts of
+// eval at the given logical level, using the keys of eval. The rotation keys of Parameters.RotationsForMatrixMul
+// and the relinearization key, which must be a frlwe.RelinKey, must be in the EvaluationKey.
+func NewMatrixMultiplier(eval *Evaluator, rows, inner, cols, logicalLevel int) (mm *MatrixMultiplier) {
+
+	params := eval.params
+
+	if rows < 1 || inner < 1 || cols < 1 {
+		panic("cannot NewMatrixMultiplier: the sizes of the matrices must be positive")
+	}
+
+	if logicalLevel < 3 || logicalLevel > params.MaxLogicalLevel() {
+		panic("cannot NewMatrixMultiplier: the logical level must be between 3 and MaxLogicalLevel")
+	}
+
+	mm = &MatrixMultiplier{Evaluator: eval, Rows: rows, Inner: inner, Cols: cols, LogicalLevel: logicalLevel}
+	mm.dim = matrixDim(params, rows, inner, cols)
+
+	ecd := ckks.NewEncoder(params.Parameters)
+
+	// The permutations of a level are encoded at the scale of its modulus
+	encode := func(diagonals map[int][]complex128, logicalLevel int) LinearTransform {
+		level := params.LevelQ(logicalLevel)
+		return NewLinearTransformHoisted(params, ecd, diagonals, level, mm.params.levelModulusFloat(level), params.LogSlots())
+	}
+
+	sigma, tau, phi, psi := matrixPermutations(params, mm.dim)
+
+	mm.sigma, mm.tau = encode(sigma, logicalLevel), encode(tau, logicalLevel)
+	mm.phi, mm.psi = make([]LinearTransform, mm.dim), make([]LinearTransform, mm.dim)
+	for k := 0; k < mm.dim; k++ {
+		mm.phi[k], mm.psi[k] = encode(phi[k], logicalLevel-1), encode(psi[k], logicalLevel-1)
+	}
+
+	mm.decomp = [2]*rlwe.Decomposition{eval.ksw.NewDecomposition(), eval.ksw.NewDecomposition()}
+
+	return
+}
+
+// RotationsForMatrixMul returns the rotations used by MatrixMultiplier.MulNew on Rows x Inner by Inner x Cols
+// matrices. The keys are generated with frlwe.KeyGenerator.GenRotKeys.
+func (p Parameters) RotationsForMatrixMul(rows, inner, cols int) (rotations []int) {
+
+	sigma, tau, phi, psi := matrixPermutations(p, matrixDim(p, rows, inner, cols))
+
+	rotIndex := make(map[int]bool)
+	for _, diagonals := range append(append([]map[int][]complex128{sigma, tau}, phi...), psi...) {
+		for k := range diagonals {
+			if k != 0 {
+				rotIndex[k] = true
+			}
+		}
+	}
+
+	rotations = make([]int, 0, len(rotIndex))
+	for k := range rotIndex {
+		rotations = append(rotations, k)
+	}
+	sort.Ints(rotations)
+
+	return
+}
+
+// Dim returns the size of the square matrices on which the product is computed.
+func (mm *MatrixMultiplier) Dim() int {
+	return mm.dim
+}
+
+// Depth returns the number of logical levels consumed by MulNew.
+func (mm *MatrixMultiplier) Depth() int {
+	return 3
+}
+
+// PackLeft packs the Rows x Inner matrix a into a new Message, to be encrypted as the left operand of MulNew.
+func (mm *MatrixMultiplier) PackLeft(a [][]complex128) *Message {
+	return mm.pack(a, mm.Rows, mm.Inner)
+}
+
+// PackRight packs the Inner x Cols matrix b into a new Message, to be encrypted as the right operand of MulNew.
+func (mm *MatrixMultiplier) PackRight(b [][]complex128) *Message {
+	return mm.pack(b, mm.Inner, mm.Cols)
+}
+
+// UnpackProduct returns the Rows x Cols matrix packed in the decryption msg of the result of MulNew.
+func (mm *MatrixMultiplier) UnpackProduct(msg *Message) (m [][]complex128) {
+	m = make([][]complex128, mm.Rows)
+	for i := range m {
+		m[i] = make([]complex128, mm.Cols)
+		copy(m[i], msg.Value[i*mm.dim:])
+	}
+	return
+}
+
+// pack packs the rows x cols matrix m row-major in a Dim() x Dim() matrix padded with zeros.
+func (mm *MatrixMultiplier) pack(m [][]complex128, rows, cols int) (msg *Message) {
+
+	if len(m) != rows {
+		panic(fmt.Sprintf("cannot Pack: the matrix has %d rows instead of %d", len(m), rows))
+	}
+
+	msg = NewMessage(mm.params)
+	for i, row := range m {
+		if len(row) != cols {
+			panic(fmt.Sprintf("cannot Pack: row %d has %d columns instead of %d", i, len(row), cols))
+		}
+		copy(msg.Value[i*mm.dim:], row)
+	}
+
+	return
+}
+
+// MulNew multiplies the matrices packed in ctA and ctB and returns their product, packed as the operands, in a
+// newly created element, Depth() logical levels below LogicalLevel. The ciphertexts must be at least at
+// LogicalLevel, and are evaluated at this level.
+// It returns an error if the ciphertexts are not of degree 1 or are below LogicalLevel.
+func (mm *MatrixMultiplier) MulNew(ctA, ctB *ckks.Ciphertext) (ctOut *ckks.Ciphertext, err error) {
+
+	params := mm.params
+
+	if ctA.Degree() != 1 || ctB.Degree() != 1 {
+		return nil, errors.New("cannot MatrixMul: input Ciphertexts must be of degree 1")
+	}
+
+	if params.LogicalLevel(ctA.Level()) < mm.LogicalLevel || params.LogicalLevel(ctB.Level()) < mm.LogicalLevel {
+		return nil, errors.New("cannot MatrixMul: input Ciphertexts are below the logical level of the MatrixMultiplier")
+	}
+
+	// sigma(A) and tau(B), and their decompositions shared by their shifts
+	level := params.LevelQ(mm.LogicalLevel - 1)
+	ctSigma, ctTau := mm.permute(ctA, mm.sigma), mm.permute(ctB, mm.tau)
+	mm.ksw.Decompose(level, ctSigma.Value[1], mm.decomp[0])
+	mm.ksw.Decompose(level, ctTau.Value[1], mm.decomp[1])
+
+	// Sum of the products of the shifts, of degree 2
+	levelOut := params.LevelQ(mm.LogicalLevel - 2)
+	acc := ckks.NewCiphertext(params.Parameters, 2, levelOut, 1)
+	prod := ckks.NewCiphertext(params.Parameters, 2, levelOut, 1)
+	tmp := ckks.NewCiphertext(params.Parameters, 1, level, 1)
+	phi := ckks.NewCiphertext(params.Parameters, 1, levelOut, 1)
+	psi := ckks.NewCiphertext(params.Parameters, 1, levelOut, 1)
+
+	for k := 0; k < mm.dim; k++ {
+
+		mm.linearTransformHoisted(ctSigma, mm.decomp[0], mm.phi[k], tmp)
+		mm.rescaleLogicalLevel(tmp, phi)
+		mm.linearTransformHoisted(ctTau, mm.decomp[1], mm.psi[k], tmp)
+		mm.rescaleLogicalLevel(tmp, psi)
+
+		if k == 0 {
+			mm.Evaluator.Evaluator.Mul(phi, psi, acc)
+		} else {
+			mm.Evaluator.Evaluator.Mul(phi, psi, prod)
+			mm.Evaluator.Evaluator.Add(acc, prod, acc)
+		}
+	}
+
+	ctOut = ckks.NewCiphertext(params.Parameters, 1, levelOut, acc.Scale)
+	mm.rescaleLogicalLevel(acc, ctOut)
+
+	return ctOut, nil
+}
+
+// permute evaluates the permutation lt, of the logical level of the MatrixMultiplier, on ct and returns the
+// result, rescaled, in a newly created element.
+func (mm *MatrixMultiplier) permute(ct *ckks.Ciphertext, lt LinearTransform) (ctOut *ckks.Ciphertext) {
+
+	ctOut = ckks.NewCiphertext(mm.params.Parameters, 1, lt.Level, ct.Scale)
+
+	buf := mm.linearTransformBuffers()
+	mm.ksw.Decompose(lt.Level, ct.Value[1], buf.decomp)
+	mm.linearTransformHoisted(ct, buf.decomp, lt, ctOut)
+	mm.rescaleLogicalLevel(ctOut, ctOut)
+
+	return
+}
+
+// matrixDim returns the size of the square matrices on which the product of Rows x Inner by Inner x Cols matrices
+// is computed, and panics if they do not fit in the slots of params.
+func matrixDim(params Parameters, rows, inner, cols int) (dim int) {
+
+	dim = rows
+	if inner > dim {
+		dim = inner
+	}
+	if cols > dim {
+		dim = cols
+	}
+
+	if dim*dim > params.Slots() {
+		panic(fmt.Sprintf("cannot MatrixMul: %d x %d matrices do not fit in %d slots", dim, dim, params.Slots()))
+	}
+
+	return
+}
+
+// matrixPermutations returns the diagonals of the permutations sigma, tau, phi^k and psi^k of the slots holding
+// dim x dim matrices packed row-major.
+func matrixPermutations(params Parameters, dim int) (sigma, tau map[int][]complex128, phi, psi []map[int][]complex128) {
+
+	slots := params.Slots()
+
+	// permutation returns the diagonals of the permutation moving the entry source(i, j) to the entry (i, j)
+	permutation := func(source func(i, j int) int) map[int][]complex128 {
+		sources := make(map[int]int, dim*dim)
+		for i := 0; i < dim; i++ {
+			for j := 0; j < dim; j++ {
+				sources[i*dim+j] = source(i, j)
+			}
+		}
+		return permutationDiagonals(slots, sources)
+	}
+
+	sigma = permutation(func(i, j int) int { return i*dim + (i+j)%dim })
+	tau = permutation(func(i, j int) int { return ((i+j)%dim)*dim + j })
+
+	phi, psi = make([]map[int][]complex128, dim), make([]map[int][]complex128, dim)
+	for k := range phi {
+		phi[k] = permutation(func(i, j int) int { return i*dim + (j+k)%dim })
+		psi[k] = permutation(func(i, j int) int { return ((i+k)%dim)*dim + j })
+	}
+
+	return
+}
+
+// permutationDiagonals returns the diagonals of the linear transform moving the slot sources[out] to each slot out
+// of sources and setting the other slots to zero.
+func permutationDiagonals(slots int, sources map[int]int) (diagonals map[int][]complex128) {
+
+	diagonals = make(map[int][]complex128)
+
+	for out, source := range sources {
+		k := (source - out + slots) % slots
+		if _, ok := diagonals[k]; !ok {
+			diagonals[k] = make([]complex128, slots)
+		}
+		diagonals[k][out] = 1
+	}
+
+	return
+}
@@ -1,6 +1,8 @@
 package fckks
 
 import (
+	"math/big"
+
 	"fast-ksw/ckks"
 	"fast-ksw/frlwe"
 	"fast-ksw/ring"
@@ -18,11 +20,21 @@ type ParametersLiteral struct {
 	DefaultScale float64
 	H            int
 	Gamma        int
+	RingType     ring.Type
+
+	SecretDistrib frlwe.SecretDistribution
+	SecretProba   float64
+
+	// PrimesPerLevel is the number of consecutive primes of Q making one logical level, so that a
+	// rescale divides by their product. If len(Q) is not a multiple of it, the remaining primes are
+	// added to the logical level 0. A value of 0 is treated as 1.
+	PrimesPerLevel int
 }
 
 type Parameters struct {
 	ckks.Parameters
-	frlweParams frlwe.Parameters
+	frlweParams    frlwe.Parameters
+	primesPerLevel int
 }
 
 func NewParametersFromLiteral(pl ParametersLiteral) (params Parameters) {
@@ -37,13 +49,22 @@ func NewParametersFromLiteral(pl ParametersLiteral) (params Parameters) {
 			Sigma:        pl.Sigma,
 			LogSlots:     pl.LogSlots,
 			DefaultScale: pl.DefaultScale,
-			RingType:     ring.Standard,
+			RingType:     pl.RingType,
 		})
 
 	if err != nil {
 		panic("cannot NewParametersFromLiteral: ckksParams cannot be generated")
 	}
 
+	primesPerLevel := pl.PrimesPerLevel
+	if primesPerLevel == 0 {
+		primesPerLevel = 1
+	}
+
+	if primesPerLevel < 0 || primesPerLevel > ckksParams.QCount() {
+		panic("cannot NewParametersFromLiteral: PrimesPerLevel must be between 1 and len(Q)")
+	}
+
 	frlweParams := frlwe.NewParametersFromLiteral(
 		frlwe.ParametersLiteral{
 			LogN:  pl.LogN,
@@ -55,14 +76,65 @@ func NewParametersFromLiteral(pl ParametersLiteral) (params Parameters) {
 			H:     pl.H,
 			Sigma: pl.Sigma,
 			Gamma: pl.Gamma,
+
+			RingType: pl.RingType,
+
+			SecretDistrib: pl.SecretDistrib,
+			SecretProba:   pl.SecretProba,
 		})
 
 	params.Parameters = ckksParams
 	params.frlweParams = frlweParams
+	params.primesPerLevel = primesPerLevel
 
 	return
 }
 
+// PrimesPerLevel returns the number of primes of Q making each logical level above the level 0.
+func (p Parameters) PrimesPerLevel() int {
+	return p.primesPerLevel
+}
+
+// MaxLogicalLevel returns the logical level of a fresh ciphertext.
+func (p Parameters) MaxLogicalLevel() int {
+	return p.QCount()/p.primesPerLevel - 1
+}
+
+// LevelQ returns the level of the ring Q, i.e. the index of the last prime, of the logical level.
+func (p Parameters) LevelQ(logicalLevel int) int {
+	return p.MaxLevel() - (p.MaxLogicalLevel()-logicalLevel)*p.primesPerLevel
+}
+
+// LogicalLevel returns the logical level of a ciphertext whose last prime has index levelQ, between 0 and
+// MaxLogicalLevel(). The primes above the last complete logical level do not count: such a ciphertext can be
+// neither rescaled nor dropped, see IsLogicalLevelBoundary. The levels below LevelQ(0) belong to the logical level 0.
+func (p Parameters) LogicalLevel(levelQ int) int {
+	if levelQ < p.LevelQ(0) {
+		return 0
+	}
+	return p.MaxLogicalLevel() - (p.MaxLevel()-levelQ+p.primesPerLevel-1)/p.primesPerLevel
+}
+
+// IsLogicalLevelBoundary returns true if levelQ is the last prime of a logical level, i.e. if
+// LevelQ(LogicalLevel(levelQ)) = levelQ.
+func (p Parameters) IsLogicalLevelBoundary(levelQ int) bool {
+	return p.LevelQ(p.LogicalLevel(levelQ)) == levelQ
+}
+
+// LevelModulus returns the product of the primes of the last logical level of a ciphertext at levelQ, by which
+// a rescaling by one logical level divides it, including the primes above the level if levelQ is not the last
+// prime of a logical level.
+func (p Parameters) LevelModulus(levelQ int) *big.Int {
+	modulus := p.QLvl(levelQ)
+	return modulus.Quo(modulus, p.QLvl(p.LevelQ(p.LogicalLevel(levelQ)-1)))
+}
+
+// levelModulusFloat returns LevelModulus(levelQ) as a float64.
+func (p Parameters) levelModulusFloat(levelQ int) float64 {
+	modulus, _ := new(big.Float).SetInt(p.LevelModulus(levelQ)).Float64()
+	return modulus
+}
+
 func (p Parameters) RingR() *ring.Ring {
 	return p.frlweParams.RingR()
 }
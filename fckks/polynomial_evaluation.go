@@ -0,0 +1,443 @@
+package fckks
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"math/bits"
+
+	"fast-ksw/ckks"
+	"fast-ksw/utils"
+)
+
+// PolynomialBasis is the basis in which the coefficients of a Polynomial are given.
+type PolynomialBasis int
+
+const (
+	// Monomial is the basis 1, x, x^2, ...
+	Monomial PolynomialBasis = iota
+	// Chebyshev is the basis of the Chebyshev polynomials of the first kind T0, T1, T2, ..., composed with
+	// the affine map sending the interval [A, B] of the Polynomial to [-1, 1].
+	Chebyshev
+)
+
+// Polynomial is a polynomial with complex coefficients in the Monomial or in the Chebyshev basis.
+// Coeffs[i] is the coefficient of x^i or of Ti. The interval [A, B] is only used by the Chebyshev basis.
+type Polynomial struct {
+	Basis  PolynomialBasis
+	Coeffs []complex128
+	A, B   float64
+}
+
+// NewPolynomial creates a new Polynomial of the given basis from its coefficients and its interval [a, b].
+func NewPolynomial(basis PolynomialBasis, coeffs []complex128, a, b float64) *Polynomial {
+	if basis == Chebyshev && a >= b {
+		panic("cannot NewPolynomial: the interval [a, b] is empty")
+	}
+	return &Polynomial{Basis: basis, Coeffs: coeffs, A: a, B: b}
+}
+
+// Degree returns the degree of the polynomial.
+func (p *Polynomial) Degree() int {
+	return len(p.Coeffs) - 1
+}
+
+// Depth returns the number of logical levels consumed by PolynomialEvaluator.Evaluate: the optimal
+// ceil(log2(Degree+1)), plus one for the change of variable of a Chebyshev interval other than [-1, 1].
+func (p *Polynomial) Depth() (depth int) {
+	depth = bits.Len64(uint64(p.Degree()))
+	if _, _, ok := p.changeOfVariable(); ok {
+		depth++
+	}
+	return
+}
+
+// changeOfVariable returns the map x -> alpha*x + beta sending [A, B] to [-1, 1], and false if
+// the polynomial is evaluated on x directly.
+func (p *Polynomial) changeOfVariable() (alpha, beta float64, ok bool) {
+	if p.Basis != Chebyshev || (p.A == -1 && p.B == 1) {
+		return 1, 0, false
+	}
+	return 2 / (p.B - p.A), -(p.A + p.B) / (p.B - p.A), true
+}
+
+// Evaluate evaluates the polynomial on x in the clear.
+func (p *Polynomial) Evaluate(x complex128) (y complex128) {
+
+	if p.Basis == Monomial {
+		for i := p.Degree(); i >= 0; i-- {
+			y = y*x + p.Coeffs[i]
+		}
+		return
+	}
+
+	alpha, beta, _ := p.changeOfVariable()
+	x = complex(alpha, 0)*x + complex(beta, 0)
+
+	// Clenshaw recurrence: b_i = c_i + 2x*b_{i+1} - b_{i+2}
+	var b1, b2 complex128
+	for i := p.Degree(); i > 0; i-- {
+		b1, b2 = p.Coeffs[i]+2*x*b1-b2, b1
+	}
+
+	return p.Coeffs[0] + x*b1 - b2
+}
+
+// ChebyshevInterpolation returns the polynomial of the given degree interpolating f at the degree+1 Chebyshev
+// nodes of [a, b], in the Chebyshev basis on [a, b]. The interpolant is close to the best uniform approximation
+// of f of this degree.
+func ChebyshevInterpolation(f func(complex128) complex128, a, b float64, degree int) *Polynomial {
+
+	n := degree + 1
+
+	values := make([]complex128, n)
+	for k := range values {
+		values[k] = f(complex((a+b)/2+(b-a)/2*math.Cos(math.Pi*(float64(k)+0.5)/float64(n)), 0))
+	}
+
+	coeffs := make([]complex128, n)
+	for j := range coeffs {
+		for k, v := range values {
+			coeffs[j] += v * complex(math.Cos(math.Pi*float64(j)*(float64(k)+0.5)/float64(n)), 0)
+		}
+		coeffs[j] *= complex(2/float64(n), 0)
+	}
+	coeffs[0] /= 2
+
+	return NewPolynomial(Chebyshev, coeffs, a, b)
+}
+
+// PolynomialEvaluator evaluates polynomials on ciphertexts with the baby-step giant-step algorithm
+// of Bossuat et al.: the polynomial is recursively split as q*X^(2^k) + r (or q*T(2^k) + r) until its
+// degree is small enough to be evaluated as a linear combination of the baby-step powers.
+// The products of the giant steps are relinearized lazily, once per node, with the relinearization
+// fused in the ModDown of the rescaling, and the scale of each node is chosen so that the result
+// is obtained at the exact target scale, with no loss of level to match scales.
+type PolynomialEvaluator struct {
+	*Evaluator
+	basis    PolynomialBasis
+	logSplit int
+	powers   map[int]*ckks.Ciphertext
+}
+
+// NewPolynomialEvaluator creates a new PolynomialEvaluator using the keys of eval. It needs
+// the relinearization key, which must be a frlwe.RelinKey.
+func NewPolynomialEvaluator(eval *Evaluator) *PolynomialEvaluator {
+	return &PolynomialEvaluator{Evaluator: eval}
+}
+
+// EvaluateNew evaluates pol on each slot of ctIn and returns the result, of scale targetScale, in a
+// newly created element. The result is pol.Depth() logical levels below ctIn.
+// It returns an error if ctIn is not of degree 1, if pol is constant or if ctIn has not enough levels.
+func (pe *PolynomialEvaluator) EvaluateNew(ctIn *ckks.Ciphertext, pol *Polynomial, targetScale float64) (ctOut *ckks.Ciphertext, err error) {
+
+	params := pe.params
+
+	if ctIn.Degree() != 1 {
+		return nil, errors.New("cannot Evaluate: input Ciphertext must be of degree 1")
+	}
+
+	if pol.Degree() < 1 {
+		return nil, errors.New("cannot Evaluate: polynomial must be of degree at least 1")
+	}
+
+	if params.LogicalLevel(ctIn.Level()) < pol.Depth() {
+		return nil, errors.New("cannot Evaluate: input Ciphertext has not enough levels for the depth of the polynomial")
+	}
+
+	x := ctIn
+	if alpha, beta, ok := pol.changeOfVariable(); ok {
+		x = pe.affineMap(ctIn, alpha, beta)
+	}
+
+	logDegree := bits.Len64(uint64(pol.Degree()))
+
+	pe.basis = pol.Basis
+	pe.logSplit = optimalSplit(logDegree)
+	pe.powers = map[int]*ckks.Ciphertext{1: x}
+	defer func() { pe.powers = nil }()
+
+	// Giant steps, relinearized
+	for i := pe.logSplit; i < logDegree; i++ {
+		pe.genPower(1<<i, false)
+	}
+
+	// Baby steps, relinearized only if they are needed for a larger power
+	for i := utils.MinInt(1<<pe.logSplit-1, pol.Degree()); i > 1; i-- {
+		pe.genPower(i, true)
+	}
+
+	level := params.LevelQ(params.LogicalLevel(x.Level()) - logDegree + 1)
+
+	if ctOut, err = pe.recurse(level, targetScale*pe.params.levelModulusFloat(level), pol.Coeffs); err != nil {
+		return nil, err
+	}
+
+	pe.rescaleLogicalLevel(ctOut, ctOut)
+	ctOut.Scale = targetScale
+
+	return ctOut, nil
+}
+
+// optimalSplit returns the log2 of the number of baby steps minimizing the number of non-scalar
+// multiplications for a polynomial of degree smaller than 2^logDegree.
+func optimalSplit(logDegree int) (logSplit int) {
+	logSplit = logDegree >> 1
+	a := (1 << logSplit) + (1 << (logDegree - logSplit)) + logDegree - logSplit - 3
+	b := (1 << (logSplit + 1)) + (1 << (logDegree - logSplit - 1)) + logDegree - logSplit - 4
+	if a > b {
+		logSplit++
+	}
+	return
+}
+
+// affineMap returns alpha*ct + beta, which consumes one logical level and keeps the scale of ct.
+func (pe *PolynomialEvaluator) affineMap(ct *ckks.Ciphertext, alpha, beta float64) (ctOut *ckks.Ciphertext) {
+
+	level := ct.Level()
+	divisor := pe.params.levelModulusFloat(level)
+
+	ctOut = ckks.NewCiphertext(pe.params.Parameters, 1, level, ct.Scale*divisor)
+	pe.Evaluator.Evaluator.MultByGaussianIntegerAndAdd(ct, roundToBigInt(alpha*divisor), int64(0), ctOut)
+	if beta != 0 {
+		pe.Evaluator.Evaluator.AddConst(ctOut, beta, ctOut)
+	}
+
+	pe.rescaleLogicalLevel(ctOut, ctOut)
+	ctOut.Scale = ct.Scale
+
+	return
+}
+
+// genPower computes the power n of the basis from two powers a and b with a+b = n, with
+// T(n) = 2*T(a)*T(b) - T(|a-b|) in the Chebyshev basis, and rescales it by one logical level.
+// It is relinearized unless lazy is true, in which case it is relinearized only when used to compute
+// a larger power.
+func (pe *PolynomialEvaluator) genPower(n int, lazy bool) {
+
+	if _, ok := pe.powers[n]; ok {
+		return
+	}
+
+	var a, b int
+	if n&(n-1) == 0 {
+		a, b = n>>1, n>>1
+	} else {
+		// Maximizes the number of odd powers in the Chebyshev basis
+		k := bits.Len64(uint64(n)) - 1
+		a, b = (1<<k)-1, n+1-(1<<k)
+	}
+
+	pe.genPower(a, lazy)
+	pe.genPower(b, lazy)
+
+	ctA, ctB := pe.relinearizedPower(a), pe.relinearizedPower(b)
+
+	ctN := ckks.NewCiphertext(pe.params.Parameters, 2, utils.MinInt(ctA.Level(), ctB.Level()), 1)
+	pe.Evaluator.Evaluator.Mul(ctA, ctB, ctN)
+
+	if pe.basis == Chebyshev {
+
+		pe.Evaluator.Evaluator.Add(ctN, ctN, ctN)
+
+		if c := a - b; c == 0 {
+			pe.Evaluator.Evaluator.AddConst(ctN, -1.0, ctN)
+		} else {
+			if c < 0 {
+				c = -c
+			}
+
+			// T(c) is brought to the scale of the product by an integer, before the rescaling
+			pe.genPower(c, lazy)
+			ctC := pe.powers[c]
+			pe.Evaluator.Evaluator.MultByGaussianIntegerAndAdd(ctC, roundToBigInt(-ctN.Scale/ctC.Scale), int64(0), ctN)
+		}
+	}
+
+	if lazy {
+		if err := pe.Rescale(ctN, ctN.Scale/pe.params.levelModulusFloat(ctN.Level()), ctN); err != nil {
+			panic(err)
+		}
+	} else {
+		pe.rescaleLogicalLevel(ctN, ctN)
+	}
+
+	pe.powers[n] = ctN
+}
+
+// relinearizedPower returns the power n of the basis, relinearizing it in place if needed.
+func (pe *PolynomialEvaluator) relinearizedPower(n int) *ckks.Ciphertext {
+	ct := pe.powers[n]
+	if ct.Degree() == 2 {
+		pe.Relinearize(ct, ct)
+	}
+	return ct
+}
+
+// rescaleLogicalLevel divides ct0 by the primes of its last logical level and returns the result in ctOut.
+// If ct0 is of degree 2, it is relinearized in the ModDown of the rescaling.
+func (eval *Evaluator) rescaleLogicalLevel(ct0, ctOut *ckks.Ciphertext) {
+
+	params := eval.params
+	level := ct0.Level()
+	scale := ct0.Scale / eval.params.levelModulusFloat(level)
+
+	if ct0.Degree() == 2 {
+		eval.relinearizeAndRescale(ct0, level-params.LevelQ(params.LogicalLevel(level)-1), ctOut)
+		ctOut.Scale = scale
+	} else if err := eval.Rescale(ct0, scale, ctOut); err != nil {
+		panic(err)
+	}
+}
+
+// mulRescaleLogicalLevelNew multiplies ct0 by ct1 and returns the product, relinearized and divided by the primes of
+// its last logical level with rescaleLogicalLevel, in a newly created element.
+func (eval *Evaluator) mulRescaleLogicalLevelNew(ct0, ct1 *ckks.Ciphertext) (ctOut *ckks.Ciphertext) {
+
+	level := utils.MinInt(ct0.Level(), ct1.Level())
+
+	ctTmp := ciphertextAtLevel(eval.ctxPool, 2, level)
+	eval.Evaluator.Mul(ct0, ct1, ctTmp)
+
+	ctOut = ckks.NewCiphertext(eval.params.Parameters, 1, level, ctTmp.Scale)
+	eval.rescaleLogicalLevel(ctTmp, ctOut)
+
+	return
+}
+
+// recurse returns the evaluation of the polynomial of coefficients coeffs at the given level and at the
+// exact given scale, before its last rescaling. The result can be of degree 2.
+func (pe *PolynomialEvaluator) recurse(level int, scale float64, coeffs []complex128) (res *ckks.Ciphertext, err error) {
+
+	params := pe.params
+	degree := len(coeffs) - 1
+
+	if degree < 1<<pe.logSplit && pe.babyStepsAvailable(level, degree) {
+		return pe.evaluateBabySteps(level, scale, coeffs), nil
+	}
+
+	// Splits on the smallest power of two (at least the number of baby steps, unless they are
+	// not available at this level) such that the quotient is of smaller degree than the power
+	nextPower := 1
+	if degree >= 1<<pe.logSplit {
+		nextPower = 1 << pe.logSplit
+	}
+	for nextPower < (degree>>1)+1 {
+		nextPower <<= 1
+	}
+
+	pe.genPower(nextPower, false)
+	ctX := pe.relinearizedPower(nextPower)
+
+	if ctX.Level() < level {
+		return nil, errors.New("cannot Evaluate: not enough levels for the giant steps")
+	}
+
+	coeffsQ, coeffsR := pe.splitCoeffs(coeffs, nextPower)
+
+	if res, err = pe.recurse(level, scale, coeffsR); err != nil {
+		return nil, err
+	}
+
+	if len(coeffsQ) == 1 {
+		pe.multByConstAndAdd(ctX, coeffsQ[0], res)
+		return res, nil
+	}
+
+	levelQ := params.LevelQ(params.LogicalLevel(level) + 1)
+	if levelQ > params.MaxLevel() {
+		return nil, errors.New("cannot Evaluate: not enough levels for the giant steps")
+	}
+
+	// The quotient is computed one logical level above and rescaled, so that its product with
+	// the giant step is at the exact scale of the remainder
+	var ctQ *ckks.Ciphertext
+	if ctQ, err = pe.recurse(levelQ, scale*pe.params.levelModulusFloat(levelQ)/ctX.Scale, coeffsQ); err != nil {
+		return nil, err
+	}
+
+	pe.rescaleLogicalLevel(ctQ, ctQ)
+	ctQ.Scale = scale / ctX.Scale
+
+	ctQX := ckks.NewCiphertext(params.Parameters, 2, level, scale)
+	pe.Evaluator.Evaluator.Mul(ctQ, ctX, ctQX)
+	ctQX.Scale = scale
+
+	pe.Evaluator.Evaluator.Add(ctQX, res, ctQX)
+
+	return ctQX, nil
+}
+
+// babyStepsAvailable returns true if the powers 1 to degree are at a level of at least level.
+func (pe *PolynomialEvaluator) babyStepsAvailable(level, degree int) bool {
+	for i := 1; i <= degree; i++ {
+		pe.genPower(i, true)
+		if pe.powers[i].Level() < level {
+			return false
+		}
+	}
+	return true
+}
+
+// evaluateBabySteps returns the linear combination of the powers of the basis with coefficients
+// coeffs, at the given level and scale.
+func (pe *PolynomialEvaluator) evaluateBabySteps(level int, scale float64, coeffs []complex128) (res *ckks.Ciphertext) {
+
+	degree := 1
+	for i := 1; i < len(coeffs); i++ {
+		if coeffs[i] != 0 {
+			degree = utils.MaxInt(degree, pe.powers[i].Degree())
+		}
+	}
+
+	res = ckks.NewCiphertext(pe.params.Parameters, degree, level, scale)
+
+	for i := 1; i < len(coeffs); i++ {
+		pe.multByConstAndAdd(pe.powers[i], coeffs[i], res)
+	}
+
+	if coeffs[0] != 0 {
+		pe.Evaluator.Evaluator.AddConst(res, coeffs[0], res)
+	}
+
+	return
+}
+
+// multByConstAndAdd adds c*ct to res, multiplying ct by the Gaussian integer bringing it to the scale of res.
+func (pe *PolynomialEvaluator) multByConstAndAdd(ct *ckks.Ciphertext, c complex128, res *ckks.Ciphertext) {
+	if c != 0 {
+		ratio := res.Scale / ct.Scale
+		pe.Evaluator.Evaluator.MultByGaussianIntegerAndAdd(ct, roundToBigInt(real(c)*ratio), roundToBigInt(imag(c)*ratio), res)
+	}
+}
+
+// splitCoeffs splits the polynomial of coefficients coeffs as q*X^split + r, or q*T(split) + r in the
+// Chebyshev basis, where the degree of coeffs is smaller than 2*split.
+func (pe *PolynomialEvaluator) splitCoeffs(coeffs []complex128, split int) (coeffsQ, coeffsR []complex128) {
+
+	degree := len(coeffs) - 1
+
+	coeffsR = make([]complex128, split)
+	copy(coeffsR, coeffs[:split])
+
+	coeffsQ = make([]complex128, degree-split+1)
+	coeffsQ[0] = coeffs[split]
+
+	for i, j := split+1, 1; i <= degree; i, j = i+1, j+1 {
+		if pe.basis == Chebyshev {
+			// T(i) = 2*T(j)*T(split) - T(split-j)
+			coeffsQ[j] = 2 * coeffs[i]
+			coeffsR[split-j] -= coeffs[i]
+		} else {
+			coeffsQ[j] = coeffs[i]
+		}
+	}
+
+	return
+}
+
+// roundToBigInt returns the integer closest to x.
+func roundToBigInt(x float64) *big.Int {
+	xInt, _ := big.NewFloat(math.Round(x)).Int(nil)
+	return xInt
+}
@@ -0,0 +1,470 @@
+package fckks
+
+import (
+	"errors"
+	"math"
+	"math/big"
+
+	"fast-ksw/ckks"
+	"fast-ksw/ring"
+	"fast-ksw/rlwe"
+	"fast-ksw/utils"
+)
+
+// RescalingPolicy defines when the Evaluator rescales the ciphertexts of a multiplication.
+type RescalingPolicy int
+
+const (
+	// RescaleManual never rescales: the ciphertexts are rescaled with Rescale or MulRelinThenRescale.
+	RescaleManual RescalingPolicy = iota
+	// RescaleEager rescales the result of every Mul and MulRelin.
+	RescaleEager
+	// RescaleLazy rescales the ciphertext operands of Mul, MulRelin and MulRelinAndAdd before the
+	// multiplication, so that products can be accumulated before being rescaled once.
+	RescaleLazy
+)
+
+// SetRescalingPolicy sets the rescaling policy of the Evaluator. A ciphertext is rescaled as long as
+// its scale stays above minScale/2 after the division, as with Rescale. The default policy is
+// RescaleManual with minScale equal to the default scale of the parameters.
+func (eval *Evaluator) SetRescalingPolicy(policy RescalingPolicy, minScale float64) {
+	if minScale <= 0 {
+		panic("cannot SetRescalingPolicy: minScale must be positive")
+	}
+	eval.policy = policy
+	eval.minScale = minScale
+}
+
+// RescalingPolicy returns the rescaling policy of the Evaluator and its minimum scale.
+func (eval *Evaluator) RescalingPolicy() (policy RescalingPolicy, minScale float64) {
+	return eval.policy, eval.minScale
+}
+
+// MulRelinThenRescaleNew multiplies op0 with op1 with relinearization, rescales the result with the
+// minimum scale of the rescaling policy and returns it in a newly created element.
+// It returns an error if the product is at logical level 0.
+func (eval *Evaluator) MulRelinThenRescaleNew(op0, op1 ckks.Operand) (ctOut *ckks.Ciphertext, err error) {
+	ctOut = eval.Evaluator.MulRelinNew(op0, op1)
+	return ctOut, eval.Rescale(ctOut, eval.minScale, ctOut)
+}
+
+// MulRelinThenRescale multiplies op0 with op1 with relinearization, rescales the result with the
+// minimum scale of the rescaling policy and returns it in ctOut, whatever the policy.
+// It returns an error if the product is at logical level 0.
+func (eval *Evaluator) MulRelinThenRescale(op0, op1 ckks.Operand, ctOut *ckks.Ciphertext) (err error) {
+	eval.Evaluator.MulRelin(op0, op1, ctOut)
+	return eval.Rescale(ctOut, eval.minScale, ctOut)
+}
+
+// MulRelinRescaleNew multiplies op0 with op1 with relinearization and rescaling as in MulRelinRescale
+// and returns the result in a newly created element.
+func (eval *Evaluator) MulRelinRescaleNew(op0, op1 ckks.Operand) (ctOut *ckks.Ciphertext, err error) {
+	ctOut = ckks.NewCiphertext(eval.params.Parameters, 1, utils.MinInt(op0.Level(), op1.Level()), 1)
+	return ctOut, eval.MulRelinRescale(op0, op1, ctOut)
+}
+
+// MulRelinRescale multiplies op0 with op1 with relinearization and rescales the result as
+// MulRelinThenRescale does, but divides the relinearization by P and the product by the primes of the
+// rescaling with a single ModDown of the frlwe key switch. This saves a pair of NTTs and a basis
+// conversion, and the result differs from the one of MulRelinThenRescale by at most one per coefficient.
+// Products that do not need a relinearization, and products with no prime to divide by, are computed
+// with MulRelinThenRescale. The relinearization key must be a frlwe.RelinKey.
+// It returns an error if the product is at logical level 0.
+func (eval *Evaluator) MulRelinRescale(op0, op1 ckks.Operand, ctOut *ckks.Ciphertext) (err error) {
+
+	if op0.Degree() != 1 || op1.Degree() != 1 {
+		return eval.MulRelinThenRescale(op0, op1, ctOut)
+	}
+
+	if ctOut.Degree() != 1 {
+		panic("cannot MulRelinRescale: output Ciphertext must be of degree 1")
+	}
+
+	level := utils.MinInt(utils.MinInt(op0.Level(), op1.Level()), ctOut.Level())
+
+	if eval.params.LogicalLevel(level) <= 0 {
+		return errors.New("cannot MulRelinRescale: product already at logical level 0")
+	}
+
+	nbRescales, scale := eval.rescaleLevels(level, op0.ScalingFactor()*op1.ScalingFactor(), eval.minScale)
+	if nbRescales == 0 {
+		return eval.MulRelinThenRescale(op0, op1, ctOut)
+	}
+
+	ctTmp := ciphertextAtLevel(eval.ctxPool, 2, level)
+	eval.Evaluator.Mul(op0, op1, ctTmp)
+
+	eval.relinearizeAndRescale(ctTmp, nbRescales, ctOut)
+	ctOut.Scale = scale
+
+	return nil
+}
+
+// relinearizeAndRescale relinearizes the degree 2 ciphertext ct0 and divides it by its last nbRescales primes
+// with a single ModDown of the frlwe key switch, and returns the result in ctOut, whose scale is left to the caller.
+func (eval *Evaluator) relinearizeAndRescale(ct0 *ckks.Ciphertext, nbRescales int, ctOut *ckks.Ciphertext) {
+
+	rlk, ok := eval.evks.GetRelinearizationKey()
+	if !ok {
+		panic("cannot relinearize: relinearization key is missing")
+	}
+
+	level := ct0.Level()
+
+	c2 := eval.polyQPool[0]
+	eval.params.RingQ().InvNTTLvl(level, ct0.Value[2], c2)
+	c2.IsNTT = false
+
+	ctOut.El().Resize(eval.params.Parameters.Parameters, 1)
+	eval.ksw.SwitchKeyAndRescale(level, nbRescales, c2, fastKey(rlk), ct0.Value[0], ct0.Value[1], ctOut.Value[0], ctOut.Value[1])
+
+	for i := range ctOut.Value {
+		ctOut.Value[i].Coeffs = ctOut.Value[i].Coeffs[:level+1-nbRescales]
+		ctOut.Value[i].IsNTT = true
+	}
+}
+
+// RescaleNew divides ct0 by the primes of its last logical levels as in Rescale and returns the result
+// in a newly created element.
+func (eval *Evaluator) RescaleNew(ct0 *ckks.Ciphertext, minScale float64) (ctOut *ckks.Ciphertext, err error) {
+	ctOut = ckks.NewCiphertext(eval.params.Parameters, ct0.Degree(), ct0.Level(), ct0.Scale)
+	return ctOut, eval.Rescale(ct0, minScale, ctOut)
+}
+
+// Rescale divides ctIn by the product of the primes of its last logical level, and repeats this procedure
+// (consuming one logical level each time) as long as the scale does not go below minScale/2, and returns
+// the result in ctOut. All the primes divided by are removed at once with a single pair of NTTs.
+// Returns an error if minScale <= 0, ctIn.Scale = 0, ctIn is at the logical level 0 or not at the last prime
+// of a logical level, or if the degrees of ctIn and ctOut differ.
+func (eval *Evaluator) Rescale(ctIn *ckks.Ciphertext, minScale float64, ctOut *ckks.Ciphertext) (err error) {
+
+	params := eval.params
+
+	if minScale <= 0 {
+		return errors.New("cannot Rescale: minScale is 0")
+	}
+
+	if ctIn.Scale == 0 {
+		return errors.New("cannot Rescale: ciphertext scale is 0")
+	}
+
+	if params.LogicalLevel(ctIn.Level()) <= 0 {
+		return errors.New("cannot Rescale: input Ciphertext already at logical level 0")
+	}
+
+	if !params.IsLogicalLevelBoundary(ctIn.Level()) {
+		return errors.New("cannot Rescale: input Ciphertext is not at the last prime of a logical level")
+	}
+
+	if ctOut.Degree() != ctIn.Degree() {
+		return errors.New("cannot Rescale : ctIn.Degree() != ctOut.Degree()")
+	}
+
+	level := ctIn.Level()
+	nbRescales, scale := eval.rescaleLevels(level, ctIn.Scale, minScale)
+
+	if nbRescales > 0 {
+		ringQ := params.RingQ()
+		for i := range ctOut.Value {
+			ringQ.DivRoundByLastModulusManyNTTLvl(level, nbRescales, ctIn.Value[i], eval.polyQPool[3], ctOut.Value[i])
+			ctOut.Value[i].Coeffs = ctOut.Value[i].Coeffs[:level+1-nbRescales]
+		}
+	} else if ctIn != ctOut {
+		ctOut.Copy(ctIn)
+	}
+
+	ctOut.Scale = scale
+
+	return nil
+}
+
+// rescaleLevels returns the number of primes Rescale divides a ciphertext at levelQ and of the given
+// scale by, and the scale after the division.
+func (eval *Evaluator) rescaleLevels(levelQ int, scale, minScale float64) (nbRescales int, scaleOut float64) {
+
+	params := eval.params
+
+	for logicalLevel := params.LogicalLevel(levelQ); logicalLevel > 0; logicalLevel-- {
+
+		divisor := params.levelModulusFloat(levelQ - nbRescales)
+		if scale/divisor < minScale/2 {
+			break
+		}
+
+		scale /= divisor
+		nbRescales = levelQ - params.LevelQ(logicalLevel-1)
+	}
+
+	return nbRescales, scale
+}
+
+// DropLevelNew reduces the logical level of ct0 by levels and returns the result in a newly created element.
+// No rescaling is applied during this procedure.
+func (eval *Evaluator) DropLevelNew(ct0 *ckks.Ciphertext, levels int) (ctOut *ckks.Ciphertext) {
+	ctOut = ct0.CopyNew()
+	eval.DropLevel(ctOut, levels)
+	return
+}
+
+// DropLevel reduces the logical level of ct0 by levels, removing all the primes of these logical levels,
+// and returns the result in ct0. No rescaling is applied during this procedure.
+// The method panics if ct0 is not at the last prime of a logical level or is below the logical level levels.
+func (eval *Evaluator) DropLevel(ct0 *ckks.Ciphertext, levels int) {
+
+	params := eval.params
+
+	if !params.IsLogicalLevelBoundary(ct0.Level()) {
+		panic("cannot DropLevel: input Ciphertext is not at the last prime of a logical level")
+	}
+
+	logicalLevel := params.LogicalLevel(ct0.Level())
+	if levels < 0 || levels > logicalLevel {
+		panic("cannot DropLevel: levels must be between 0 and the logical level of the input Ciphertext")
+	}
+
+	eval.Evaluator.DropLevel(ct0, ct0.Level()-params.LevelQ(logicalLevel-levels))
+}
+
+// SetScale sets the scale of ct to scale as Add would do: ct keeps its level if the ratio of the scales
+// is an integer, and consumes one logical level otherwise.
+func (eval *Evaluator) SetScale(ct *ckks.Ciphertext, scale float64) {
+
+	ctScaled := eval.scaleTo(ct, scale)
+	if ctScaled == nil {
+		panic("cannot SetScale: the ratio of the scales is not an integer and ct is at logical level 0")
+	}
+
+	if ctScaled.Ciphertext != ct.Ciphertext {
+		level := ctScaled.Level()
+		for i := range ct.Value {
+			ring.CopyValuesLvl(level, ctScaled.Value[i], ct.Value[i])
+			ct.Value[i].Coeffs = ct.Value[i].Coeffs[:level+1]
+		}
+	}
+
+	ct.Scale = scale
+}
+
+// MulNew multiplies op0 with op1 without relinearization and returns the result in a newly created
+// element, rescaling the operands or the result according to the rescaling policy.
+func (eval *Evaluator) MulNew(op0, op1 ckks.Operand) (ctOut *ckks.Ciphertext) {
+	op0, op1 = eval.rescaleOperands(op0, op1)
+	ctOut = eval.Evaluator.MulNew(op0, op1)
+	eval.rescaleProduct(ctOut)
+	return
+}
+
+// Mul multiplies op0 with op1 without relinearization and returns the result in ctOut,
+// rescaling the operands or the result according to the rescaling policy.
+func (eval *Evaluator) Mul(op0, op1 ckks.Operand, ctOut *ckks.Ciphertext) {
+	op0, op1 = eval.rescaleOperands(op0, op1)
+	eval.Evaluator.Mul(op0, op1, ctOut)
+	eval.rescaleProduct(ctOut)
+}
+
+// MulRelinNew multiplies op0 with op1 with relinearization and returns the result in a newly created
+// element, rescaling the operands or the result according to the rescaling policy.
+func (eval *Evaluator) MulRelinNew(op0, op1 ckks.Operand) (ctOut *ckks.Ciphertext) {
+	op0, op1 = eval.rescaleOperands(op0, op1)
+	ctOut = eval.Evaluator.MulRelinNew(op0, op1)
+	eval.rescaleProduct(ctOut)
+	return
+}
+
+// MulRelin multiplies op0 with op1 with relinearization and returns the result in ctOut,
+// rescaling the operands or the result according to the rescaling policy.
+func (eval *Evaluator) MulRelin(op0, op1 ckks.Operand, ctOut *ckks.Ciphertext) {
+	op0, op1 = eval.rescaleOperands(op0, op1)
+	eval.Evaluator.MulRelin(op0, op1, ctOut)
+	eval.rescaleProduct(ctOut)
+}
+
+// canRescale returns true if Rescale with the minimum scale of the policy would consume at least one logical level of ct.
+func (eval *Evaluator) canRescale(ct *ckks.Ciphertext) bool {
+	if eval.params.LogicalLevel(ct.Level()) <= 0 || !eval.params.IsLogicalLevelBoundary(ct.Level()) {
+		return false
+	}
+	divisor := eval.params.levelModulusFloat(ct.Level())
+	return ct.Scale/divisor >= eval.minScale/2
+}
+
+// rescaleProduct rescales ctOut in place if the policy is RescaleEager.
+func (eval *Evaluator) rescaleProduct(ctOut *ckks.Ciphertext) {
+	if eval.policy == RescaleEager && eval.canRescale(ctOut) {
+		if err := eval.Rescale(ctOut, eval.minScale, ctOut); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// rescaleOperands returns the operands of a multiplication, rescaled on the buffers of the Evaluator
+// if the policy is RescaleLazy. The inputs are not modified.
+func (eval *Evaluator) rescaleOperands(op0, op1 ckks.Operand) (ckks.Operand, ckks.Operand) {
+	if eval.policy != RescaleLazy {
+		return op0, op1
+	}
+	return eval.rescaleOperand(op0, 0), eval.rescaleOperand(op1, 1)
+}
+
+// rescaleOperand returns op rescaled on the buffer ctxRescale[i] if it is a ciphertext of degree 1 that can be
+// rescaled, and op otherwise: the multiplications reject the operands of degree 2 themselves.
+func (eval *Evaluator) rescaleOperand(op ckks.Operand, i int) ckks.Operand {
+
+	ct, isCiphertext := op.(*ckks.Ciphertext)
+	if !isCiphertext || ct.Degree() != 1 || !eval.canRescale(ct) {
+		return op
+	}
+
+	ctOut := ciphertextAtLevel(eval.ctxRescale[i], 1, ct.Level())
+	if err := eval.Rescale(ct, eval.minScale, ctOut); err != nil {
+		panic(err)
+	}
+
+	return ctOut
+}
+
+// AddNew adds op0 to op1 and returns the result in a newly created element.
+// The operands are brought to the same scale beforehand, see matchScales.
+func (eval *Evaluator) AddNew(op0, op1 ckks.Operand) *ckks.Ciphertext {
+	op0, op1 = eval.matchScales(op0, op1)
+	return eval.Evaluator.AddNew(op0, op1)
+}
+
+// Add adds op0 to op1 and returns the result in ctOut.
+// The operands are brought to the same scale beforehand, see matchScales.
+func (eval *Evaluator) Add(op0, op1 ckks.Operand, ctOut *ckks.Ciphertext) {
+	op0, op1 = eval.matchScales(op0, op1)
+	eval.Evaluator.Add(op0, op1, ctOut)
+}
+
+// AddNoModNew adds op0 to op1 without modular reduction and returns the result in a newly created element.
+// The operands are brought to the same scale beforehand, see matchScales.
+func (eval *Evaluator) AddNoModNew(op0, op1 ckks.Operand) *ckks.Ciphertext {
+	op0, op1 = eval.matchScales(op0, op1)
+	return eval.Evaluator.AddNoModNew(op0, op1)
+}
+
+// AddNoMod adds op0 to op1 without modular reduction and returns the result in ctOut.
+// The operands are brought to the same scale beforehand, see matchScales.
+func (eval *Evaluator) AddNoMod(op0, op1 ckks.Operand, ctOut *ckks.Ciphertext) {
+	op0, op1 = eval.matchScales(op0, op1)
+	eval.Evaluator.AddNoMod(op0, op1, ctOut)
+}
+
+// SubNew subtracts op1 from op0 and returns the result in a newly created element.
+// The operands are brought to the same scale beforehand, see matchScales.
+func (eval *Evaluator) SubNew(op0, op1 ckks.Operand) *ckks.Ciphertext {
+	op0, op1 = eval.matchScales(op0, op1)
+	return eval.Evaluator.SubNew(op0, op1)
+}
+
+// Sub subtracts op1 from op0 and returns the result in ctOut.
+// The operands are brought to the same scale beforehand, see matchScales.
+func (eval *Evaluator) Sub(op0, op1 ckks.Operand, ctOut *ckks.Ciphertext) {
+	op0, op1 = eval.matchScales(op0, op1)
+	eval.Evaluator.Sub(op0, op1, ctOut)
+}
+
+// SubNoModNew subtracts op1 from op0 without modular reduction and returns the result in a newly created element.
+// The operands are brought to the same scale beforehand, see matchScales.
+func (eval *Evaluator) SubNoModNew(op0, op1 ckks.Operand) *ckks.Ciphertext {
+	op0, op1 = eval.matchScales(op0, op1)
+	return eval.Evaluator.SubNoModNew(op0, op1)
+}
+
+// SubNoMod subtracts op1 from op0 without modular reduction and returns the result in ctOut.
+// The operands are brought to the same scale beforehand, see matchScales.
+func (eval *Evaluator) SubNoMod(op0, op1 ckks.Operand, ctOut *ckks.Ciphertext) {
+	op0, op1 = eval.matchScales(op0, op1)
+	eval.Evaluator.SubNoMod(op0, op1, ctOut)
+}
+
+// matchScales returns the operands of an addition at the same scale. The operand with the smaller
+// scale, if it is a ciphertext, is brought to the larger scale on a buffer of the Evaluator:
+//   - if the ratio of the scales is an integer up to the rounding error of the encoding, it is
+//     multiplied by this integer and keeps its level;
+//   - else it is multiplied by the ratio and rescaled, which consumes one logical level.
+//
+// The levels are then matched by the ckks.Evaluator, which computes the result at the smallest level
+// of the operands. The inputs are not modified. If the smaller scale is the one of a plaintext, or if
+// the ciphertext to scale is at logical level 0, the operands are left to the ckks.Evaluator, which only scales
+// by the integer part of the ratio.
+func (eval *Evaluator) matchScales(op0, op1 ckks.Operand) (ckks.Operand, ckks.Operand) {
+
+	scale0, scale1 := op0.ScalingFactor(), op1.ScalingFactor()
+
+	switch {
+	case scale0 < scale1:
+		if ct, isCiphertext := op0.(*ckks.Ciphertext); isCiphertext {
+			if ctScaled := eval.scaleTo(ct, scale1); ctScaled != nil {
+				return ctScaled, op1
+			}
+		}
+	case scale1 < scale0:
+		if ct, isCiphertext := op1.(*ckks.Ciphertext); isCiphertext {
+			if ctScaled := eval.scaleTo(ct, scale0); ctScaled != nil {
+				return op0, ctScaled
+			}
+		}
+	}
+
+	return op0, op1
+}
+
+// scaleTo returns ct brought to the larger scale, or nil if it cannot be done without losing precision.
+func (eval *Evaluator) scaleTo(ct *ckks.Ciphertext, scale float64) *ckks.Ciphertext {
+
+	ratio := scale / ct.Scale
+	ratioInt := math.Round(ratio)
+
+	// Rounding the ratio adds an error of at most ct.Scale*|ratio-ratioInt| to the scaled message,
+	// which is below the rounding error of the encoding as long as it is smaller than one.
+	if ratioInt >= 1 && ct.Scale*math.Abs(ratio-ratioInt) < 1 {
+
+		if ratioInt == 1 {
+			return &ckks.Ciphertext{Ciphertext: ct.Ciphertext, Scale: scale}
+		}
+
+		ctOut := ciphertextAtLevel(eval.ctxAlign, ct.Degree(), ct.Level())
+		eval.Evaluator.MultByConst(ct, uint64(ratioInt), ctOut)
+		ctOut.Scale = scale
+		return ctOut
+	}
+
+	if eval.params.LogicalLevel(ct.Level()) <= 0 {
+		return nil
+	}
+
+	// ct is multiplied by round(ratio * D), where D is the product of the primes of its last logical
+	// level, so that the division by D of Rescale brings the scale back to ct.Scale.
+	level := ct.Level()
+	divisor := eval.params.LevelModulus(level)
+
+	constant := new(big.Float).SetPrec(256).SetFloat64(ratio)
+	constant.Mul(constant, new(big.Float).SetInt(divisor))
+	constant.Add(constant, big.NewFloat(0.5))
+	constantInt, _ := constant.Int(nil)
+
+	ctOut := ciphertextAtLevel(eval.ctxAlign, ct.Degree(), level)
+	for i := range ctOut.Value {
+		eval.params.RingQ().MulScalarBigintLvl(level, ct.Value[i], constantInt, ctOut.Value[i])
+	}
+
+	divisorFloat, _ := new(big.Float).SetInt(divisor).Float64()
+	ctOut.Scale = ct.Scale * divisorFloat
+	if err := eval.Rescale(ctOut, ct.Scale, ctOut); err != nil {
+		panic(err)
+	}
+	ctOut.Scale = scale
+
+	return ctOut
+}
+
+// ciphertextAtLevel returns a ciphertext of the given degree and level sharing its memory with pool.
+func ciphertextAtLevel(pool *ckks.Ciphertext, degree, level int) *ckks.Ciphertext {
+	ct := &ckks.Ciphertext{Ciphertext: &rlwe.Ciphertext{Value: make([]*ring.Poly, degree+1)}}
+	for i := range ct.Value {
+		ct.Value[i] = &ring.Poly{Coeffs: pool.Value[i].Coeffs[:level+1], IsNTT: true}
+	}
+	return ct
+}
@@ -0,0 +1,235 @@
+package fckks
+
+import (
+	"fmt"
+
+	"fast-ksw/ckks"
+)
+
+// reshapeOp is a permutation of the slots holding a matrix packed row-major.
+type reshapeOp int
+
+const (
+	reshapeTranspose reshapeOp = iota
+	reshapeRowToColumn
+	reshapeColumnToRow
+)
+
+func (op reshapeOp) String() string {
+	switch op {
+	case reshapeTranspose:
+		return "Transpose"
+	case reshapeRowToColumn:
+		return "RowToColumn"
+	default:
+		return "ColumnToRow"
+	}
+}
+
+// Reshaper permutes the slots of rows x cols matrices packed row-major in the first rows*cols slots of ciphertexts,
+// the other slots being zero. The permutations are linear transforms on the slots, evaluated with the baby-step
+// giant-step algorithm of LinearTransform. They are encoded at the creation of the Reshaper for the ciphertexts at
+// the given logical level, at the scale of its modulus, so that the rescalings divide exactly by their scales: each
+// permutation consumes one logical level and leaves the scale unchanged. The permutations return an error if the
+// ciphertexts are not of degree 1 or if the input is below this logical level.
+type Reshaper struct {
+	*Evaluator
+	Rows, Cols   int
+	LogicalLevel int
+
+	lts [3]LinearTransform
+}
+
+// NewReshaper creates a new Reshaper of rows x cols matrices for the ciphertexts of eval at the given logical level,
+// using the keys of eval. The rotation keys of Parameters.RotationsForTranspose, RotationsForRowToColumn and
+// RotationsForColumnToRow of the permutations used must be in the EvaluationKey.
+func NewReshaper(eval *Evaluator, rows, cols, logicalLevel int) (rs *Reshaper) {
+
+	params := eval.params
+
+	if logicalLevel < 1 || logicalLevel > params.MaxLogicalLevel() {
+		panic("cannot NewReshaper: the logical level must be between 1 and MaxLogicalLevel")
+	}
+
+	rs = &Reshaper{Evaluator: eval, Rows: rows, Cols: cols, LogicalLevel: logicalLevel}
+
+	ecd := ckks.NewEncoder(params.Parameters)
+	level := params.LevelQ(logicalLevel)
+
+	for _, op := range []reshapeOp{reshapeTranspose, reshapeRowToColumn, reshapeColumnToRow} {
+		diagonals := reshapeDiagonals(params, op, rows, cols)
+		rs.lts[op] = NewLinearTransform(params, ecd, diagonals, level, eval.params.levelModulusFloat(level), params.LogSlots())
+	}
+
+	return
+}
+
+// RotationsForTranspose returns the rotations used by Reshaper.Transpose on rows x cols matrices.
+// The keys are generated with frlwe.KeyGenerator.GenRotKeys.
+func (p Parameters) RotationsForTranspose(rows, cols int) []int {
+	return reshapeRotations(p, reshapeTranspose, rows, cols)
+}
+
+// RotationsForRowToColumn returns the rotations used by Reshaper.RowToColumn on n x cols matrices.
+func (p Parameters) RotationsForRowToColumn(n, cols int) []int {
+	return reshapeRotations(p, reshapeRowToColumn, n, cols)
+}
+
+// RotationsForColumnToRow returns the rotations used by Reshaper.ColumnToRow on n x cols matrices.
+func (p Parameters) RotationsForColumnToRow(n, cols int) []int {
+	return reshapeRotations(p, reshapeColumnToRow, n, cols)
+}
+
+// RotationsForReplicateRows returns the rotations used by Evaluator.ReplicateRows on rows x cols matrices.
+func (p Parameters) RotationsForReplicateRows(rows, cols int) []int {
+	return p.RotationsForReplicateLog(cols, rows)
+}
+
+// RotationsForReplicateColumns returns the rotations used by Evaluator.ReplicateColumns on rows x cols matrices.
+func (p Parameters) RotationsForReplicateColumns(rows, cols int) []int {
+	return p.RotationsForReplicateLog(1, cols)
+}
+
+// reshapeRotations returns the rotations of the baby-step giant-step evaluation of the permutation op.
+func reshapeRotations(params Parameters, op reshapeOp, rows, cols int) []int {
+
+	diagonals := reshapeDiagonals(params, op, rows, cols)
+
+	diags := make([]int, 0, len(diagonals))
+	for k := range diagonals {
+		diags = append(diags, k)
+	}
+
+	return params.RotationsForLinearTransform(diags, params.LogSlots())
+}
+
+// reshapeDiagonals returns the diagonals of the permutation op of rows x cols matrices packed row-major, and panics
+// if they do not fit in the slots of params.
+func reshapeDiagonals(params Parameters, op reshapeOp, rows, cols int) map[int][]complex128 {
+
+	if rows < 1 || cols < 1 {
+		panic(fmt.Sprintf("cannot %s: the sizes of the matrix must be positive", op))
+	}
+
+	if rows*cols > params.Slots() {
+		panic(fmt.Sprintf("cannot %s: a %d x %d matrix does not fit in %d slots", op, rows, cols, params.Slots()))
+	}
+
+	sources := make(map[int]int, rows*cols)
+
+	switch op {
+	case reshapeTranspose:
+		// The entry (i, j) goes to the entry (j, i) of the cols x rows matrix
+		for i := 0; i < rows; i++ {
+			for j := 0; j < cols; j++ {
+				sources[j*rows+i] = i*cols + j
+			}
+		}
+	case reshapeRowToColumn:
+		for i := 0; i < rows; i++ {
+			sources[i*cols] = i
+		}
+	case reshapeColumnToRow:
+		for i := 0; i < rows; i++ {
+			sources[i] = i * cols
+		}
+	}
+
+	return permutationDiagonals(params.Slots(), sources)
+}
+
+// reshapeNew evaluates the permutation op on ctIn as in reshape and returns the result in a newly created element.
+func (rs *Reshaper) reshapeNew(op reshapeOp, ctIn *ckks.Ciphertext) (ctOut *ckks.Ciphertext, err error) {
+	params := rs.params
+	ctOut = ckks.NewCiphertext(params.Parameters, 1, params.LevelQ(rs.LogicalLevel-1), ctIn.Scale)
+	if err = rs.reshape(op, ctIn, ctOut); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// reshape evaluates the permutation op on ctIn at the logical level of the Reshaper, and rescales the result by one
+// logical level in ctOut, leaving its scale unchanged. It returns an error if the ciphertexts are not of degree 1
+// or if ctIn is below the logical level of the Reshaper.
+func (rs *Reshaper) reshape(op reshapeOp, ctIn *ckks.Ciphertext, ctOut *ckks.Ciphertext) (err error) {
+
+	params := rs.params
+
+	if ctIn.Degree() != 1 || ctOut.Degree() != 1 {
+		return fmt.Errorf("cannot %s: input and output Ciphertext must be of degree 1", op)
+	}
+
+	if params.LogicalLevel(ctIn.Level()) < rs.LogicalLevel {
+		return fmt.Errorf("cannot %s: input Ciphertext is below the logical level of the Reshaper", op)
+	}
+
+	rs.LinearTransformAndRescale(ctIn, rs.lts[op], ctOut)
+
+	return nil
+}
+
+// TransposeNew transposes the matrix of ctIn as in Transpose and returns the result in a newly created element.
+func (rs *Reshaper) TransposeNew(ctIn *ckks.Ciphertext) (ctOut *ckks.Ciphertext, err error) {
+	return rs.reshapeNew(reshapeTranspose, ctIn)
+}
+
+// Transpose sets ctOut to the transpose of the Rows x Cols matrix packed row-major in ctIn, packed row-major as a
+// Cols x Rows matrix. The permutation has at most Rows+Cols-1 diagonals for square matrices, and is evaluated with
+// O(sqrt(Rows+Cols)) rotations, whose keys are given by Parameters.RotationsForTranspose(Rows, Cols).
+func (rs *Reshaper) Transpose(ctIn *ckks.Ciphertext, ctOut *ckks.Ciphertext) (err error) {
+	return rs.reshape(reshapeTranspose, ctIn, ctOut)
+}
+
+// RowToColumnNew reshapes the vector of ctIn as in RowToColumn and returns the result in a newly created element.
+func (rs *Reshaper) RowToColumnNew(ctIn *ckks.Ciphertext) (ctOut *ckks.Ciphertext, err error) {
+	return rs.reshapeNew(reshapeRowToColumn, ctIn)
+}
+
+// RowToColumn sets ctOut to the vector of the Rows first slots of ctIn packed as the first column of the Rows x Cols
+// matrix, packed row-major: the slot i goes to the slot i*Cols, and the other slots are set to zero.
+// The rotation keys of Parameters.RotationsForRowToColumn(Rows, Cols) must be in the EvaluationKey.
+func (rs *Reshaper) RowToColumn(ctIn *ckks.Ciphertext, ctOut *ckks.Ciphertext) (err error) {
+	return rs.reshape(reshapeRowToColumn, ctIn, ctOut)
+}
+
+// ColumnToRowNew reshapes the column of ctIn as in ColumnToRow and returns the result in a newly created element.
+func (rs *Reshaper) ColumnToRowNew(ctIn *ckks.Ciphertext) (ctOut *ckks.Ciphertext, err error) {
+	return rs.reshapeNew(reshapeColumnToRow, ctIn)
+}
+
+// ColumnToRow is the inverse of RowToColumn: it sets ctOut to the first column of the Rows x Cols matrix packed
+// row-major in ctIn, packed in the Rows first slots, and the other slots to zero.
+// The rotation keys of Parameters.RotationsForColumnToRow(Rows, Cols) must be in the EvaluationKey.
+func (rs *Reshaper) ColumnToRow(ctIn *ckks.Ciphertext, ctOut *ckks.Ciphertext) (err error) {
+	return rs.reshape(reshapeColumnToRow, ctIn, ctOut)
+}
+
+// ReplicateRowsNew replicates the first row of ctIn as in ReplicateRows and returns the result in a newly created
+// element.
+func (eval *Evaluator) ReplicateRowsNew(ctIn *ckks.Ciphertext, rows, cols int) (ctOut *ckks.Ciphertext) {
+	ctOut = ckks.NewCiphertext(eval.params.Parameters, 1, ctIn.Level(), ctIn.Scale)
+	eval.ReplicateRows(ctIn, rows, cols, ctOut)
+	return
+}
+
+// ReplicateRows copies the row of the cols first slots of ctIn, the other slots being zero, on each row of a
+// rows x cols matrix packed row-major, with O(log(rows)) rotations and no level.
+// The rotation keys of Parameters.RotationsForReplicateRows(rows, cols) must be in the EvaluationKey.
+func (eval *Evaluator) ReplicateRows(ctIn *ckks.Ciphertext, rows, cols int, ctOut *ckks.Ciphertext) {
+	eval.Replicate(ctIn, cols, rows, ctOut)
+}
+
+// ReplicateColumnsNew replicates the first column of ctIn as in ReplicateColumns and returns the result in a newly
+// created element.
+func (eval *Evaluator) ReplicateColumnsNew(ctIn *ckks.Ciphertext, rows, cols int) (ctOut *ckks.Ciphertext) {
+	ctOut = ckks.NewCiphertext(eval.params.Parameters, 1, ctIn.Level(), ctIn.Scale)
+	eval.ReplicateColumns(ctIn, rows, cols, ctOut)
+	return
+}
+
+// ReplicateColumns copies the first column of the rows x cols matrix packed row-major in ctIn, the other slots
+// being zero, on each of its columns, with O(log(cols)) rotations and no level.
+// The rotation keys of Parameters.RotationsForReplicateColumns(rows, cols) must be in the EvaluationKey.
+func (eval *Evaluator) ReplicateColumns(ctIn *ckks.Ciphertext, rows, cols int, ctOut *ckks.Ciphertext) {
+	eval.Replicate(ctIn, 1, cols, ctOut)
+}
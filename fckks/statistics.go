@@ -0,0 +1,204 @@
+package fckks
+
+import (
+	"errors"
+
+	"fast-ksw/ckks"
+)
+
+// The statistics are computed on columns of n values packed in the first n slots of a ciphertext, the other slots
+// being zero. Their results are replicated in all the slots.
+
+// RotationsForSum returns the rotations used by Evaluator.SumNew and by the statistics built on it: MeanNew,
+// VarianceNew, CovarianceNew, CovarianceMatrixNew, CorrelationNew and CorrelationMatrixNew.
+// The keys are generated with frlwe.KeyGenerator.GenRotKeys.
+func (p Parameters) RotationsForSum() []int {
+	return p.RotationsForInnerSumLog(1, p.Slots())
+}
+
+// CorrelationDepth returns the number of logical levels consumed by CorrelationNew and CorrelationMatrixNew with the
+// NewtonParameters p of the inverse square roots of the variances: two for the covariances, InvSqrtDepth() for the
+// inverse square roots of the variances, and two for the products of the covariances by the inverse square roots.
+func (p NewtonParameters) CorrelationDepth() int {
+	return p.InvSqrtDepth() + 4
+}
+
+// SumNew sums the slots of ctIn with the rotate-and-add procedure of InnerSum and returns the sum, replicated in
+// all the slots, in a newly created element. It consumes no level.
+// The rotation keys of Parameters.RotationsForSum must be in the EvaluationKey.
+func (eval *Evaluator) SumNew(ctIn *ckks.Ciphertext) (ctOut *ckks.Ciphertext) {
+	return eval.InnerSumNew(ctIn, 1, eval.params.Slots())
+}
+
+// MeanNew returns the mean of the column of n values of ctIn, replicated in all the slots, in a newly created
+// element one logical level below, with the scale of ctIn. The division by n is the evaluation of the polynomial
+// x/n with the PolynomialEvaluator.
+// It returns an error if ctIn is not of degree 1 or is at logical level 0.
+func (eval *Evaluator) MeanNew(ctIn *ckks.Ciphertext, n int) (ctOut *ckks.Ciphertext, err error) {
+
+	if err = eval.checkStatisticsInput("Mean", []*ckks.Ciphertext{ctIn}, n, 1); err != nil {
+		return nil, err
+	}
+
+	return eval.mean(ctIn, n, ctIn.Scale)
+}
+
+// VarianceNew returns the population variance of the column of n values of ctIn, replicated in all the slots, in a
+// newly created element two logical levels below, as in CovarianceNew(ctIn, ctIn, n).
+func (eval *Evaluator) VarianceNew(ctIn *ckks.Ciphertext, n int) (ctOut *ckks.Ciphertext, err error) {
+	return eval.CovarianceNew(ctIn, ctIn, n)
+}
+
+// CovarianceNew returns the population covariance of the columns of n values of ctX and ctY, replicated in all the
+// slots, in a newly created element two logical levels below. It is computed as mean(x*y) - mean(x)*mean(y), whose
+// two terms are at the same level and scale: the product of the means is computed in parallel with the product
+// x*y, and mean(x*y) is brought to the scale of the product of the means by the division by n.
+// It returns an error if the ciphertexts are not of degree 1 or have less than two logical levels.
+func (eval *Evaluator) CovarianceNew(ctX, ctY *ckks.Ciphertext, n int) (ctOut *ckks.Ciphertext, err error) {
+
+	if err = eval.checkStatisticsInput("Covariance", []*ckks.Ciphertext{ctX, ctY}, n, 2); err != nil {
+		return nil, err
+	}
+
+	means, err := eval.means([]*ckks.Ciphertext{ctX, ctY}, n)
+	if err != nil {
+		return nil, err
+	}
+
+	return eval.covariance(ctX, ctY, means[0], means[1], n)
+}
+
+// CovarianceMatrixNew returns the population covariance matrix of the columns of n values of cts, as in
+// CovarianceNew, in newly created elements two logical levels below. The means of the columns are computed once,
+// and the matrix being symmetric, cov[j][i] is the same element as cov[i][j].
+// It returns an error if the ciphertexts are not of degree 1 or have less than two logical levels.
+func (eval *Evaluator) CovarianceMatrixNew(cts []*ckks.Ciphertext, n int) (cov [][]*ckks.Ciphertext, err error) {
+
+	if err = eval.checkStatisticsInput("CovarianceMatrix", cts, n, 2); err != nil {
+		return nil, err
+	}
+
+	means, err := eval.means(cts, n)
+	if err != nil {
+		return nil, err
+	}
+
+	cov = make([][]*ckks.Ciphertext, len(cts))
+	for i := range cov {
+		cov[i] = make([]*ckks.Ciphertext, len(cts))
+	}
+
+	for i := range cts {
+		for j := i; j < len(cts); j++ {
+			if cov[i][j], err = eval.covariance(cts[i], cts[j], means[i], means[j], n); err != nil {
+				return nil, err
+			}
+			cov[j][i] = cov[i][j]
+		}
+	}
+
+	return
+}
+
+// CorrelationNew returns the Pearson correlation of the columns of n values of ctX and ctY, replicated in all the
+// slots, in a newly created element p.CorrelationDepth() logical levels below, as in CorrelationMatrixNew.
+func (eval *Evaluator) CorrelationNew(ctX, ctY *ckks.Ciphertext, n int, p NewtonParameters) (ctOut *ckks.Ciphertext, err error) {
+
+	corr, err := eval.CorrelationMatrixNew([]*ckks.Ciphertext{ctX, ctY}, n, p)
+	if err != nil {
+		return nil, err
+	}
+
+	return corr[0][1], nil
+}
+
+// CorrelationMatrixNew returns the Pearson correlation matrix of the columns of n values of cts, in newly created
+// elements p.CorrelationDepth() logical levels below. The correlation of the columns i and j is
+// cov[i][j] * (r[i] * r[j]), with r[i] the inverse square root of the variance cov[i][i] computed by InvSqrtNew
+// with the NewtonParameters p, whose interval [A, B] must contain the variances. The product r[i] * r[j] is computed
+// while the covariances wait at their level. The matrix being symmetric, corr[j][i] is the same element as
+// corr[i][j].
+// It returns an error if the ciphertexts are not of degree 1 or have not enough levels.
+func (eval *Evaluator) CorrelationMatrixNew(cts []*ckks.Ciphertext, n int, p NewtonParameters) (corr [][]*ckks.Ciphertext, err error) {
+
+	if err = eval.checkStatisticsInput("Correlation", cts, n, p.CorrelationDepth()); err != nil {
+		return nil, err
+	}
+
+	cov, err := eval.CovarianceMatrixNew(cts, n)
+	if err != nil {
+		return nil, err
+	}
+
+	r := make([]*ckks.Ciphertext, len(cts))
+	for i := range r {
+		if r[i], err = eval.InvSqrtNew(cov[i][i], p); err != nil {
+			return nil, err
+		}
+	}
+
+	corr = make([][]*ckks.Ciphertext, len(cts))
+	for i := range corr {
+		corr[i] = make([]*ckks.Ciphertext, len(cts))
+	}
+
+	for i := range cts {
+		for j := i; j < len(cts); j++ {
+			corr[i][j] = eval.mulRescaleLogicalLevelNew(cov[i][j], eval.mulRescaleLogicalLevelNew(r[i], r[j]))
+			corr[j][i] = corr[i][j]
+		}
+	}
+
+	return
+}
+
+// means returns the means of the columns of n values of cts, at the scales of cts.
+func (eval *Evaluator) means(cts []*ckks.Ciphertext, n int) (means []*ckks.Ciphertext, err error) {
+
+	means = make([]*ckks.Ciphertext, len(cts))
+	for i, ct := range cts {
+		if i > 0 && ct == cts[i-1] {
+			means[i] = means[i-1]
+		} else if means[i], err = eval.mean(ct, n, ct.Scale); err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
+
+// mean returns the mean of the column of n values of ct at the given scale, one logical level below.
+func (eval *Evaluator) mean(ct *ckks.Ciphertext, n int, targetScale float64) (*ckks.Ciphertext, error) {
+	return NewPolynomialEvaluator(eval).EvaluateNew(eval.SumNew(ct), NewPolynomial(Monomial, []complex128{0, complex(1/float64(n), 0)}, 0, 0), targetScale)
+}
+
+// covariance returns the covariance of the columns of n values of ctX and ctY of means meanX and meanY.
+func (eval *Evaluator) covariance(ctX, ctY, meanX, meanY *ckks.Ciphertext, n int) (ctOut *ckks.Ciphertext, err error) {
+
+	prod := eval.mulRescaleLogicalLevelNew(meanX, meanY)
+
+	if ctOut, err = eval.mean(eval.mulRescaleLogicalLevelNew(ctX, ctY), n, prod.Scale); err != nil {
+		return nil, err
+	}
+
+	eval.Sub(ctOut, prod, ctOut)
+
+	return ctOut, nil
+}
+
+// checkStatisticsInput returns an error for the operation op if n is not a valid number of values or if one of cts
+// is not of degree 1 or has less than depth logical levels.
+func (eval *Evaluator) checkStatisticsInput(op string, cts []*ckks.Ciphertext, n, depth int) error {
+
+	if n < 1 || n > eval.params.Slots() {
+		return errors.New("cannot " + op + ": the number of values must be between 1 and the number of slots")
+	}
+
+	for _, ct := range cts {
+		if err := eval.checkInput(op, ct, depth); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,6 +1,11 @@
 package frlwe
 
 import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
 	"math"
 	"math/big"
 	"math/bits"
@@ -11,6 +16,7 @@ import (
 	"fast-ksw/utils"
 
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2b"
 )
 
 var (
@@ -152,6 +158,25 @@ func TestFRLWE(t *testing.T) {
 		panic(err)
 	}
 	testExternalProduct(testctx, t)
+	testSecretDistributions(testctx, t)
+	testTruncatedKeys(testctx, t)
+	testKeySwitchingBackends(testctx, t)
+	testParallelKeyGen(testctx, t)
+	testKnownAnswer(testctx, t)
+}
+
+func TestFRLWEConjugateInvariant(t *testing.T) {
+	pl := PN15QP880
+	pl.LogN = 14
+	pl.RingType = ring.ConjugateInvariant
+
+	testctx, err := genTestParams(NewParametersFromLiteral(pl))
+	if err != nil {
+		panic(err)
+	}
+	testExternalProduct(testctx, t)
+	testTruncatedKeys(testctx, t)
+	testKeySwitchingBackends(testctx, t)
 }
 
 func testExternalProduct(testctx *testContext, t *testing.T) {
@@ -190,3 +215,377 @@ func testExternalProduct(testctx *testContext, t *testing.T) {
 
 	})
 }
+
+// Returns the number of non-zero coefficients of sk
+func secretHammingWeight(params Parameters, sk *rlwe.SecretKey) (hw int) {
+	ringQ := params.RingQ()
+	skQ := ringQ.NewPoly()
+	ringQ.InvMForm(sk.Value.Q, skQ)
+	ringQ.InvNTT(skQ, skQ)
+	for _, c := range skQ.Coeffs[0] {
+		if c != 0 {
+			hw++
+		}
+	}
+	return
+}
+
+func testSecretDistributions(testctx *testContext, t *testing.T) {
+	params := testctx.params
+	kgen := testctx.kgen
+
+	t.Run("SecretKeyHammingWeight", func(t *testing.T) {
+		sk := kgen.GenSecretKeyWithHammingWeight(64)
+		require.Equal(t, 64, secretHammingWeight(params, sk))
+	})
+
+	t.Run("SecretKeyFromParameters", func(t *testing.T) {
+		pl := PN15QP880
+		pl.H = 192
+		paramsH := NewParametersFromLiteral(pl)
+		sk, _ := NewKeyGenerator(paramsH).GenKeyPair()
+		require.Equal(t, 192, secretHammingWeight(paramsH, sk))
+
+		pl.SecretDistrib = Ternary
+		pl.SecretProba = 0.9
+		paramsTernary := NewParametersFromLiteral(pl)
+		hw := secretHammingWeight(paramsTernary, NewKeyGenerator(paramsTernary).GenSecretKey())
+		require.InDelta(t, paramsTernary.N()/10, hw, float64(paramsTernary.N())/50)
+	})
+
+	t.Run("ExternalProductSparseSecret", func(t *testing.T) {
+		sk := kgen.GenSecretKeyWithHammingWeight(32)
+		rlk := kgen.GenRelinKey(sk)
+		ringQ := params.RingQ()
+
+		a := ringQ.NewPoly()
+		c0 := ringQ.NewPoly()
+		c1 := ringQ.NewPoly()
+		testctx.uSamplerQ.Read(a)
+
+		testctx.ksw.SwitchKey(params.MaxLevel(), a, rlk.Value[0], rlk.Value[1], c0, c1)
+
+		ringQ.NTT(c0, c0)
+		ringQ.NTT(c1, c1)
+		ringQ.NTT(a, a)
+		ringQ.MulCoeffsMontgomery(a, sk.Value.Q, a)
+		ringQ.MulCoeffsMontgomery(a, sk.Value.Q, a)
+		ringQ.MulCoeffsMontgomeryAndAdd(c1, sk.Value.Q, c0)
+		ringQ.Sub(c0, a, c0)
+		ringQ.InvNTT(c0, c0)
+
+		log2Bound := bits.Len64(uint64(math.Floor(rlwe.DefaultSigma*6)) * uint64(params.N()))
+		require.GreaterOrEqual(t, log2Bound+3, log2OfInnerSum(params.MaxLevel(), ringQ, c0))
+	})
+
+	t.Run("EncapsulationKey", func(t *testing.T) {
+		skSparse := kgen.GenSecretKeyWithHammingWeight(32)
+		ek := kgen.GenEncapsulationKey(testctx.sk, skSparse)
+		require.Equal(t, 0, ek.DenseToSparse[0].LevelQ())
+		require.Equal(t, params.MaxLevel(), ek.SparseToDense[0].LevelQ())
+
+		log2Bound := bits.Len64(uint64(math.Floor(rlwe.DefaultSigma*6)) * uint64(params.N()))
+		require.GreaterOrEqual(t, log2Bound+3, switchSecretErrorLvl(testctx, 0, ek.DenseToSparse, testctx.sk, skSparse))
+		require.GreaterOrEqual(t, log2Bound+3, switchSecretErrorLvl(testctx, params.MaxLevel(), ek.SparseToDense, skSparse, testctx.sk))
+		require.Panics(t, func() { switchSecretErrorLvl(testctx, 1, ek.DenseToSparse, testctx.sk, skSparse) })
+	})
+}
+
+// Returns the log2 of the error of switching a random polynomial of level levelQ from skIn to skOut with swk
+func switchSecretErrorLvl(testctx *testContext, levelQ int, swk [2]*SwitchingKey, skIn, skOut *rlwe.SecretKey) int {
+	ringQ := testctx.params.RingQ()
+
+	a := ringQ.NewPolyLvl(levelQ)
+	c0 := ringQ.NewPolyLvl(levelQ)
+	c1 := ringQ.NewPolyLvl(levelQ)
+	testctx.uSamplerQ.Read(a)
+
+	testctx.ksw.SwitchKey(levelQ, a, swk[0], swk[1], c0, c1)
+
+	ringQ.NTTLvl(levelQ, c0, c0)
+	ringQ.NTTLvl(levelQ, c1, c1)
+	ringQ.NTTLvl(levelQ, a, a)
+	ringQ.MulCoeffsMontgomeryLvl(levelQ, a, skIn.Value.Q, a)
+	ringQ.MulCoeffsMontgomeryAndAddLvl(levelQ, c1, skOut.Value.Q, c0)
+	ringQ.SubLvl(levelQ, c0, a, c0)
+	ringQ.InvNTTLvl(levelQ, c0, c0)
+
+	return log2OfInnerSum(levelQ, ringQ, c0)
+}
+
+// Returns the log2 of the error of switching a random polynomial of level levelQ with rlk
+func switchKeyErrorLvl(testctx *testContext, levelQ int, rlk *RelinKey) int {
+	params := testctx.params
+	sk := testctx.sk
+	ringQ := params.RingQ()
+
+	a := ringQ.NewPolyLvl(levelQ)
+	c0 := ringQ.NewPolyLvl(levelQ)
+	c1 := ringQ.NewPolyLvl(levelQ)
+	testctx.uSamplerQ.Read(a)
+
+	testctx.ksw.SwitchKey(levelQ, a, rlk.Value[0], rlk.Value[1], c0, c1)
+
+	ringQ.NTTLvl(levelQ, c0, c0)
+	ringQ.NTTLvl(levelQ, c1, c1)
+	ringQ.NTTLvl(levelQ, a, a)
+	ringQ.MulCoeffsMontgomeryLvl(levelQ, a, sk.Value.Q, a)
+	ringQ.MulCoeffsMontgomeryLvl(levelQ, a, sk.Value.Q, a)
+	ringQ.MulCoeffsMontgomeryAndAddLvl(levelQ, c1, sk.Value.Q, c0)
+	ringQ.SubLvl(levelQ, c0, a, c0)
+	ringQ.InvNTTLvl(levelQ, c0, c0)
+
+	return log2OfInnerSum(levelQ, ringQ, c0)
+}
+
+func testTruncatedKeys(testctx *testContext, t *testing.T) {
+	params := testctx.params
+	kgen := testctx.kgen
+	log2Bound := bits.Len64(uint64(math.Floor(rlwe.DefaultSigma*6)) * uint64(params.N()))
+
+	t.Run("TruncatedKeyDimensions", func(t *testing.T) {
+		for _, levelQ := range []int{0, 3, params.MaxLevel()} {
+			swk := NewSwitchingKeyLvl(params, levelQ)
+			beta := int(math.Ceil(float64(levelQ+1) / float64(params.Alpha())))
+			blockLen := int(math.Ceil(float64(levelQ+params.Alpha()+1) / float64(params.Gamma())))
+			require.Equal(t, levelQ, swk.LevelQ())
+			require.Len(t, swk.Value, beta)
+			require.Len(t, swk.Value[0], blockLen)
+		}
+	})
+
+	t.Run("ExternalProductTruncatedKey", func(t *testing.T) {
+		levelQ := 4
+		rlk := kgen.GenRelinKeyLvl(levelQ, testctx.sk)
+		for level := 0; level <= levelQ; level++ {
+			require.GreaterOrEqual(t, log2Bound+3, switchKeyErrorLvl(testctx, level, rlk))
+		}
+	})
+
+	t.Run("ExternalProductTruncatedKeyLevelTooHigh", func(t *testing.T) {
+		rlk := kgen.GenRelinKeyLvl(2, testctx.sk)
+		require.Panics(t, func() { switchKeyErrorLvl(testctx, 3, rlk) })
+	})
+}
+
+// Returns the log2 of the error of the relinearization of a random a, given in the NTT domain, by the backend ksw
+func backendSwitchErrorLvl(testctx *testContext, levelQ int, ksw rlwe.KeySwitchingBackend, rlk rlwe.KeySwitchingKey) int {
+	sk := testctx.sk
+	ringQ := testctx.params.RingQ()
+
+	a := ringQ.NewPolyLvl(levelQ)
+	c0 := ringQ.NewPolyLvl(levelQ)
+	c1 := ringQ.NewPolyLvl(levelQ)
+	testctx.uSamplerQ.Read(a)
+	a.IsNTT = true
+
+	ksw.Switch(levelQ, a, rlk, c0, c1)
+
+	ringQ.MulCoeffsMontgomeryLvl(levelQ, a, sk.Value.Q, a)
+	ringQ.MulCoeffsMontgomeryLvl(levelQ, a, sk.Value.Q, a)
+	ringQ.MulCoeffsMontgomeryAndAddLvl(levelQ, c1, sk.Value.Q, c0)
+	ringQ.SubLvl(levelQ, c0, a, c0)
+	ringQ.InvNTTLvl(levelQ, c0, c0)
+
+	return log2OfInnerSum(levelQ, ringQ, c0)
+}
+
+func testKeySwitchingBackends(testctx *testContext, t *testing.T) {
+	params := testctx.params
+	ringQ := params.RingQ()
+	ringP := params.RingP()
+	log2Bound := bits.Len64(uint64(math.Floor(rlwe.DefaultSigma*6)) * uint64(params.N()))
+
+	backends := []struct {
+		name string
+		ksw  rlwe.KeySwitchingBackend
+		rlk  rlwe.KeySwitchingKey
+	}{
+		{"rlwe", rlwe.NewKeySwitcher(params.Parameters), testctx.kgen.KeyGenerator.GenRelinearizationKey(testctx.sk, 1).Keys[0]},
+		{"frlwe", testctx.ksw, testctx.rlk},
+	}
+
+	for _, backend := range backends {
+
+		ksw, rlk := backend.ksw, backend.rlk
+
+		t.Run("KeySwitchingBackend/"+backend.name+"/Switch", func(t *testing.T) {
+			for _, levelQ := range []int{0, 5, params.MaxLevel()} {
+				require.GreaterOrEqual(t, log2Bound+3, backendSwitchErrorLvl(testctx, levelQ, ksw, rlk))
+			}
+		})
+
+		t.Run("KeySwitchingBackend/"+backend.name+"/HoistedAndNoModDown", func(t *testing.T) {
+
+			levelQ := 5
+			levelP := params.PCount() - 1
+
+			a := ringQ.NewPolyLvl(levelQ)
+			testctx.uSamplerQ.Read(a)
+			a.IsNTT = true
+
+			c0, c1 := ringQ.NewPolyLvl(levelQ), ringQ.NewPolyLvl(levelQ)
+			ksw.Switch(levelQ, a, rlk, c0, c1)
+
+			decomp := ksw.NewDecomposition()
+			ksw.Decompose(levelQ, a, decomp)
+
+			h0, h1 := ringQ.NewPolyLvl(levelQ), ringQ.NewPolyLvl(levelQ)
+			ksw.SwitchHoisted(levelQ, decomp, rlk, h0, h1, ringP.NewPoly(), ringP.NewPoly())
+			require.True(t, ringQ.EqualLvl(levelQ, c0, h0))
+			require.True(t, ringQ.EqualLvl(levelQ, c1, h1))
+
+			n0 := rlwe.PolyQP{Q: ringQ.NewPolyLvl(levelQ), P: ringP.NewPoly()}
+			n1 := rlwe.PolyQP{Q: ringQ.NewPolyLvl(levelQ), P: ringP.NewPoly()}
+			ksw.SwitchNoModDown(levelQ, a, rlk, n0.Q, n0.P, n1.Q, n1.P)
+
+			hn0 := rlwe.PolyQP{Q: ringQ.NewPolyLvl(levelQ), P: ringP.NewPoly()}
+			hn1 := rlwe.PolyQP{Q: ringQ.NewPolyLvl(levelQ), P: ringP.NewPoly()}
+			ksw.SwitchHoistedNoModDown(levelQ, decomp, rlk, hn0.Q, hn1.Q, hn0.P, hn1.P)
+			require.True(t, ringQ.EqualLvl(levelQ, n0.Q, hn0.Q) && ringP.EqualLvl(levelP, n0.P, hn0.P))
+			require.True(t, ringQ.EqualLvl(levelQ, n1.Q, hn1.Q) && ringP.EqualLvl(levelP, n1.P, hn1.P))
+
+			baseconverter := ring.NewBasisExtender(ringQ, ringP)
+			baseconverter.ModDownQPtoQNTT(levelQ, levelP, n0.Q, n0.P, n0.Q)
+			baseconverter.ModDownQPtoQNTT(levelQ, levelP, n1.Q, n1.P, n1.Q)
+			require.True(t, ringQ.EqualLvl(levelQ, c0, n0.Q))
+			require.True(t, ringQ.EqualLvl(levelQ, c1, n1.Q))
+		})
+	}
+
+	t.Run("KeySwitchingBackend/WrongKeyType", func(t *testing.T) {
+		a := ringQ.NewPoly()
+		require.Panics(t, func() { testctx.ksw.Switch(0, a, backends[0].rlk, ringQ.NewPoly(), ringQ.NewPoly()) })
+		require.Panics(t, func() { backends[0].ksw.Switch(0, a, testctx.rlk, ringQ.NewPoly(), ringQ.NewPoly()) })
+	})
+
+	t.Run("EvaluationKeySet", func(t *testing.T) {
+		rtks := NewRotationKeySet()
+		rtks.Add(testctx.kgen.GenRotKeyLvl(0, 3, testctx.sk))
+		evks := NewEvaluationKeySet(params, EvaluationKey{Rlk: testctx.rlk, Rtks: rtks})
+
+		rlk, ok := evks.GetRelinearizationKey()
+		require.True(t, ok)
+		require.Equal(t, rlwe.KeySwitchingKey(testctx.rlk), rlk)
+
+		rtk, ok := evks.GetGaloisKey(params.GaloisElementForColumnRotationBy(3))
+		require.True(t, ok)
+		require.Equal(t, rlwe.KeySwitchingKey(rtks.Keys[3]), rtk)
+
+		_, ok = evks.GetGaloisKey(params.GaloisElementForColumnRotationBy(4))
+		require.False(t, ok)
+	})
+}
+
+func requireRotationKeysEqual(t *testing.T, rtk0, rtk1 *RotationKey) {
+	require.Equal(t, rtk0.Rotidx, rtk1.Rotidx)
+	for k := range rtk0.Value {
+		require.Equal(t, rtk0.Value[k].LevelQ(), rtk1.Value[k].LevelQ())
+		require.Equal(t, len(rtk0.Value[k].Value), len(rtk1.Value[k].Value))
+		for i := range rtk0.Value[k].Value {
+			for j := range rtk0.Value[k].Value[i] {
+				require.True(t, rtk0.Value[k].Value[i][j].Equals(rtk1.Value[k].Value[i][j]))
+			}
+		}
+	}
+}
+
+func testParallelKeyGen(testctx *testContext, t *testing.T) {
+	params := testctx.params
+	sk := testctx.sk
+	levelQ := 3
+	rots := []int{1, 2, 5, 16}
+	seed := []byte("parallel key generation test seed")
+
+	t.Run("ParallelKeyGenDeterministic", func(t *testing.T) {
+		rtks0 := NewParallelKeyGenerator(params, seed, 1).GenRotKeys(levelQ, rots, sk)
+		rtks1 := NewParallelKeyGenerator(params, seed, 3).GenRotKeys(levelQ, rots, sk)
+
+		require.Len(t, rtks1.Keys, len(rots))
+		for _, rot := range rots {
+			rtk0, ok0 := rtks0.GetRotationKey(uint64(rot))
+			rtk1, ok1 := rtks1.GetRotationKey(uint64(rot))
+			require.True(t, ok0 && ok1)
+			requireRotationKeysEqual(t, rtk0, rtk1)
+		}
+	})
+
+	t.Run("ParallelKeyGenMatchesKeyGenerator", func(t *testing.T) {
+		rtks := NewParallelKeyGenerator(params, seed, 2).GenRotKeys(levelQ, rots, sk)
+
+		// The key of each rotation is the key generated from the PRNG keyed with blake2b-512(seed, galEl), by a
+		// new KeyGenerator as well as by a reseeded KeyGenerator which has generated other keys before.
+		prngFor := func(rot int) utils.PRNG {
+			var galEl [8]byte
+			binary.BigEndian.PutUint64(galEl[:], params.GaloisElementForColumnRotationBy(rot))
+			hash, err := blake2b.New512(seed)
+			require.NoError(t, err)
+			hash.Write(galEl[:])
+			prng, err := utils.NewKeyedPRNG(hash.Sum(nil))
+			require.NoError(t, err)
+			return prng
+		}
+
+		kgen := NewKeyGenerator(params)
+		kgen.GenRotKeyLvl(levelQ, 3, sk)
+		for _, rot := range rots {
+			rtk, _ := rtks.GetRotationKey(uint64(rot))
+			requireRotationKeysEqual(t, NewKeyGeneratorWithPRNG(params, prngFor(rot)).GenRotKeyLvl(levelQ, rot, sk), rtk)
+
+			kgen.Reseed(prngFor(rot))
+			requireRotationKeysEqual(t, kgen.GenRotKeyLvl(levelQ, rot, sk), rtk)
+		}
+	})
+
+	t.Run("ParallelKeyGenStreaming", func(t *testing.T) {
+		rtks := NewParallelKeyGenerator(params, seed, 1).GenRotKeys(levelQ, rots, sk)
+
+		buff := new(bytes.Buffer)
+		n, err := NewParallelKeyGenerator(params, seed, 3).WriteRotKeys(levelQ, rots, sk, buff)
+		require.NoError(t, err)
+		require.Equal(t, int64(buff.Len()), n)
+
+		for _, rot := range rots {
+			rtk, err := ReadRotationKey(buff)
+			require.NoError(t, err)
+			require.Equal(t, uint64(rot), rtk.Rotidx)
+			requireRotationKeysEqual(t, rtks.Keys[uint64(rot)], rtk)
+		}
+
+		_, err = ReadRotationKey(buff)
+		require.Equal(t, io.EOF, err)
+	})
+}
+
+// Known-answer hashes of the keys generated by a KeyGenerator seeded with katSeed under PN15QP880.
+// They change whenever the sampling, the ring arithmetic or the conversion to the T basis do.
+var (
+	katSeed    = []byte("fast-ksw known-answer test seed")
+	katSk      = "355256ce5835dc58438f8183cf715e1c1bcc8e086849a48c5d9e0eb1fe814e3f"
+	katRlk     = "7c87f96fe39222f9526fea7861a709c83b7271b6a258e5ea7bf3faef4c5b88b7"
+	katRtk     = "dbc7783d4556bfc2cc25178f34bcda51152d14284d0a957858fc4c68bbbef47c"
+	katRtkLvl3 = "12888f293e7c141353fe3c3d8d0782176685507d1062a3c18d77ad67a12819a3"
+)
+
+func blake2bHex(t *testing.T, obj encoding.BinaryMarshaler) string {
+	data, err := obj.MarshalBinary()
+	require.NoError(t, err)
+	hash := blake2b.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func testKnownAnswer(testctx *testContext, t *testing.T) {
+	params := testctx.params
+
+	t.Run("KnownAnswer", func(t *testing.T) {
+		prng, err := utils.NewKeyedPRNG(katSeed)
+		require.NoError(t, err)
+		kgen := NewKeyGeneratorWithPRNG(params, prng)
+
+		sk := kgen.GenSecretKey()
+		require.Equal(t, katSk, blake2bHex(t, sk))
+		require.Equal(t, katRlk, blake2bHex(t, kgen.GenRelinKey(sk)))
+		require.Equal(t, katRtk, blake2bHex(t, kgen.GenRotKey(5, sk)))
+		require.Equal(t, katRtkLvl3, blake2bHex(t, kgen.GenRotKeyLvl(3, 7, sk)))
+	})
+}
@@ -3,6 +3,7 @@ package frlwe
 import (
 	"fast-ksw/ring"
 	"fast-ksw/rlwe"
+	"fast-ksw/utils"
 
 	"math"
 )
@@ -17,8 +18,18 @@ type KeyGenerator struct {
 }
 
 func NewKeyGenerator(params Parameters) (keygen *KeyGenerator) {
+	return newKeyGenerator(params, rlwe.NewKeyGenerator(params.Parameters))
+}
+
+// NewKeyGeneratorWithPRNG creates a new KeyGenerator sampling all keys from the provided PRNG.
+// Two KeyGenerators created from PRNGs in the same state generate the same keys.
+func NewKeyGeneratorWithPRNG(params Parameters, prng utils.PRNG) (keygen *KeyGenerator) {
+	return newKeyGenerator(params, rlwe.NewKeyGeneratorWithPRNG(params.Parameters, prng))
+}
+
+func newKeyGenerator(params Parameters, rlweKeygen rlwe.KeyGenerator) (keygen *KeyGenerator) {
 	keygen = new(KeyGenerator)
-	keygen.KeyGenerator = rlwe.NewKeyGenerator(params.Parameters)
+	keygen.KeyGenerator = rlweKeygen
 	keygen.params = params
 
 	level := params.MaxLevel()
@@ -40,7 +51,7 @@ func NewKeyGenerator(params Parameters) (keygen *KeyGenerator) {
 			}
 		}
 
-		keygen.ringRi[i], _ = ring.NewRing(params.N(), modulusRi)
+		keygen.ringRi[i], _ = ring.NewRingFromType(params.N(), modulusRi, params.RingType())
 		keygen.convRiT[i] = ring.NewBasisExtender(keygen.ringRi[i], params.RingT())
 	}
 	keygen.polyRiPool = params.RingR().NewPolyLvl(gamma - 1)
@@ -48,19 +59,49 @@ func NewKeyGenerator(params Parameters) (keygen *KeyGenerator) {
 	return
 }
 
+// GenSecretKey samples a new secret key from the distribution selected by the parameters.
 func (keygen *KeyGenerator) GenSecretKey() *rlwe.SecretKey {
-	return keygen.GenSecretKey()
+	switch keygen.params.SecretDistribution() {
+	case Ternary:
+		return keygen.KeyGenerator.GenSecretKeyWithDistrib(keygen.params.SecretProba())
+	case Gaussian:
+		return keygen.KeyGenerator.GenSecretKeyGaussian()
+	default:
+		return keygen.KeyGenerator.GenSecretKeyWithHammingWeight(keygen.params.HammingWeight())
+	}
+}
+
+// GenSecretKeyGaussian generates a new secret key with the error distribution.
+func (keygen *KeyGenerator) GenSecretKeyGaussian() *rlwe.SecretKey {
+	return keygen.KeyGenerator.GenSecretKeyGaussian()
+}
+
+// GenSecretKeyWithHammingWeight generates a new secret key with exactly hw non-zero coefficients.
+func (keygen *KeyGenerator) GenSecretKeyWithHammingWeight(hw int) *rlwe.SecretKey {
+	return keygen.KeyGenerator.GenSecretKeyWithHammingWeight(hw)
 }
 
+// GenSecretKeyWithDistrib generates a new secret key with the distribution [(1-p)/2, p, (1-p)/2].
+func (keygen *KeyGenerator) GenSecretKeyWithDistrib(p float64) *rlwe.SecretKey {
+	return keygen.KeyGenerator.GenSecretKeyWithDistrib(p)
+}
+
+// GenPublicKey generates a new public key from the provided secret key.
 func (keygen *KeyGenerator) GenPublicKey(sk *rlwe.SecretKey) *rlwe.PublicKey {
-	return keygen.GenPublicKey(sk)
+	return keygen.KeyGenerator.GenPublicKey(sk)
 }
 
-func (keygen *KeyGenerator) polyQPToPolyTs(polyQP rlwe.PolyQP, polyTs []*ring.Poly) {
+// GenKeyPair generates a secret key with GenSecretKey and its public key.
+func (keygen *KeyGenerator) GenKeyPair() (sk *rlwe.SecretKey, pk *rlwe.PublicKey) {
+	sk = keygen.GenSecretKey()
+	return sk, keygen.GenPublicKey(sk)
+}
+
+// polyQPToPolyTs converts the R_i blocks of polyQP needed at levelQ to the T basis.
+func (keygen *KeyGenerator) polyQPToPolyTs(levelQ int, polyQP rlwe.PolyQP, polyTs []*ring.Poly) {
 
 	params := keygen.params
 
-	level := params.MaxLevel()
 	alpha := params.Alpha()
 	gamma := params.Gamma()
 
@@ -69,7 +110,7 @@ func (keygen *KeyGenerator) polyQPToPolyTs(polyQP rlwe.PolyQP, polyTs []*ring.Po
 
 	coeffsQ := polyQP.Q.GetCoefficients()
 	coeffsP := polyQP.P.GetCoefficients()
-	blockLen := int(math.Ceil(float64(level+alpha+1) / float64(gamma)))
+	blockLen := int(math.Ceil(float64(levelQ+alpha+1) / float64(gamma)))
 
 	for i := 0; i < blockLen; i++ {
 		levelRi := -1
@@ -77,7 +118,7 @@ func (keygen *KeyGenerator) polyQPToPolyTs(polyQP rlwe.PolyQP, polyTs []*ring.Po
 			if i*gamma+j < alpha {
 				levelRi++
 				copy(keygen.polyRiPool.Coeffs[j], coeffsP[i*gamma+j])
-			} else if i*gamma+j < level+alpha+1 {
+			} else if i*gamma+j < levelQ+alpha+1 {
 				levelRi++
 				copy(keygen.polyRiPool.Coeffs[j], coeffsQ[i*gamma+j-alpha])
 			}
@@ -90,56 +131,84 @@ func (keygen *KeyGenerator) polyQPToPolyTs(polyQP rlwe.PolyQP, polyTs []*ring.Po
 
 }
 
-func (keygen *KeyGenerator) GenRelinKey(sk *rlwe.SecretKey) (rlk *RelinKey) {
+// swkToFastSwk converts the gadget rows of swk needed at the level of swkOut into swkOut.
+func (keygen *KeyGenerator) swkToFastSwk(swk *rlwe.SwitchingKey, swkOut [2]*SwitchingKey) {
 
 	params := keygen.params
 	ringQP := params.RingQP()
-	rlk = NewRelinKey(params)
-	rlweRlk := keygen.GenRelinearizationKey(sk, 1).Keys[0]
-
-	beta := params.Beta()
-	alpha := params.Alpha()
 
-	levelQ := params.MaxLevel()
-	levelP := alpha - 1
+	levelQ := swkOut[0].LevelQ()
+	levelP := params.Alpha() - 1
 
-	for i := 0; i < beta; i++ {
-		ringQP.InvMFormLvl(levelQ, levelP, rlweRlk.Value[i][0], rlweRlk.Value[i][0])
-		ringQP.InvNTTLvl(levelQ, levelP, rlweRlk.Value[i][0], rlweRlk.Value[i][0])
-		keygen.polyQPToPolyTs(rlweRlk.Value[i][0], rlk.Value[0].Value[i])
+	for i := range swkOut[0].Value {
+		ringQP.InvMFormLvl(levelQ, levelP, swk.Value[i][0], swk.Value[i][0])
+		ringQP.InvNTTLvl(levelQ, levelP, swk.Value[i][0], swk.Value[i][0])
+		keygen.polyQPToPolyTs(levelQ, swk.Value[i][0], swkOut[0].Value[i])
 
-		ringQP.InvMFormLvl(levelQ, levelP, rlweRlk.Value[i][1], rlweRlk.Value[i][1])
-		ringQP.InvNTTLvl(levelQ, levelP, rlweRlk.Value[i][1], rlweRlk.Value[i][1])
-		keygen.polyQPToPolyTs(rlweRlk.Value[i][1], rlk.Value[1].Value[i])
+		ringQP.InvMFormLvl(levelQ, levelP, swk.Value[i][1], swk.Value[i][1])
+		ringQP.InvNTTLvl(levelQ, levelP, swk.Value[i][1], swk.Value[i][1])
+		keygen.polyQPToPolyTs(levelQ, swk.Value[i][1], swkOut[1].Value[i])
 	}
+}
 
+func (keygen *KeyGenerator) GenRelinKey(sk *rlwe.SecretKey) (rlk *RelinKey) {
+	return keygen.GenRelinKeyLvl(keygen.params.MaxLevel(), sk)
+}
+
+// GenRelinKeyLvl generates a relinearization key for ciphertexts of level at most levelQ.
+func (keygen *KeyGenerator) GenRelinKeyLvl(levelQ int, sk *rlwe.SecretKey) (rlk *RelinKey) {
+	rlk = NewRelinKeyLvl(keygen.params, levelQ)
+	keygen.swkToFastSwk(keygen.GenRelinearizationKey(sk, 1).Keys[0], rlk.Value)
 	return
 }
 
 func (keygen *KeyGenerator) GenRotKey(rotidx int, sk *rlwe.SecretKey) (rtk *RotationKey) {
+	return keygen.GenRotKeyLvl(keygen.params.MaxLevel(), rotidx, sk)
+}
 
-	rotidx %= (keygen.params.N() / 2)
+// GenRotKeyLvl generates a rotation key for ciphertexts of level at most levelQ.
+func (keygen *KeyGenerator) GenRotKeyLvl(levelQ, rotidx int, sk *rlwe.SecretKey) (rtk *RotationKey) {
 
-	params := keygen.params
-	ringQP := params.RingQP()
-	rtk = NewRotationKey(params, uint64(rotidx))
-	rlweRtk := keygen.GenSwitchingKeyForRotationBy(rotidx, sk)
+	rtk = NewRotationKeyLvl(keygen.params, levelQ, keygen.params.RotationIndex(rotidx))
+	keygen.swkToFastSwk(keygen.GenSwitchingKeyForRotationBy(int(rtk.Rotidx), sk), rtk.Value)
 
-	beta := params.Beta()
-	alpha := params.Alpha()
+	return
+}
 
-	levelQ := params.MaxLevel()
-	levelP := alpha - 1
+func (keygen *KeyGenerator) GenConjugationKey(sk *rlwe.SecretKey) (cjk *ConjugationKey) {
+	return keygen.GenConjugationKeyLvl(keygen.params.MaxLevel(), sk)
+}
 
-	for i := 0; i < beta; i++ {
-		ringQP.InvMFormLvl(levelQ, levelP, rlweRtk.Value[i][0], rlweRtk.Value[i][0])
-		ringQP.InvNTTLvl(levelQ, levelP, rlweRtk.Value[i][0], rlweRtk.Value[i][0])
-		keygen.polyQPToPolyTs(rlweRtk.Value[i][0], rtk.Value[0].Value[i])
+// GenConjugationKeyLvl generates a conjugation (row rotation) key for ciphertexts of level at most levelQ.
+func (keygen *KeyGenerator) GenConjugationKeyLvl(levelQ int, sk *rlwe.SecretKey) (cjk *ConjugationKey) {
+	cjk = NewConjugationKeyLvl(keygen.params, levelQ)
+	keygen.swkToFastSwk(keygen.GenSwitchingKeyForRowRotation(sk), cjk.Value)
+	return
+}
 
-		ringQP.InvMFormLvl(levelQ, levelP, rlweRtk.Value[i][1], rlweRtk.Value[i][1])
-		ringQP.InvNTTLvl(levelQ, levelP, rlweRtk.Value[i][1], rlweRtk.Value[i][1])
-		keygen.polyQPToPolyTs(rlweRtk.Value[i][1], rtk.Value[1].Value[i])
+// GenEncapsulationKey generates the keys switching ciphertexts from skDense to the ephemeral sparse secret skSparse,
+// sampled with GenSecretKeyWithHammingWeight, and back. The switch to skSparse is only generated for ciphertexts
+// of level 0, which the bootstrapping switches to skSparse before raising their modulus.
+func (keygen *KeyGenerator) GenEncapsulationKey(skDense, skSparse *rlwe.SecretKey) (ek *EncapsulationKey) {
+	ek = NewEncapsulationKey(keygen.params)
+	keygen.swkToFastSwk(keygen.GenSwitchingKey(skDense, skSparse), ek.DenseToSparse)
+	keygen.swkToFastSwk(keygen.GenSwitchingKey(skSparse, skDense), ek.SparseToDense)
+	return
+}
+
+// GenRotKeys generates the rotation keys for all rotations in rots and returns them as a RotationKeySet.
+func (keygen *KeyGenerator) GenRotKeys(rots []int, sk *rlwe.SecretKey) (rtks *RotationKeySet) {
+	rtks = NewRotationKeySet()
+	for _, rot := range rots {
+		rtks.Add(keygen.GenRotKey(rot, sk))
 	}
+	return
+}
 
+// ConvertSwitchingKey converts the rlwe switching key swk to the T basis, keeping only the rows
+// and R_i blocks needed for ciphertexts of level at most levelQ. swk is left unchanged.
+func (keygen *KeyGenerator) ConvertSwitchingKey(levelQ int, swk *rlwe.SwitchingKey) (swkOut [2]*SwitchingKey) {
+	swkOut = [2]*SwitchingKey{NewSwitchingKeyLvl(keygen.params, levelQ), NewSwitchingKeyLvl(keygen.params, levelQ)}
+	keygen.swkToFastSwk(swk.CopyNew(), swkOut)
 	return
 }
@@ -0,0 +1,139 @@
+package frlwe
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"fast-ksw/rlwe"
+	"fast-ksw/utils"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// ParallelKeyGenerator generates sets of rotation keys over several goroutines.
+// Each key is sampled from its own PRNG, derived from a master seed and the Galois
+// element of the key, so that the generated keys depend neither on the number of
+// workers nor on the order in which they are scheduled.
+type ParallelKeyGenerator struct {
+	params  Parameters
+	seed    []byte
+	workers []*KeyGenerator
+}
+
+// NewParallelKeyGenerator creates a ParallelKeyGenerator running nbWorkers goroutines.
+// If seed is nil, a random master seed is sampled. The seed must be at most 64 bytes
+// long and be kept as secret as the keys it generates.
+func NewParallelKeyGenerator(params Parameters, seed []byte, nbWorkers int) (pkgen *ParallelKeyGenerator) {
+
+	if nbWorkers < 1 {
+		panic("cannot NewParallelKeyGenerator: nbWorkers must be positive")
+	}
+
+	if len(seed) > blake2b.Size {
+		panic("cannot NewParallelKeyGenerator: seed is longer than 64 bytes")
+	}
+
+	if seed == nil {
+		seed = make([]byte, 64)
+		if _, err := rand.Read(seed); err != nil {
+			panic("crypto rand error")
+		}
+	}
+
+	pkgen = new(ParallelKeyGenerator)
+	pkgen.params = params
+	pkgen.seed = append([]byte{}, seed...)
+	pkgen.workers = make([]*KeyGenerator, nbWorkers)
+
+	for i := range pkgen.workers {
+		pkgen.workers[i] = NewKeyGenerator(params)
+	}
+
+	return
+}
+
+// prngForGaloisElement returns the PRNG stream of the key for galEl.
+func (pkgen *ParallelKeyGenerator) prngForGaloisElement(galEl uint64) utils.PRNG {
+
+	var buff [8]byte
+	binary.BigEndian.PutUint64(buff[:], galEl)
+
+	hash, err := blake2b.New512(pkgen.seed)
+	if err != nil {
+		panic(err)
+	}
+	hash.Write(buff[:])
+
+	prng, err := utils.NewKeyedPRNG(hash.Sum(nil))
+	if err != nil {
+		panic(err)
+	}
+
+	return prng
+}
+
+// genRotKey generates the key for rotidx with the worker kgen, reseeded with the PRNG of its Galois element.
+func (pkgen *ParallelKeyGenerator) genRotKey(kgen *KeyGenerator, levelQ, rotidx int, sk *rlwe.SecretKey) *RotationKey {
+	kgen.Reseed(pkgen.prngForGaloisElement(pkgen.params.GaloisElementForColumnRotationBy(rotidx)))
+	return kgen.GenRotKeyLvl(levelQ, rotidx, sk)
+}
+
+// genRotKeys generates the keys for rots, spreading them over the workers.
+func (pkgen *ParallelKeyGenerator) genRotKeys(levelQ int, rots []int, sk *rlwe.SecretKey) (rtks []*RotationKey) {
+
+	rtks = make([]*RotationKey, len(rots))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for _, kgen := range pkgen.workers {
+		wg.Add(1)
+		go func(kgen *KeyGenerator) {
+			defer wg.Done()
+			for i := range jobs {
+				rtks[i] = pkgen.genRotKey(kgen, levelQ, rots[i], sk)
+			}
+		}(kgen)
+	}
+
+	for i := range rots {
+		jobs <- i
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	return
+}
+
+// GenRotKeys generates the rotation keys for all rotations in rots, for ciphertexts of level at most levelQ.
+func (pkgen *ParallelKeyGenerator) GenRotKeys(levelQ int, rots []int, sk *rlwe.SecretKey) (rtks *RotationKeySet) {
+
+	rtks = NewRotationKeySet()
+	for _, rtk := range pkgen.genRotKeys(levelQ, rots, sk) {
+		rtks.Add(rtk)
+	}
+
+	return
+}
+
+// WriteRotKeys generates the rotation keys for all rotations in rots, for ciphertexts of level at most levelQ,
+// and writes them on w with WriteRotationKey in the order of rots. At most one key per worker is held in
+// memory at any time. It returns the number of written bytes.
+func (pkgen *ParallelKeyGenerator) WriteRotKeys(levelQ int, rots []int, sk *rlwe.SecretKey, w io.Writer) (n int64, err error) {
+
+	batch := len(pkgen.workers)
+
+	var inc int64
+	for start := 0; start < len(rots); start += batch {
+		for _, rtk := range pkgen.genRotKeys(levelQ, rots[start:utils.MinInt(start+batch, len(rots))], sk) {
+			if inc, err = WriteRotationKey(w, rtk); err != nil {
+				return n + inc, err
+			}
+			n += inc
+		}
+	}
+
+	return
+}
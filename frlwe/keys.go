@@ -2,11 +2,13 @@ package frlwe
 
 import (
 	"fast-ksw/ring"
+	"fast-ksw/rlwe"
 	"math"
 )
 
 type SwitchingKey struct {
-	Value [][]*ring.Poly
+	Value  [][]*ring.Poly
+	levelQ int
 }
 
 type RelinKey struct {
@@ -19,15 +21,25 @@ type RotationKey struct {
 }
 
 func NewSwitchingKey(params Parameters) *SwitchingKey {
+	return NewSwitchingKeyLvl(params, params.MaxLevel())
+}
+
+// NewSwitchingKeyLvl allocates a SwitchingKey that only holds the gadget rows
+// and R_i blocks needed to switch ciphertexts of level at most levelQ.
+func NewSwitchingKeyLvl(params Parameters, levelQ int) *SwitchingKey {
+
+	if levelQ < 0 || levelQ > params.MaxLevel() {
+		panic("cannot NewSwitchingKeyLvl: levelQ out of range")
+	}
 
-	level := params.MaxLevel()
-	beta := params.Beta()
 	alpha := params.Alpha()
 	gamma := params.Gamma()
 
-	blockLen := int(math.Ceil(float64(level+alpha+1) / float64(gamma)))
+	beta := int(math.Ceil(float64(levelQ+1) / float64(alpha)))
+	blockLen := int(math.Ceil(float64(levelQ+alpha+1) / float64(gamma)))
 
 	swk := new(SwitchingKey)
+	swk.levelQ = levelQ
 	swk.Value = make([][]*ring.Poly, beta)
 
 	for i := 0; i < beta; i++ {
@@ -41,21 +53,165 @@ func NewSwitchingKey(params Parameters) *SwitchingKey {
 	return swk
 }
 
+// LevelQ returns the maximum level of the ciphertexts the key can switch.
+func (swk *SwitchingKey) LevelQ() int {
+	return swk.levelQ
+}
+
 func NewRelinKey(params Parameters) *RelinKey {
+	return NewRelinKeyLvl(params, params.MaxLevel())
+}
+
+func NewRelinKeyLvl(params Parameters, levelQ int) *RelinKey {
 
 	rlk := new(RelinKey)
-	rlk.Value[0] = NewSwitchingKey(params)
-	rlk.Value[1] = NewSwitchingKey(params)
+	rlk.Value[0] = NewSwitchingKeyLvl(params, levelQ)
+	rlk.Value[1] = NewSwitchingKeyLvl(params, levelQ)
 
 	return rlk
 }
 
+// LevelQ returns the maximum level of the ciphertexts the key can relinearize.
+func (rlk *RelinKey) LevelQ() int {
+	return rlk.Value[0].LevelQ()
+}
+
 func NewRotationKey(params Parameters, rotidx uint64) *RotationKey {
+	return NewRotationKeyLvl(params, params.MaxLevel(), rotidx)
+}
+
+func NewRotationKeyLvl(params Parameters, levelQ int, rotidx uint64) *RotationKey {
 
 	rtk := new(RotationKey)
-	rtk.Value[0] = NewSwitchingKey(params)
-	rtk.Value[1] = NewSwitchingKey(params)
+	rtk.Value[0] = NewSwitchingKeyLvl(params, levelQ)
+	rtk.Value[1] = NewSwitchingKeyLvl(params, levelQ)
 	rtk.Rotidx = rotidx
 
 	return rtk
 }
+
+// LevelQ returns the maximum level of the ciphertexts the key can rotate.
+func (rtk *RotationKey) LevelQ() int {
+	return rtk.Value[0].LevelQ()
+}
+
+// ConjugationKey is the key of the row rotation, which conjugates the slots in the standard ring.
+type ConjugationKey struct {
+	Value [2]*SwitchingKey
+}
+
+func NewConjugationKey(params Parameters) *ConjugationKey {
+	return NewConjugationKeyLvl(params, params.MaxLevel())
+}
+
+func NewConjugationKeyLvl(params Parameters, levelQ int) *ConjugationKey {
+
+	cjk := new(ConjugationKey)
+	cjk.Value[0] = NewSwitchingKeyLvl(params, levelQ)
+	cjk.Value[1] = NewSwitchingKeyLvl(params, levelQ)
+
+	return cjk
+}
+
+// LevelQ returns the maximum level of the ciphertexts the key can conjugate.
+func (cjk *ConjugationKey) LevelQ() int {
+	return cjk.Value[0].LevelQ()
+}
+
+// EncapsulationKey holds the keys switching ciphertexts between a dense secret and an ephemeral sparse secret,
+// so that the bootstrapping can refresh ciphertexts of the dense secret with the small integer part of the
+// sparse one. DenseToSparse switches ciphertexts of level 0, and SparseToDense ciphertexts of any level.
+type EncapsulationKey struct {
+	DenseToSparse [2]*SwitchingKey
+	SparseToDense [2]*SwitchingKey
+}
+
+func NewEncapsulationKey(params Parameters) *EncapsulationKey {
+
+	ek := new(EncapsulationKey)
+	ek.DenseToSparse[0] = NewSwitchingKeyLvl(params, 0)
+	ek.DenseToSparse[1] = NewSwitchingKeyLvl(params, 0)
+	ek.SparseToDense[0] = NewSwitchingKey(params)
+	ek.SparseToDense[1] = NewSwitchingKey(params)
+
+	return ek
+}
+
+// RotationKeySet is a set of RotationKeys indexed by their rotation.
+type RotationKeySet struct {
+	Keys map[uint64]*RotationKey
+}
+
+func NewRotationKeySet() *RotationKeySet {
+	return &RotationKeySet{Keys: make(map[uint64]*RotationKey)}
+}
+
+// Add inserts rtk in the set, replacing any key for the same rotation.
+func (rtks *RotationKeySet) Add(rtk *RotationKey) {
+	rtks.Keys[rtk.Rotidx] = rtk
+}
+
+// GetRotationKey returns the key for the rotation by rotidx positions and whether it is in the set.
+func (rtks *RotationKeySet) GetRotationKey(rotidx uint64) (rtk *RotationKey, ok bool) {
+	rtk, ok = rtks.Keys[rotidx]
+	return
+}
+
+// EvaluationKey is the set of keys an evaluator uses for relinearization, rotations, conjugation and the
+// switches to and from an ephemeral sparse secret. Any of its fields can be left nil if the corresponding
+// operations are not needed.
+type EvaluationKey struct {
+	Rlk  *RelinKey
+	Rtks *RotationKeySet
+	Cjk  *ConjugationKey
+	Ek   *EncapsulationKey
+}
+
+// EvaluationKeySet indexes the keys of an EvaluationKey by Galois element, so that they can be
+// used by an evaluator with a KeySwitcher backend. It implements rlwe.EvaluationKeySet.
+type EvaluationKeySet struct {
+	rlk        *RelinKey
+	galoisKeys map[uint64]rlwe.KeySwitchingKey
+}
+
+// NewEvaluationKeySet creates an EvaluationKeySet from the keys in evk at the time of the call.
+func NewEvaluationKeySet(params Parameters, evk EvaluationKey) *EvaluationKeySet {
+
+	evks := &EvaluationKeySet{rlk: evk.Rlk, galoisKeys: make(map[uint64]rlwe.KeySwitchingKey)}
+
+	if evk.Rtks != nil {
+		for _, rtk := range evk.Rtks.Keys {
+			evks.galoisKeys[params.GaloisElementForColumnRotationBy(int(rtk.Rotidx))] = rtk
+		}
+	}
+
+	if evk.Cjk != nil {
+		evks.galoisKeys[params.GaloisElementForRowRotation()] = evk.Cjk
+	}
+
+	return evks
+}
+
+// GetRelinearizationKey returns the RelinKey of the set and whether it is available.
+func (evks *EvaluationKeySet) GetRelinearizationKey() (rlwe.KeySwitchingKey, bool) {
+	if evks.rlk == nil {
+		return nil, false
+	}
+	return evks.rlk, true
+}
+
+// GetGaloisKey returns the RotationKey or the ConjugationKey of the automorphism defined by galEl
+// and whether it is available.
+func (evks *EvaluationKeySet) GetGaloisKey(galEl uint64) (swk rlwe.KeySwitchingKey, ok bool) {
+	swk, ok = evks.galoisKeys[galEl]
+	return
+}
+
+// GaloisElements returns the Galois elements of the RotationKeys and of the ConjugationKey of the set.
+func (evks *EvaluationKeySet) GaloisElements() (galEls []uint64) {
+	galEls = make([]uint64, 0, len(evks.galoisKeys))
+	for galEl := range evks.galoisKeys {
+		galEls = append(galEls, galEl)
+	}
+	return
+}
@@ -16,10 +16,14 @@ type KeySwitcher struct {
 	polyTPools1 []*ring.Poly
 	polyTPools2 []*ring.Poly
 
-	polyQPPool rlwe.PolyQP
-	polyQPool  *ring.Poly
-	polyTPool  *ring.Poly
-	polyRPool  *ring.Poly
+	polyQPPool      rlwe.PolyQP
+	polyQPool       *ring.Poly
+	polyQPoolInvNTT *ring.Poly
+	polyTPool       *ring.Poly
+	polyRPool       *ring.Poly
+
+	// P mod each modulus of Q, in Montgomery form
+	pModQ []uint64
 
 	convQP  *ring.BasisExtender
 	convTRi []*ring.BasisExtender
@@ -42,6 +46,7 @@ func NewKeySwitcher(params Parameters) *KeySwitcher {
 
 	ksw.polyQPPool = params.RingQP().NewPoly()
 	ksw.polyQPool = params.RingQ().NewPoly()
+	ksw.polyQPoolInvNTT = params.RingQ().NewPoly()
 	ksw.polyRPool = params.RingR().NewPoly()
 	ksw.polyTPool = params.RingT().NewPoly()
 
@@ -53,6 +58,12 @@ func NewKeySwitcher(params Parameters) *KeySwitcher {
 
 	ksw.convQP = ring.NewBasisExtender(ringQ, ringP)
 
+	ksw.pModQ = make([]uint64, len(ringQ.Modulus))
+	for i, qi := range ringQ.Modulus {
+		pModQi := new(big.Int).Mod(ringP.ModulusBigint, ring.NewUint(qi)).Uint64()
+		ksw.pModQ[i] = ring.MForm(pModQi, qi, ringQ.BredParams[i])
+	}
+
 	// generate ringRi convTRi
 	ksw.ringRi = make([]*ring.Ring, blockLen)
 	ksw.convTRi = make([]*ring.BasisExtender, blockLen)
@@ -65,7 +76,7 @@ func NewKeySwitcher(params Parameters) *KeySwitcher {
 			}
 		}
 
-		ksw.ringRi[i], _ = ring.NewRing(params.N(), modulusRi)
+		ksw.ringRi[i], _ = ring.NewRingFromType(params.N(), modulusRi, params.RingType())
 		ksw.convTRi[i] = ring.NewBasisExtender(params.RingT(), ksw.ringRi[i])
 	}
 
@@ -81,7 +92,7 @@ func NewKeySwitcher(params Parameters) *KeySwitcher {
 			}
 		}
 
-		ksw.ringQj[j], _ = ring.NewRing(params.N(), modulusQj)
+		ksw.ringQj[j], _ = ring.NewRingFromType(params.N(), modulusQj, params.RingType())
 		ksw.convQjT[j] = ring.NewBasisExtender(ksw.ringQj[j], params.RingT())
 	}
 
@@ -109,9 +120,19 @@ func NewKeySwitcher(params Parameters) *KeySwitcher {
 	return ksw
 }
 
-//assume input a and output c is in InvNTT form
+// assume input a and output c is in InvNTT form
 func (ksw *KeySwitcher) externalProduct(levelQ int, aPolyTs []*ring.Poly, bg *SwitchingKey, c *ring.Poly) {
 
+	ksw.externalProductNoModDown(levelQ, aPolyTs, bg, ksw.polyQPPool)
+
+	//Div by P
+	ksw.convQP.ModDownQPtoQ(levelQ, ksw.params.Alpha()-1, ksw.polyQPPool.Q, ksw.polyQPPool.P, c)
+}
+
+// externalProductNoModDown computes P times the external product of aPolyTs and bg mod QP on cQP,
+// out of the NTT domain.
+func (ksw *KeySwitcher) externalProductNoModDown(levelQ int, aPolyTs []*ring.Poly, bg *SwitchingKey, cQP rlwe.PolyQP) {
+
 	params := ksw.params
 	ringQP := params.RingQP()
 	ringT := params.RingT()
@@ -170,31 +191,100 @@ func (ksw *KeySwitcher) externalProduct(levelQ int, aPolyTs []*ring.Poly, bg *Sw
 
 		for j := 0; j < levelRi+1; j++ {
 			if i*gamma+j < alpha {
-				copy(ksw.polyQPPool.P.Coeffs[i*gamma+j], ksw.polyRPool.Coeffs[j])
+				copy(cQP.P.Coeffs[i*gamma+j], ksw.polyRPool.Coeffs[j])
 			} else {
-				copy(ksw.polyQPPool.Q.Coeffs[i*gamma+j-alpha], ksw.polyRPool.Coeffs[j])
+				copy(cQP.Q.Coeffs[i*gamma+j-alpha], ksw.polyRPool.Coeffs[j])
 			}
 		}
 	}
 
-	ringQP.SubLvl(levelQ, levelP, ksw.polyQPPool, ksw.halfTPolyQP, ksw.polyQPPool)
+	ringQP.SubLvl(levelQ, levelP, cQP, ksw.halfTPolyQP, cQP)
+}
 
-	//Div by P
-	ksw.convQP.ModDownQPtoQ(levelQ, levelP, ksw.polyQPPool.Q, ksw.polyQPPool.P, c)
+func (ksw *KeySwitcher) SwitchKey(levelQ int, a *ring.Poly, bg0, bg1 *SwitchingKey, c0, c1 *ring.Poly) {
+
+	if a.IsNTT {
+		panic("a should not be in NTT")
+	}
+
+	if bg0.LevelQ() < levelQ || bg1.LevelQ() < levelQ {
+		panic("cannot SwitchKey: switching key level is smaller than levelQ")
+	}
+
+	ksw.decompose(levelQ, a, ksw.polyTPools1)
+
+	ksw.externalProduct(levelQ, ksw.polyTPools1, bg0, c0)
+	ksw.externalProduct(levelQ, ksw.polyTPools1, bg1, c1)
 
 	return
 }
 
-func (ksw *KeySwitcher) SwitchKey(levelQ int, a *ring.Poly, bg0, bg1 *SwitchingKey, c0, c1 *ring.Poly) {
-
-	params := ksw.params
-	ringT := params.RingT()
-	levelT := len(ringT.Modulus) - 1
+// SwitchKeyAndRescale switches the key of a with swk, adds d0 and d1 to the two resulting polynomials
+// and divides them by the product q of the nbRescales last moduli of levelQ. The division by P of the
+// key switch and the division by q are done with a single ModDown, so that c0 and c1 are the rounding of
+// (P*d + KeySwitch(a))/(P*q) instead of the rounding of a rounding.
+// a must be out of the NTT domain, d0 and d1 at level levelQ and in the NTT domain. c0 and c1 are
+// returned at level levelQ-nbRescales in the NTT domain, and can be d0 and d1.
+func (ksw *KeySwitcher) SwitchKeyAndRescale(levelQ, nbRescales int, a *ring.Poly, swk rlwe.KeySwitchingKey, d0, d1, c0, c1 *ring.Poly) {
 
 	if a.IsNTT {
 		panic("a should not be in NTT")
 	}
 
+	if nbRescales < 1 || nbRescales > levelQ {
+		panic("cannot SwitchKeyAndRescale: nbRescales must be between 1 and levelQ")
+	}
+
+	bg := switchingKeys(swk)
+
+	if bg[0].LevelQ() < levelQ || bg[1].LevelQ() < levelQ {
+		panic("cannot SwitchKeyAndRescale: switching key level is smaller than levelQ")
+	}
+
+	// (q^-1 mod qi) in Montgomery form
+	qInvModQ := ksw.convQP.RescaleInvModQ(levelQ, nbRescales, ksw.params.Alpha()-1)
+
+	ksw.decompose(levelQ, a, ksw.polyTPools1)
+
+	ksw.externalProductRescale(levelQ, nbRescales, ksw.polyTPools1, bg[0], qInvModQ, d0, c0)
+	ksw.externalProductRescale(levelQ, nbRescales, ksw.polyTPools1, bg[1], qInvModQ, d1, c1)
+}
+
+// externalProductRescale computes the rounding of (P*d + P*<aPolyTs, bg>)/(P*q) on c, see SwitchKeyAndRescale.
+func (ksw *KeySwitcher) externalProductRescale(levelQ, nbRescales int, aPolyTs []*ring.Poly, bg *SwitchingKey, qInvModQ []uint64, d, c *ring.Poly) {
+
+	ringQ := ksw.params.RingQ()
+	levelOut := levelQ - nbRescales
+	cQ := ksw.polyQPPool.Q
+
+	ksw.externalProductNoModDown(levelQ, aPolyTs, bg, ksw.polyQPPool)
+
+	// Adds P*d on the moduli divided by, where the sum is needed out of the NTT domain
+	for i := levelOut + 1; i < levelQ+1; i++ {
+		ringQ.InvNTTSingle(i, d.Coeffs[i], ksw.polyQPoolInvNTT.Coeffs[i])
+		ring.MulScalarMontgomeryAndAddVec(ksw.polyQPoolInvNTT.Coeffs[i], cQ.Coeffs[i], ksw.pModQ[i], ringQ.Modulus[i], ringQ.MredParams[i])
+	}
+
+	ksw.convQP.ModDownQPtoQRescale(levelQ, nbRescales, ksw.params.Alpha()-1, cQ, ksw.polyQPPool.P, cQ)
+
+	ringQ.NTTLvl(levelOut, cQ, cQ)
+
+	// Adds P*d/(P*q) = d/q on the remaining moduli, in the NTT domain
+	for i := 0; i < levelOut+1; i++ {
+		ring.MulScalarMontgomeryAndAddVec(d.Coeffs[i], cQ.Coeffs[i], qInvModQ[i], ringQ.Modulus[i], ringQ.MredParams[i])
+	}
+
+	ring.CopyValuesLvl(levelOut, cQ, c)
+}
+
+// decompose computes the gadget decomposition of a, given out of the NTT domain, and writes
+// it in the NTT domain of T on aPolyTs.
+func (ksw *KeySwitcher) decompose(levelQ int, a *ring.Poly, aPolyTs []*ring.Poly) {
+
+	params := ksw.params
+	ringT := params.RingT()
+	levelT := len(ringT.Modulus) - 1
+
 	alpha := params.Alpha()
 	beta := int(math.Ceil(float64(levelQ+1) / float64(alpha)))
 
@@ -209,16 +299,143 @@ func (ksw *KeySwitcher) SwitchKey(levelQ int, a *ring.Poly, bg0, bg1 *SwitchingK
 
 		if alpha == 1 {
 			for i := 0; i < levelT+1; i++ {
-				copy(ksw.polyTPools1[j].Coeffs[i], ksw.polyQPool.Coeffs[0])
+				copy(aPolyTs[j].Coeffs[i], ksw.polyQPool.Coeffs[0])
 			}
 		} else {
-			ksw.convQjT[j].ModUpQtoP(levelQj, levelT, ksw.polyQPool, ksw.polyTPools1[j])
+			ksw.convQjT[j].ModUpQtoP(levelQj, levelT, ksw.polyQPool, aPolyTs[j])
 		}
-		ringT.NTTLazy(ksw.polyTPools1[j], ksw.polyTPools1[j])
+		ringT.NTTLazy(aPolyTs[j], aPolyTs[j])
 	}
+}
 
-	ksw.externalProduct(levelQ, ksw.polyTPools1, bg0, c0)
-	ksw.externalProduct(levelQ, ksw.polyTPools1, bg1, c1)
+// switchingKeys returns the pair of SwitchingKey of a RelinKey, a RotationKey or a ConjugationKey.
+func switchingKeys(swk rlwe.KeySwitchingKey) [2]*SwitchingKey {
+	switch swk := swk.(type) {
+	case *RelinKey:
+		return swk.Value
+	case *RotationKey:
+		return swk.Value
+	case *ConjugationKey:
+		return swk.Value
+	}
+	panic("cannot switch keys: key is not a frlwe RelinKey, RotationKey or ConjugationKey")
+}
 
-	return
+// invNTTIfNTT returns cx out of the NTT domain, using polyQPoolInvNTT if needed.
+func (ksw *KeySwitcher) invNTTIfNTT(levelQ int, cx *ring.Poly) *ring.Poly {
+	if !cx.IsNTT {
+		return cx
+	}
+	ksw.params.RingQ().InvNTTLvl(levelQ, cx, ksw.polyQPoolInvNTT)
+	ksw.polyQPoolInvNTT.IsNTT = false
+	return ksw.polyQPoolInvNTT
+}
+
+// Switch implements rlwe.KeySwitchingBackend with SwitchKey.
+// The result is returned in the same domain as cx.
+func (ksw *KeySwitcher) Switch(levelQ int, cx *ring.Poly, swk rlwe.KeySwitchingKey, c0, c1 *ring.Poly) {
+
+	bg := switchingKeys(swk)
+
+	isNTT := cx.IsNTT
+
+	ksw.SwitchKey(levelQ, ksw.invNTTIfNTT(levelQ, cx), bg[0], bg[1], c0, c1)
+
+	if isNTT {
+		ringQ := ksw.params.RingQ()
+		ringQ.NTTLvl(levelQ, c0, c0)
+		ringQ.NTTLvl(levelQ, c1, c1)
+	}
+}
+
+// SwitchNoModDown implements rlwe.KeySwitchingBackend.
+// The result is returned in the NTT domain.
+func (ksw *KeySwitcher) SwitchNoModDown(levelQ int, cx *ring.Poly, swk rlwe.KeySwitchingKey, c0Q, c0P, c1Q, c1P *ring.Poly) {
+
+	bg := switchingKeys(swk)
+
+	if bg[0].LevelQ() < levelQ || bg[1].LevelQ() < levelQ {
+		panic("cannot SwitchNoModDown: switching key level is smaller than levelQ")
+	}
+
+	ksw.decompose(levelQ, ksw.invNTTIfNTT(levelQ, cx), ksw.polyTPools1)
+
+	ksw.externalProductNTTNoModDown(levelQ, ksw.polyTPools1, bg[0], rlwe.PolyQP{Q: c0Q, P: c0P})
+	ksw.externalProductNTTNoModDown(levelQ, ksw.polyTPools1, bg[1], rlwe.PolyQP{Q: c1Q, P: c1P})
+}
+
+// NewDecomposition allocates a Decomposition holding Beta() polynomials mod T.
+func (ksw *KeySwitcher) NewDecomposition() *rlwe.Decomposition {
+	ringT := ksw.params.RingT()
+	decomp := &rlwe.Decomposition{T: make([]*ring.Poly, ksw.params.Beta())}
+	for i := range decomp.T {
+		decomp.T[i] = ringT.NewPoly()
+	}
+	return decomp
+}
+
+// Decompose implements rlwe.KeySwitchingBackend. The decomposition is computed in the NTT domain of T.
+func (ksw *KeySwitcher) Decompose(levelQ int, cx *ring.Poly, decomp *rlwe.Decomposition) {
+	ksw.decompose(levelQ, ksw.invNTTIfNTT(levelQ, cx), decomp.T)
+}
+
+// SwitchHoisted implements rlwe.KeySwitchingBackend. c0P and c1P are not used.
+func (ksw *KeySwitcher) SwitchHoisted(levelQ int, decomp *rlwe.Decomposition, swk rlwe.KeySwitchingKey, c0Q, c1Q, c0P, c1P *ring.Poly) {
+
+	bg := switchingKeys(swk)
+
+	if bg[0].LevelQ() < levelQ || bg[1].LevelQ() < levelQ {
+		panic("cannot SwitchHoisted: switching key level is smaller than levelQ")
+	}
+
+	ringQ := ksw.params.RingQ()
+
+	ksw.externalProduct(levelQ, decomp.T, bg[0], c0Q)
+	ksw.externalProduct(levelQ, decomp.T, bg[1], c1Q)
+
+	ringQ.NTTLvl(levelQ, c0Q, c0Q)
+	ringQ.NTTLvl(levelQ, c1Q, c1Q)
+}
+
+// SwitchHoistedNoModDown implements rlwe.KeySwitchingBackend.
+func (ksw *KeySwitcher) SwitchHoistedNoModDown(levelQ int, decomp *rlwe.Decomposition, swk rlwe.KeySwitchingKey, c0Q, c1Q, c0P, c1P *ring.Poly) {
+
+	bg := switchingKeys(swk)
+
+	if bg[0].LevelQ() < levelQ || bg[1].LevelQ() < levelQ {
+		panic("cannot SwitchHoistedNoModDown: switching key level is smaller than levelQ")
+	}
+
+	ksw.externalProductNTTNoModDown(levelQ, decomp.T, bg[0], rlwe.PolyQP{Q: c0Q, P: c0P})
+	ksw.externalProductNTTNoModDown(levelQ, decomp.T, bg[1], rlwe.PolyQP{Q: c1Q, P: c1P})
+}
+
+// SwitchHoistedPermuteAndMulAddNoModDown adds diag * phi(P*swk(a)) mod QP on [c0, c1], where a is the
+// polynomial decomposed in decomp and phi is the automorphism given by its NTT permutation index.
+// The key-switched polynomials are multiplied by diag right after their basis extension from T to QP,
+// so that a linear transform can sum all its rotations before dividing by P once.
+// diag must be in the NTT and Montgomery domain, and c0 and c1 are in the NTT domain.
+func (ksw *KeySwitcher) SwitchHoistedPermuteAndMulAddNoModDown(levelQ int, decomp *rlwe.Decomposition, swk rlwe.KeySwitchingKey, index []uint64, diag rlwe.PolyQP, c0, c1 rlwe.PolyQP) {
+
+	bg := switchingKeys(swk)
+
+	if bg[0].LevelQ() < levelQ || bg[1].LevelQ() < levelQ {
+		panic("cannot SwitchHoistedPermuteAndMulAddNoModDown: switching key level is smaller than levelQ")
+	}
+
+	ringQ := ksw.params.RingQ()
+	ringP := ksw.params.RingP()
+	levelP := ksw.params.Alpha() - 1
+
+	for i, c := range [2]rlwe.PolyQP{c0, c1} {
+		ksw.externalProductNTTNoModDown(levelQ, decomp.T, bg[i], ksw.polyQPPool)
+		ringQ.PermuteNTTWithIndexAndMulCoeffsMontgomeryAndAddLvl(levelQ, ksw.polyQPPool.Q, index, diag.Q, c.Q)
+		ringP.PermuteNTTWithIndexAndMulCoeffsMontgomeryAndAddLvl(levelP, ksw.polyQPPool.P, index, diag.P, c.P)
+	}
+}
+
+func (ksw *KeySwitcher) externalProductNTTNoModDown(levelQ int, aPolyTs []*ring.Poly, bg *SwitchingKey, cQP rlwe.PolyQP) {
+	ksw.externalProductNoModDown(levelQ, aPolyTs, bg, cQP)
+	ksw.params.RingQ().NTTLvl(levelQ, cQP.Q, cQP.Q)
+	ksw.params.RingP().NTTLvl(ksw.params.Alpha()-1, cQP.P, cQP.P)
 }
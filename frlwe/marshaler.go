@@ -0,0 +1,241 @@
+package frlwe
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"fast-ksw/ring"
+)
+
+// GetDataLen returns the length in bytes of the target SwitchingKey.
+func (swk *SwitchingKey) GetDataLen(WithMetadata bool) (dataLen int) {
+
+	// MetaData is :
+	// 1 byte : levelQ
+	// 1 byte : beta
+	// 1 byte : blockLen
+	if WithMetadata {
+		dataLen += 3
+	}
+
+	for i := range swk.Value {
+		for j := range swk.Value[i] {
+			dataLen += swk.Value[i][j].GetDataLen(WithMetadata)
+		}
+	}
+
+	return
+}
+
+// MarshalBinary encodes a SwitchingKey in a byte slice.
+func (swk *SwitchingKey) MarshalBinary() (data []byte, err error) {
+
+	data = make([]byte, swk.GetDataLen(true))
+
+	if _, err = swk.encode(0, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// UnmarshalBinary decodes a previously marshaled SwitchingKey in the target SwitchingKey.
+func (swk *SwitchingKey) UnmarshalBinary(data []byte) (err error) {
+
+	if _, err = swk.decode(data); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (swk *SwitchingKey) encode(pointer int, data []byte) (int, error) {
+
+	var err error
+	var inc int
+
+	data[pointer] = uint8(swk.levelQ)
+	data[pointer+1] = uint8(len(swk.Value))
+	data[pointer+2] = uint8(len(swk.Value[0]))
+
+	pointer += 3
+
+	for i := range swk.Value {
+		for j := range swk.Value[i] {
+			if inc, err = swk.Value[i][j].WriteTo(data[pointer : pointer+swk.Value[i][j].GetDataLen(true)]); err != nil {
+				return pointer, err
+			}
+
+			pointer += inc
+		}
+	}
+
+	return pointer, nil
+}
+
+func (swk *SwitchingKey) decode(data []byte) (pointer int, err error) {
+
+	if len(data) < 3 {
+		return 0, errors.New("too small bytearray")
+	}
+
+	swk.levelQ = int(data[0])
+	beta := int(data[1])
+	blockLen := int(data[2])
+
+	pointer = 3
+
+	swk.Value = make([][]*ring.Poly, beta)
+
+	var inc int
+
+	for i := range swk.Value {
+		swk.Value[i] = make([]*ring.Poly, blockLen)
+		for j := range swk.Value[i] {
+			swk.Value[i][j] = new(ring.Poly)
+			if inc, err = swk.Value[i][j].DecodePolyNew(data[pointer:]); err != nil {
+				return
+			}
+			pointer += inc
+		}
+	}
+
+	return
+}
+
+// GetDataLen returns the length in bytes of the target RelinKey.
+func (rlk *RelinKey) GetDataLen(WithMetadata bool) (dataLen int) {
+	return rlk.Value[0].GetDataLen(WithMetadata) + rlk.Value[1].GetDataLen(WithMetadata)
+}
+
+// MarshalBinary encodes a RelinKey in a byte slice.
+func (rlk *RelinKey) MarshalBinary() (data []byte, err error) {
+
+	data = make([]byte, rlk.GetDataLen(true))
+
+	var pointer int
+	if pointer, err = rlk.Value[0].encode(0, data); err != nil {
+		return nil, err
+	}
+
+	if _, err = rlk.Value[1].encode(pointer, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// UnmarshalBinary decodes a previously marshaled RelinKey in the target RelinKey.
+func (rlk *RelinKey) UnmarshalBinary(data []byte) (err error) {
+
+	rlk.Value[0], rlk.Value[1] = new(SwitchingKey), new(SwitchingKey)
+
+	var pointer int
+	if pointer, err = rlk.Value[0].decode(data); err != nil {
+		return err
+	}
+
+	_, err = rlk.Value[1].decode(data[pointer:])
+
+	return
+}
+
+// GetDataLen returns the length in bytes of the target RotationKey.
+func (rtk *RotationKey) GetDataLen(WithMetadata bool) (dataLen int) {
+
+	// MetaData is :
+	// 8 byte : Rotidx
+	if WithMetadata {
+		dataLen += 8
+	}
+
+	return dataLen + rtk.Value[0].GetDataLen(WithMetadata) + rtk.Value[1].GetDataLen(WithMetadata)
+}
+
+// MarshalBinary encodes a RotationKey in a byte slice.
+func (rtk *RotationKey) MarshalBinary() (data []byte, err error) {
+
+	data = make([]byte, rtk.GetDataLen(true))
+
+	binary.BigEndian.PutUint64(data[:8], rtk.Rotidx)
+
+	var pointer int
+	if pointer, err = rtk.Value[0].encode(8, data); err != nil {
+		return nil, err
+	}
+
+	if _, err = rtk.Value[1].encode(pointer, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// UnmarshalBinary decodes a previously marshaled RotationKey in the target RotationKey.
+func (rtk *RotationKey) UnmarshalBinary(data []byte) (err error) {
+
+	if len(data) < 8 {
+		return errors.New("too small bytearray")
+	}
+
+	rtk.Rotidx = binary.BigEndian.Uint64(data[:8])
+	rtk.Value[0], rtk.Value[1] = new(SwitchingKey), new(SwitchingKey)
+
+	var inc int
+	pointer := 8
+	if inc, err = rtk.Value[0].decode(data[pointer:]); err != nil {
+		return err
+	}
+	pointer += inc
+
+	_, err = rtk.Value[1].decode(data[pointer:])
+
+	return
+}
+
+// WriteRotationKey writes rtk on w as an 8 byte length followed by its MarshalBinary encoding.
+// It returns the number of written bytes.
+func WriteRotationKey(w io.Writer, rtk *RotationKey) (n int64, err error) {
+
+	var data []byte
+	if data, err = rtk.MarshalBinary(); err != nil {
+		return 0, err
+	}
+
+	var header [8]byte
+	binary.BigEndian.PutUint64(header[:], uint64(len(data)))
+
+	var inc int
+	if inc, err = w.Write(header[:]); err != nil {
+		return int64(inc), err
+	}
+	n += int64(inc)
+
+	inc, err = w.Write(data)
+	n += int64(inc)
+
+	return
+}
+
+// ReadRotationKey reads the next RotationKey written by WriteRotationKey from r.
+// It returns io.EOF if r holds no further key.
+func ReadRotationKey(r io.Reader) (rtk *RotationKey, err error) {
+
+	var header [8]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, binary.BigEndian.Uint64(header[:]))
+	if _, err = io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	rtk = new(RotationKey)
+	if err = rtk.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+
+	return
+}
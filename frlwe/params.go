@@ -7,6 +7,18 @@ import (
 	"math"
 )
 
+// SecretDistribution selects the distribution sampled by KeyGenerator.GenSecretKey.
+type SecretDistribution int
+
+const (
+	// HammingWeight samples a ternary secret with exactly H non-zero coefficients.
+	HammingWeight SecretDistribution = iota
+	// Ternary samples each coefficient in {-1, 0, 1} with probabilities [(1-p)/2, p, (1-p)/2], p = SecretProba.
+	Ternary
+	// Gaussian samples the secret from the error distribution.
+	Gaussian
+)
+
 type ParametersLiteral struct {
 	LogN  int
 	Q     []uint64
@@ -17,6 +29,13 @@ type ParametersLiteral struct {
 	Sigma float64
 	H     int
 	Gamma int
+
+	// RingType selects Z[X]/(X^N+1) (ring.Standard) or Z[X+X^-1]/(X^2N+1) (ring.ConjugateInvariant).
+	// The T and R bases are instantiated with the same ring type as Q and P.
+	RingType ring.Type
+
+	SecretDistrib SecretDistribution
+	SecretProba   float64
 }
 
 type Parameters struct {
@@ -24,19 +43,22 @@ type Parameters struct {
 
 	gamma int
 
+	secretDistrib SecretDistribution
+	secretProba   float64
+
 	ringR *ring.Ring
 	ringT *ring.Ring
 }
 
 func NewParametersFromLiteral(pl ParametersLiteral) (params Parameters) {
-	rlweParams, err := rlwe.NewParametersFromLiteral(rlwe.ParametersLiteral{LogN: pl.LogN, Q: pl.Q, P: pl.P, LogQ: pl.LogQ, LogP: pl.LogP, H: pl.H, Sigma: pl.Sigma})
+	rlweParams, err := rlwe.NewParametersFromLiteral(rlwe.ParametersLiteral{LogN: pl.LogN, Q: pl.Q, P: pl.P, LogQ: pl.LogQ, LogP: pl.LogP, H: pl.H, Sigma: pl.Sigma, RingType: pl.RingType})
 
 	if err != nil {
 		panic("cannot NewParametersFromLiteral: rlweParams cannot be generated")
 	}
 
 	N := (1 << pl.LogN)
-	ringT, err := ring.NewRing(N, pl.T)
+	ringT, err := ring.NewRingFromType(N, pl.T, pl.RingType)
 	if err != nil {
 		panic("cannot NewParametersFromLiteral: ringT cannot be generated")
 	}
@@ -45,7 +67,7 @@ func NewParametersFromLiteral(pl ParametersLiteral) (params Parameters) {
 	moduliR = append(moduliR, pl.P...)
 	moduliR = append(moduliR, pl.Q...)
 
-	ringR, err := ring.NewRing(N, moduliR)
+	ringR, err := ring.NewRingFromType(N, moduliR, pl.RingType)
 	if err != nil {
 		panic("cannot NewParametersFromLiteral: ringR cannot be generated")
 	}
@@ -54,6 +76,12 @@ func NewParametersFromLiteral(pl ParametersLiteral) (params Parameters) {
 	params.ringT = ringT
 	params.ringR = ringR
 	params.gamma = pl.Gamma
+	params.secretDistrib = pl.SecretDistrib
+	params.secretProba = pl.SecretProba
+
+	if params.secretDistrib == Ternary && (params.secretProba < 0 || params.secretProba >= 1) {
+		panic("cannot NewParametersFromLiteral: SecretProba must be in [0, 1)")
+	}
 
 	return
 }
@@ -73,3 +101,21 @@ func (p Parameters) TiOverflowMargin(level int) int {
 func (p Parameters) Gamma() int {
 	return p.gamma
 }
+
+func (p Parameters) SecretDistribution() SecretDistribution {
+	return p.secretDistrib
+}
+
+func (p Parameters) SecretProba() float64 {
+	return p.secretProba
+}
+
+// RotationIndex returns the left rotation by k positions as an index in [0, n), where n is the
+// number of slots in a row: N/2 in the standard ring and N in the conjugate invariant ring.
+func (p Parameters) RotationIndex(k int) uint64 {
+	n := p.N() >> 1
+	if p.RingType() == ring.ConjugateInvariant {
+		n = p.N()
+	}
+	return uint64(((k % n) + n) % n)
+}
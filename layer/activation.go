@@ -0,0 +1,92 @@
+package layer
+
+import (
+	"errors"
+	"fmt"
+
+	"fast-ksw/approx"
+	"fast-ksw/ckks"
+	"fast-ksw/fckks"
+)
+
+// Activation is a layer applying a polynomial to each activation, evaluated with fckks.PolynomialEvaluator.
+// Forward consumes Polynomial.Depth() logical levels and needs no rotation.
+type Activation struct {
+	Polynomial *fckks.Polynomial
+
+	size   int
+	params fckks.Parameters
+	level  int
+}
+
+// NewActivation creates a new Activation applying pol to size activations.
+func NewActivation(size int, pol *fckks.Polynomial) *Activation {
+
+	if size < 1 {
+		panic("cannot NewActivation: the size must be positive")
+	}
+
+	return &Activation{Polynomial: pol, size: size, level: -1}
+}
+
+// NewActivationFunc creates a new Activation applying to size activations the Chebyshev interpolant of f of the
+// given degree on [a, b], computed with approx.ChebyshevReal. The activations must stay in [a, b].
+func NewActivationFunc(size int, f func(float64) float64, a, b float64, degree int) *Activation {
+	return NewActivation(size, approx.ChebyshevReal(f, a, b, degree))
+}
+
+// InputSize returns the number of input activations.
+func (act *Activation) InputSize() int {
+	return act.size
+}
+
+// OutputSize returns the number of output activations.
+func (act *Activation) OutputSize() int {
+	return act.size
+}
+
+// Depth returns the number of logical levels consumed by Forward.
+func (act *Activation) Depth() int {
+	return act.Polynomial.Depth()
+}
+
+// Rotations returns the rotations used by Forward, which uses none.
+func (act *Activation) Rotations(params fckks.Parameters) []int {
+	return nil
+}
+
+// Compile records the logical level of the inputs of Forward. The polynomial needs no plaintext.
+func (act *Activation) Compile(params fckks.Parameters, ecd ckks.Encoder, logicalLevel int) error {
+
+	if logicalLevel < act.Depth() || logicalLevel > params.MaxLogicalLevel() {
+		return fmt.Errorf("cannot Compile: the activation consumes %d logical levels but the input has %d", act.Depth(), logicalLevel)
+	}
+
+	act.params, act.level = params, logicalLevel
+
+	return nil
+}
+
+// Forward evaluates the polynomial on the slots of ctIn and returns the result in a newly created element,
+// Depth() logical levels below, with the scale of ctIn.
+func (act *Activation) Forward(eval *fckks.Evaluator, ctIn *ckks.Ciphertext) (ctOut *ckks.Ciphertext, err error) {
+
+	if act.level < 0 {
+		return nil, errors.New("cannot Forward: the layer is not compiled")
+	}
+
+	if ctIn, err = atLogicalLevel(eval, act.params, ctIn, act.level); err != nil {
+		return nil, err
+	}
+
+	return fckks.NewPolynomialEvaluator(eval).EvaluateNew(ctIn, act.Polynomial, ctIn.Scale)
+}
+
+// ForwardPlain evaluates the polynomial on the activations x in the clear.
+func (act *Activation) ForwardPlain(x []float64) (y []float64) {
+	y = make([]float64, len(x))
+	for i := range x {
+		y[i] = real(act.Polynomial.Evaluate(complex(x[i], 0)))
+	}
+	return
+}
@@ -0,0 +1,182 @@
+// Package layer implements the layers of neural networks evaluated on activations encrypted with fckks, for
+// private inference. The activations of a layer are packed in the first slots of a single ciphertext, and a
+// Sequential model plans the logical levels and the rotation keys of the whole network.
+package layer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"fast-ksw/ckks"
+	"fast-ksw/fckks"
+)
+
+// Layer is a layer of a network evaluated on a ciphertext whose first InputSize() slots hold the input
+// activations. The other slots of the input are ignored.
+type Layer interface {
+	// InputSize returns the number of input activations.
+	InputSize() int
+	// OutputSize returns the number of output activations.
+	OutputSize() int
+	// Depth returns the number of logical levels consumed by Forward.
+	Depth() int
+	// Rotations returns the rotations used by Forward. The keys are generated with frlwe.KeyGenerator.GenRotKeys.
+	Rotations(params fckks.Parameters) []int
+	// Compile encodes the plaintexts of the layer with ecd for the ciphertexts at the given logical level.
+	Compile(params fckks.Parameters, ecd ckks.Encoder, logicalLevel int) error
+	// Forward evaluates the layer on ctIn, at the logical level of Compile, and returns the result in a newly
+	// created element, Depth() logical levels below, with the scale of ctIn.
+	Forward(eval *fckks.Evaluator, ctIn *ckks.Ciphertext) (*ckks.Ciphertext, error)
+	// ForwardPlain evaluates the layer on the activations x in the clear.
+	ForwardPlain(x []float64) []float64
+}
+
+// Sequential is a network evaluating its layers one after the other.
+type Sequential struct {
+	Layers []Layer
+
+	params fckks.Parameters
+	levels []int
+}
+
+// NewSequential creates a new Sequential network of the given layers, the output size of each layer being the
+// input size of the next one.
+func NewSequential(layers ...Layer) *Sequential {
+
+	if len(layers) == 0 {
+		panic("cannot NewSequential: the network has no layer")
+	}
+
+	for i := 1; i < len(layers); i++ {
+		if layers[i-1].OutputSize() != layers[i].InputSize() {
+			panic(fmt.Sprintf("cannot NewSequential: layer %d outputs %d activations but layer %d takes %d",
+				i-1, layers[i-1].OutputSize(), i, layers[i].InputSize()))
+		}
+	}
+
+	return &Sequential{Layers: layers}
+}
+
+// InputSize returns the number of input activations of the network.
+func (s *Sequential) InputSize() int {
+	return s.Layers[0].InputSize()
+}
+
+// OutputSize returns the number of output activations of the network.
+func (s *Sequential) OutputSize() int {
+	return s.Layers[len(s.Layers)-1].OutputSize()
+}
+
+// Depth returns the number of logical levels consumed by Forward, the sum of the depths of the layers.
+func (s *Sequential) Depth() (depth int) {
+	for _, l := range s.Layers {
+		depth += l.Depth()
+	}
+	return
+}
+
+// Rotations returns the rotations used by the layers of the network, whose keys must be in the EvaluationKey
+// of the Evaluator of Forward, along with the relinearization key. The keys are generated with
+// frlwe.KeyGenerator.GenRotKeys.
+func (s *Sequential) Rotations(params fckks.Parameters) (rotations []int) {
+
+	rotIndex := make(map[int]bool)
+	for _, l := range s.Layers {
+		for _, k := range l.Rotations(params) {
+			rotIndex[k] = true
+		}
+	}
+
+	rotations = make([]int, 0, len(rotIndex))
+	for k := range rotIndex {
+		rotations = append(rotations, k)
+	}
+	sort.Ints(rotations)
+
+	return
+}
+
+// Compile plans the levels of the network for inputs at the given logical level, each layer starting at the
+// level its predecessor ends at, and compiles the layers at their level.
+// It returns an error if the network consumes more than logicalLevel levels.
+func (s *Sequential) Compile(params fckks.Parameters, logicalLevel int) (err error) {
+
+	if logicalLevel > params.MaxLogicalLevel() {
+		return errors.New("cannot Compile: the logical level exceeds MaxLogicalLevel")
+	}
+
+	if depth := s.Depth(); depth > logicalLevel {
+		return fmt.Errorf("cannot Compile: the network consumes %d logical levels but the input has %d", depth, logicalLevel)
+	}
+
+	ecd := ckks.NewEncoder(params.Parameters)
+
+	levels := make([]int, len(s.Layers))
+	for i, l := range s.Layers {
+		levels[i] = logicalLevel
+		if err = l.Compile(params, ecd, logicalLevel); err != nil {
+			return err
+		}
+		logicalLevel -= l.Depth()
+	}
+
+	s.params, s.levels = params, levels
+
+	return nil
+}
+
+// Levels returns the logical levels at which the layers are evaluated, planned by Compile.
+func (s *Sequential) Levels() []int {
+	return s.levels
+}
+
+// Forward evaluates the network on ctIn and returns the result in a newly created element, Depth() logical levels
+// below the level of Compile, with the scale of ctIn. ctIn is brought down to the level of Compile if it is above.
+// It returns an error if the network is not compiled, if ctIn is below the level of Compile or if a layer fails.
+func (s *Sequential) Forward(eval *fckks.Evaluator, ctIn *ckks.Ciphertext) (ctOut *ckks.Ciphertext, err error) {
+
+	if s.levels == nil {
+		return nil, errors.New("cannot Forward: the network is not compiled")
+	}
+
+	if ctOut, err = atLogicalLevel(eval, s.params, ctIn, s.levels[0]); err != nil {
+		return nil, err
+	}
+
+	for i, l := range s.Layers {
+		if ctOut, err = l.Forward(eval, ctOut); err != nil {
+			return nil, fmt.Errorf("layer %d: %w", i, err)
+		}
+	}
+
+	return ctOut, nil
+}
+
+// ForwardPlain evaluates the network on the activations x in the clear.
+func (s *Sequential) ForwardPlain(x []float64) []float64 {
+	for _, l := range s.Layers {
+		x = l.ForwardPlain(x)
+	}
+	return x
+}
+
+// atLogicalLevel returns ct, dropped to the given logical level in a newly created element if it is above.
+// It returns an error if ct is below the logical level.
+func atLogicalLevel(eval *fckks.Evaluator, params fckks.Parameters, ct *ckks.Ciphertext, logicalLevel int) (*ckks.Ciphertext, error) {
+
+	if ct.Degree() != 1 {
+		return nil, errors.New("cannot Forward: input Ciphertext must be of degree 1")
+	}
+
+	level := params.LogicalLevel(ct.Level())
+	if level < logicalLevel {
+		return nil, fmt.Errorf("cannot Forward: input Ciphertext is at logical level %d instead of %d", level, logicalLevel)
+	}
+
+	if level > logicalLevel {
+		return eval.DropLevelNew(ct, level-logicalLevel), nil
+	}
+
+	return ct, nil
+}
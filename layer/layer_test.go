@@ -0,0 +1,251 @@
+package layer
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"fast-ksw/fckks"
+	"fast-ksw/frlwe"
+	"fast-ksw/ring"
+	"fast-ksw/rlwe"
+	"fast-ksw/utils"
+)
+
+// pn12qp342 has 6 logical levels of 40 bits for a scale of 2^40. It copies the PN12QP342 parameters of the fckks
+// tests, which are not exported since they do not ensure 128 bit security.
+var pn12qp342 = fckks.ParametersLiteral{
+	LogN: 12,
+	Q: []uint64{
+		0x4000000120001, // 50
+
+		0x10000140001, 0xffffe80001, 0xffffc40001, // 40 x 6
+		0x100003e0001, 0xffffb20001, 0x10000500001,
+	},
+	P: []uint64{ // 61 x 1
+		0x1fffffffffe00001,
+	},
+	T: []uint64{ // 60 x 4
+		0xffffffffffc0001, 0xfffffffff840001, 0xfffffffff6a0001, 0xfffffffff5a0001,
+	},
+	Sigma:        rlwe.DefaultSigma,
+	DefaultScale: 1 << 40,
+	LogSlots:     11,
+	Gamma:        2,
+	RingType:     ring.Standard,
+}
+
+type testContext struct {
+	params fckks.Parameters
+	kgen   *frlwe.KeyGenerator
+	sk     *rlwe.SecretKey
+	enc    *fckks.Encryptor
+	dec    *fckks.Decryptor
+}
+
+func randomVector(n int, bound float64) (v []float64) {
+	v = make([]float64, n)
+	for i := range v {
+		v[i] = utils.RandFloat64(-bound, bound)
+	}
+	return
+}
+
+func randomMatrix(rows, cols int, bound float64) (m [][]float64) {
+	m = make([][]float64, rows)
+	for i := range m {
+		m[i] = randomVector(cols, bound)
+	}
+	return
+}
+
+func randomKernel(outChannels, inChannels, kh, kw int, bound float64) (kernel [][][][]float64) {
+	kernel = make([][][][]float64, outChannels)
+	for o := range kernel {
+		kernel[o] = make([][][]float64, inChannels)
+		for c := range kernel[o] {
+			kernel[o][c] = randomMatrix(kh, kw, bound)
+		}
+	}
+	return
+}
+
+func TestLayer(t *testing.T) {
+
+	params := fckks.NewParametersFromLiteral(pn12qp342)
+	kgen := fckks.NewKeyGenerator(params)
+	sk, pk := kgen.GenKeyPair()
+
+	testctx := &testContext{
+		params: params,
+		kgen:   kgen,
+		sk:     sk,
+		enc:    fckks.NewEncryptor(params, pk),
+		dec:    fckks.NewDecryptor(params, sk),
+	}
+
+	testLinear(t)
+
+	// MLP of 64 inputs, with a hidden layer of 32 activations and 10 outputs
+	testSequential(testctx, "MLP", NewSequential(
+		NewDense(randomMatrix(32, 64, 2.0/64), randomVector(32, 0.1)),
+		NewActivationFunc(32, math.Tanh, -4, 4, 3),
+		NewDense(randomMatrix(10, 32, 2.0/32), randomVector(10, 0.1)),
+	), t)
+
+	// Convolutional network on 6 x 6 images of 2 channels
+	conv := NewConv2D(6, 6, randomKernel(3, 2, 3, 3, 0.2), randomVector(3, 0.1), 1, 1)
+	conv.FoldBatchNorm(BatchNorm{
+		Gamma:    randomVector(3, 1),
+		Beta:     randomVector(3, 0.1),
+		Mean:     randomVector(3, 0.1),
+		Variance: []float64{0.5, 1, 2},
+		Epsilon:  1e-5,
+	})
+	testSequential(testctx, "CNN", NewSequential(
+		conv,
+		NewActivation(108, fckks.NewPolynomial(fckks.Monomial, []complex128{0, 0.5, 0.25}, 0, 0)),
+		NewAveragePool(6, 6, 3, 2),
+		NewDense(randomMatrix(10, 27, 2.0/27), nil),
+	), t)
+
+	// 1D convolutions on sequences of 16 positions of 2 channels
+	testSequential(testctx, "Conv1D", NewSequential(
+		NewConv1D(16, [][][]float64{{{0.5, -0.25, 0.1}, {0.2, 0.3, -0.4}}, {{-0.1, 0.6, 0.2}, {0.3, -0.2, 0.1}}}, nil, 2, 1),
+		NewActivation(16, fckks.NewPolynomial(fckks.Monomial, []complex128{0, 1, 0.5}, 0, 0)),
+		NewAveragePool(1, 8, 2, 2),
+	), t)
+
+	t.Run("Errors", func(t *testing.T) {
+
+		require.Panics(t, func() { NewSequential(NewDense(randomMatrix(4, 8, 1), nil), NewDense(randomMatrix(4, 8, 1), nil)) })
+		require.Panics(t, func() { NewDense(randomMatrix(4, 8, 1), randomVector(3, 1)) })
+		require.Panics(t, func() { NewConv2D(2, 2, randomKernel(1, 1, 3, 3, 1), nil, 1, 0) })
+
+		dense := NewDense(randomMatrix(4, 8, 1), nil)
+		model := NewSequential(dense, NewActivationFunc(4, math.Tanh, -4, 4, 7), NewDense(randomMatrix(2, 4, 1), nil))
+
+		ct := testctx.enc.EncryptMsgNew(fckks.NewMessage(params))
+		eval := fckks.NewEvaluator(params, frlwe.EvaluationKey{})
+
+		_, err := model.Forward(eval, ct)
+		require.Error(t, err)
+
+		require.Error(t, model.Compile(params, model.Depth()-1))
+		require.NoError(t, model.Compile(params, model.Depth()))
+
+		_, err = model.Forward(eval, eval.DropLevelNew(ct, params.MaxLogicalLevel()))
+		require.Error(t, err)
+
+		// The bias is encoded at the default scale
+		ctScaled := ct.CopyNew()
+		ctScaled.Scale *= 2
+		_, err = dense.Forward(eval, ctScaled)
+		require.Error(t, err)
+
+		dense.FoldBatchNorm(BatchNorm{Gamma: []float64{1, 1, 1, 1}, Beta: make([]float64, 4), Mean: make([]float64, 4), Variance: []float64{1, 1, 1, 1}})
+		_, err = dense.Forward(eval, ct)
+		require.Error(t, err)
+	})
+}
+
+// testLinear checks the weights of the linear layers against their direct definition.
+func testLinear(t *testing.T) {
+
+	t.Run("Conv2D", func(t *testing.T) {
+
+		height, width, inChannels, outChannels, size, stride, padding := 5, 7, 2, 3, 3, 2, 1
+		kernel := randomKernel(outChannels, inChannels, size, size, 1)
+		bias := randomVector(outChannels, 1)
+		x := randomVector(height*width*inChannels, 1)
+
+		conv := NewConv2D(height, width, kernel, bias, stride, padding)
+		outHeight, outWidth := (height+2*padding-size)/stride+1, (width+2*padding-size)/stride+1
+		require.Equal(t, outHeight*outWidth*outChannels, conv.OutputSize())
+
+		y := conv.ForwardPlain(x)
+		for i := 0; i < outHeight; i++ {
+			for j := 0; j < outWidth; j++ {
+				for o := 0; o < outChannels; o++ {
+					want := bias[o]
+					for dy := 0; dy < size; dy++ {
+						for dx := 0; dx < size; dx++ {
+							yIn, xIn := i*stride+dy-padding, j*stride+dx-padding
+							if yIn < 0 || yIn >= height || xIn < 0 || xIn >= width {
+								continue
+							}
+							for c := 0; c < inChannels; c++ {
+								want += kernel[o][c][dy][dx] * x[(yIn*width+xIn)*inChannels+c]
+							}
+						}
+					}
+					require.InDelta(t, want, y[(i*outWidth+j)*outChannels+o], 1e-12)
+				}
+			}
+		}
+	})
+
+	t.Run("AveragePool", func(t *testing.T) {
+		x := randomVector(4*4*2, 1)
+		y := NewAveragePool(4, 4, 2, 2).ForwardPlain(x)
+		require.Len(t, y, 2*2*2)
+		require.InDelta(t, (x[1]+x[3]+x[9]+x[11])/4, y[1], 1e-12)
+		require.InDelta(t, (x[20]+x[22]+x[28]+x[30])/4, y[6], 1e-12)
+	})
+
+	t.Run("FoldBatchNorm", func(t *testing.T) {
+		dense := NewDense(randomMatrix(3, 5, 1), randomVector(3, 1))
+		x := randomVector(5, 1)
+		y := dense.ForwardPlain(x)
+
+		bn := BatchNorm{Gamma: randomVector(3, 1), Beta: randomVector(3, 1), Mean: randomVector(3, 1), Variance: []float64{0.5, 1, 2}, Epsilon: 1e-5}
+		dense.FoldBatchNorm(bn)
+
+		for i, yi := range dense.ForwardPlain(x) {
+			require.InDelta(t, bn.Gamma[i]*(y[i]-bn.Mean[i])/math.Sqrt(bn.Variance[i]+bn.Epsilon)+bn.Beta[i], yi, 1e-12)
+		}
+	})
+}
+
+// testSequential runs model on random inputs in [-1, 1] encrypted at the top logical level and compares the
+// result with the forward pass in the clear.
+func testSequential(testctx *testContext, name string, model *Sequential, t *testing.T) {
+
+	params := testctx.params
+
+	t.Run(fmt.Sprintf("Sequential/%s", name), func(t *testing.T) {
+
+		evk := frlwe.EvaluationKey{
+			Rlk:  testctx.kgen.GenRelinKey(testctx.sk),
+			Rtks: testctx.kgen.GenRotKeys(model.Rotations(params), testctx.sk),
+		}
+		eval := fckks.NewEvaluator(params, evk)
+
+		require.NoError(t, model.Compile(params, params.MaxLogicalLevel()))
+
+		levels := model.Levels()
+		require.Equal(t, params.MaxLogicalLevel(), levels[0])
+		for i := 1; i < len(levels); i++ {
+			require.Equal(t, levels[i-1]-model.Layers[i-1].Depth(), levels[i])
+		}
+
+		x := randomVector(model.InputSize(), 1)
+		msg := fckks.NewMessage(params)
+		for i := range x {
+			msg.Value[i] = complex(x[i], 0)
+		}
+
+		ct, err := model.Forward(eval, testctx.enc.EncryptMsgNew(msg))
+		require.NoError(t, err)
+		require.Equal(t, params.LevelQ(params.MaxLogicalLevel()-model.Depth()), ct.Level())
+		require.Equal(t, params.DefaultScale(), ct.Scale)
+
+		want := model.ForwardPlain(x)
+		have := testctx.dec.DecryptToMsgNew(ct).Value
+		for i := range want {
+			require.InDelta(t, want[i], real(have[i]), math.Exp2(-15))
+		}
+	})
+}
@@ -0,0 +1,366 @@
+package layer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+
+	"fast-ksw/ckks"
+	"fast-ksw/fckks"
+)
+
+// weight is the entry of the matrix of a Linear layer multiplying the input activation in to the output
+// activation out.
+type weight struct {
+	out, in int
+	w       float64
+}
+
+// Linear is an affine layer y = W * x + b, with W a sparse matrix. It implements the dense layers, the
+// convolutions and the average pooling. W is a linear transform on the slots, evaluated on its non-zero diagonals
+// with the baby-step giant-step algorithm of fckks.Evaluator.LinearTransformAndRescale. Its plaintexts are encoded
+// at the scale of the modulus of their logical level, so that the rescaling divides exactly by their scale.
+// Forward consumes one logical level.
+//
+// The output activations are grouped in channels, the output out being in the channel out % Channels(), to which
+// FoldBatchNorm applies the batch normalization.
+type Linear struct {
+	in, out  int
+	channels int
+	weights  []weight
+	bias     []float64
+
+	params fckks.Parameters
+	level  int
+	lt     *fckks.LinearTransform
+	ptBias *ckks.Plaintext
+}
+
+// NewDense creates a new dense layer of an input of len(weights[0]) activations to an output of len(weights)
+// activations, y[i] = sum_j weights[i][j] * x[j] + bias[i]. bias can be nil.
+func NewDense(weights [][]float64, bias []float64) (l *Linear) {
+
+	if len(weights) == 0 || len(weights[0]) == 0 {
+		panic("cannot NewDense: the weight matrix is empty")
+	}
+
+	l = newLinear("NewDense", len(weights[0]), len(weights), len(weights), bias)
+
+	for i, row := range weights {
+		if len(row) != l.in {
+			panic(fmt.Sprintf("cannot NewDense: row %d has %d weights instead of %d", i, len(row), l.in))
+		}
+		for j, w := range row {
+			l.addWeight(i, j, w)
+		}
+	}
+
+	return
+}
+
+// NewConv2D creates a new 2D convolution of an input of height x width pixels of len(kernel[0]) channels to
+// len(kernel) channels, with kernel[o][c][dy][dx] the weight of the input channel c in the output channel o at the
+// offset (dy, dx) of the window, and bias[o] the bias of the output channel o. bias can be nil. The input is padded
+// with padding zeros on each side and the windows are spaced by stride, for an output of
+// (height + 2*padding - kh)/stride + 1 x (width + 2*padding - kw)/stride + 1 pixels, with kh x kw the size of
+// the kernel.
+//
+// The channels of a pixel are multiplexed in consecutive slots: the channel c of the pixel (y, x) of an image of
+// width w and C channels is in the slot (y*w + x)*C + c, for the input and for the output. With this packing, the
+// convolutions with a stride 1 have at most kh*kw*(2C-1) diagonals.
+func NewConv2D(height, width int, kernel [][][][]float64, bias []float64, stride, padding int) *Linear {
+	return newConv("NewConv2D", height, width, kernel, bias, stride, stride, padding, padding)
+}
+
+// NewConv1D creates a new 1D convolution of an input of length positions of len(kernel[0]) channels to len(kernel)
+// channels, with kernel[o][c][d] the weight of the input channel c in the output channel o at the offset d of the
+// window. It is the NewConv2D of an input of height 1, padded and strided along its length only, for an output
+// of (length + 2*padding - k)/stride + 1 positions.
+func NewConv1D(length int, kernel [][][]float64, bias []float64, stride, padding int) *Linear {
+
+	kernel2D := make([][][][]float64, len(kernel))
+	for o := range kernel {
+		kernel2D[o] = make([][][]float64, len(kernel[o]))
+		for c := range kernel[o] {
+			kernel2D[o][c] = [][]float64{kernel[o][c]}
+		}
+	}
+
+	return newConv("NewConv1D", 1, length, kernel2D, bias, 1, stride, 0, padding)
+}
+
+// NewAveragePool creates a new average pooling of an input of height x width pixels of the given number of
+// channels, packed as in NewConv2D, over non-overlapping windows of size x size pixels, for an output of
+// height/size x width/size pixels. A 1D pooling is the one of an input of height 1 with windows of size 1 x size,
+// given by a height of 1.
+func NewAveragePool(height, width, channels, size int) (l *Linear) {
+
+	if height < 1 || width < 1 || channels < 1 || size < 1 {
+		panic("cannot NewAveragePool: the sizes must be positive")
+	}
+
+	sizeY := size
+	if height == 1 {
+		sizeY = 1
+	}
+
+	outHeight, outWidth := height/sizeY, width/size
+	if outHeight < 1 || outWidth < 1 {
+		panic("cannot NewAveragePool: the window is larger than the input")
+	}
+
+	l = newLinear("NewAveragePool", height*width*channels, outHeight*outWidth*channels, channels, nil)
+
+	w := 1 / float64(sizeY*size)
+	for y := 0; y < outHeight; y++ {
+		for x := 0; x < outWidth; x++ {
+			for c := 0; c < channels; c++ {
+				out := (y*outWidth+x)*channels + c
+				for dy := 0; dy < sizeY; dy++ {
+					for dx := 0; dx < size; dx++ {
+						l.addWeight(out, ((y*sizeY+dy)*width+x*size+dx)*channels+c, w)
+					}
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// newLinear creates a new Linear layer of in to out activations in the given number of channels, without weights.
+func newLinear(op string, in, out, channels int, bias []float64) *Linear {
+
+	if bias == nil {
+		bias = make([]float64, channels)
+	}
+
+	if len(bias) != channels {
+		panic(fmt.Sprintf("cannot %s: the bias has %d values instead of %d", op, len(bias), channels))
+	}
+
+	l := &Linear{in: in, out: out, channels: channels, bias: make([]float64, out)}
+	for i := range l.bias {
+		l.bias[i] = bias[i%channels]
+	}
+
+	return l
+}
+
+// newConv creates the 2D convolution of NewConv2D with the strides and the paddings of each dimension.
+func newConv(op string, height, width int, kernel [][][][]float64, bias []float64, strideY, strideX, padY, padX int) (l *Linear) {
+
+	if height < 1 || width < 1 || strideY < 1 || strideX < 1 || padY < 0 || padX < 0 {
+		panic(fmt.Sprintf("cannot %s: invalid input size, stride or padding", op))
+	}
+
+	if len(kernel) == 0 || len(kernel[0]) == 0 || len(kernel[0][0]) == 0 || len(kernel[0][0][0]) == 0 {
+		panic(fmt.Sprintf("cannot %s: the kernel is empty", op))
+	}
+
+	outChannels, inChannels := len(kernel), len(kernel[0])
+	kh, kw := len(kernel[0][0]), len(kernel[0][0][0])
+
+	outHeight, outWidth := (height+2*padY-kh)/strideY+1, (width+2*padX-kw)/strideX+1
+	if height+2*padY < kh || width+2*padX < kw {
+		panic(fmt.Sprintf("cannot %s: the kernel is larger than the padded input", op))
+	}
+
+	l = newLinear(op, height*width*inChannels, outHeight*outWidth*outChannels, outChannels, bias)
+
+	for o := range kernel {
+		if len(kernel[o]) != inChannels {
+			panic(fmt.Sprintf("cannot %s: output channel %d has %d input channels instead of %d", op, o, len(kernel[o]), inChannels))
+		}
+		for c := range kernel[o] {
+			if len(kernel[o][c]) != kh {
+				panic(fmt.Sprintf("cannot %s: the kernels must have the same size", op))
+			}
+			for dy := range kernel[o][c] {
+				if len(kernel[o][c][dy]) != kw {
+					panic(fmt.Sprintf("cannot %s: the kernels must have the same size", op))
+				}
+			}
+		}
+	}
+
+	for y := 0; y < outHeight; y++ {
+		for x := 0; x < outWidth; x++ {
+			for o := 0; o < outChannels; o++ {
+				out := (y*outWidth+x)*outChannels + o
+				for dy := 0; dy < kh; dy++ {
+					yIn := y*strideY + dy - padY
+					if yIn < 0 || yIn >= height {
+						continue
+					}
+					for dx := 0; dx < kw; dx++ {
+						xIn := x*strideX + dx - padX
+						if xIn < 0 || xIn >= width {
+							continue
+						}
+						for c := 0; c < inChannels; c++ {
+							l.addWeight(out, (yIn*width+xIn)*inChannels+c, kernel[o][c][dy][dx])
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// addWeight adds the weight w of the input activation in to the output activation out.
+func (l *Linear) addWeight(out, in int, w float64) {
+	if w != 0 {
+		l.weights = append(l.weights, weight{out: out, in: in, w: w})
+	}
+}
+
+// InputSize returns the number of input activations.
+func (l *Linear) InputSize() int {
+	return l.in
+}
+
+// OutputSize returns the number of output activations.
+func (l *Linear) OutputSize() int {
+	return l.out
+}
+
+// Channels returns the number of channels of the output activations.
+func (l *Linear) Channels() int {
+	return l.channels
+}
+
+// Depth returns the number of logical levels consumed by Forward.
+func (l *Linear) Depth() int {
+	return 1
+}
+
+// BatchNorm is a batch normalization y = Gamma * (x - Mean) / sqrt(Variance + Epsilon) + Beta, given for each
+// channel of its input.
+type BatchNorm struct {
+	Gamma, Beta, Mean, Variance []float64
+	Epsilon                     float64
+}
+
+// FoldBatchNorm folds the batch normalization bn, applied to the output channels of l, in the weights and the
+// bias of l, so that it costs no level. The layer must be compiled afterwards.
+func (l *Linear) FoldBatchNorm(bn BatchNorm) {
+
+	if len(bn.Gamma) != l.channels || len(bn.Beta) != l.channels || len(bn.Mean) != l.channels || len(bn.Variance) != l.channels {
+		panic(fmt.Sprintf("cannot FoldBatchNorm: the batch normalization must have %d channels", l.channels))
+	}
+
+	factors := make([]float64, l.channels)
+	for c := range factors {
+		factors[c] = bn.Gamma[c] / math.Sqrt(bn.Variance[c]+bn.Epsilon)
+	}
+
+	for i := range l.weights {
+		l.weights[i].w *= factors[l.weights[i].out%l.channels]
+	}
+
+	for i := range l.bias {
+		c := i % l.channels
+		l.bias[i] = (l.bias[i]-bn.Mean[c])*factors[c] + bn.Beta[c]
+	}
+
+	l.lt = nil
+}
+
+// diagonals returns the diagonals of W on the slots.
+func (l *Linear) diagonals(slots int) (diagonals map[int][]complex128) {
+
+	diagonals = make(map[int][]complex128)
+
+	for _, e := range l.weights {
+		k := (e.in - e.out + slots) % slots
+		if _, ok := diagonals[k]; !ok {
+			diagonals[k] = make([]complex128, slots)
+		}
+		diagonals[k][e.out] += complex(e.w, 0)
+	}
+
+	return
+}
+
+// checkSlots panics if the activations of l do not fit in the slots of params.
+func (l *Linear) checkSlots(params fckks.Parameters) {
+	if l.in > params.Slots() || l.out > params.Slots() {
+		panic(fmt.Sprintf("cannot Linear: %d to %d activations do not fit in %d slots", l.in, l.out, params.Slots()))
+	}
+}
+
+// Rotations returns the rotations used by Forward.
+func (l *Linear) Rotations(params fckks.Parameters) []int {
+
+	l.checkSlots(params)
+
+	diags := make([]int, 0)
+	for k := range l.diagonals(params.Slots()) {
+		diags = append(diags, k)
+	}
+
+	return params.RotationsForLinearTransform(diags, params.LogSlots())
+}
+
+// Compile encodes the diagonals of W for the ciphertexts at the given logical level, and the bias at the default
+// scale of params for the results, one logical level below.
+func (l *Linear) Compile(params fckks.Parameters, ecd ckks.Encoder, logicalLevel int) error {
+
+	l.checkSlots(params)
+
+	if logicalLevel < 1 || logicalLevel > params.MaxLogicalLevel() {
+		return errors.New("cannot Compile: the logical level must be between 1 and MaxLogicalLevel")
+	}
+
+	level := params.LevelQ(logicalLevel)
+	modulus, _ := new(big.Float).SetInt(params.LevelModulus(level)).Float64()
+	lt := fckks.NewLinearTransform(params, ecd, l.diagonals(params.Slots()), level, modulus, params.LogSlots())
+
+	bias := make([]float64, params.Slots())
+	copy(bias, l.bias)
+
+	l.params, l.level, l.lt = params, logicalLevel, &lt
+	l.ptBias = ecd.EncodeNew(bias, params.LevelQ(logicalLevel-1), params.DefaultScale(), params.LogSlots())
+
+	return nil
+}
+
+// Forward evaluates W * x + b on ctIn and returns the result in a newly created element, one logical level below,
+// with the scale of ctIn, which must be the default scale of the parameters at which the bias is encoded. The slots
+// after OutputSize() are set to zero.
+func (l *Linear) Forward(eval *fckks.Evaluator, ctIn *ckks.Ciphertext) (ctOut *ckks.Ciphertext, err error) {
+
+	if l.lt == nil {
+		return nil, errors.New("cannot Forward: the layer is not compiled")
+	}
+
+	if ctIn.Scale != l.ptBias.Scale {
+		return nil, errors.New("cannot Forward: input Ciphertext is not at the default scale")
+	}
+
+	if ctIn, err = atLogicalLevel(eval, l.params, ctIn, l.level); err != nil {
+		return nil, err
+	}
+
+	ctOut = eval.LinearTransformAndRescaleNew(ctIn, *l.lt)
+	eval.Add(ctOut, l.ptBias, ctOut)
+
+	return ctOut, nil
+}
+
+// ForwardPlain evaluates W * x + b on the activations x in the clear.
+func (l *Linear) ForwardPlain(x []float64) (y []float64) {
+
+	y = make([]float64, l.out)
+	copy(y, l.bias)
+
+	for _, e := range l.weights {
+		y[e.out] += e.w * x[e.in]
+	}
+
+	return
+}
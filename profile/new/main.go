@@ -7,6 +7,7 @@ import (
 	"runtime/pprof"
 
 	"fast-ksw/fckks"
+	"fast-ksw/frlwe"
 	"fast-ksw/rlwe"
 )
 
@@ -60,7 +61,7 @@ func main() {
 	rlk := kgen.GenRelinKey(sk)
 
 	enc := fckks.NewEncryptor(params, pk)
-	eval := fckks.NewEvaluator(params)
+	eval := fckks.NewEvaluator(params, frlwe.EvaluationKey{Rlk: rlk})
 
 	msg := fckks.NewMessage(params)
 	ct0 := enc.EncryptMsgNew(msg)
@@ -86,6 +87,6 @@ func main() {
 
 	// ... rest of the program ...
 	for i := 0; i < 50; i++ {
-		eval.SwitchKeysNew(ct0, rlk.Value)
+		eval.SwitchKeysFastNew(ct0, rlk.Value)
 	}
 }
@@ -127,6 +127,36 @@ func (r *Ring) PermuteNTTWithIndexAndAddNoModLvl(level int, polIn *Poly, index [
 	}
 }
 
+// PermuteNTTWithIndexAndMulCoeffsMontgomeryAndAddLvl applies the Galois transform on a polynomial in the NTT domain,
+// up to a given level, multiplies the result coefficient-wise with p1 with a Montgomery modular reduction and adds
+// it to the output polynomial. It maps the coefficients x^i to x^(gen*i) using the PermuteNTTIndex table.
+// It must be noted that the result cannot be in-place.
+func (r *Ring) PermuteNTTWithIndexAndMulCoeffsMontgomeryAndAddLvl(level int, polIn *Poly, index []uint64, p1, polOut *Poly) {
+
+	for i := 0; i < level+1; i++ {
+
+		qi := r.Modulus[i]
+		mredParams := r.MredParams[i]
+		y := polIn.Coeffs[i]
+
+		for j := 0; j < r.N; j = j + 8 {
+
+			x := (*[8]uint64)(unsafe.Pointer(&index[j]))
+			w := (*[8]uint64)(unsafe.Pointer(&p1.Coeffs[i][j]))
+			z := (*[8]uint64)(unsafe.Pointer(&polOut.Coeffs[i][j]))
+
+			z[0] = CRed(z[0]+MRed(y[x[0]], w[0], qi, mredParams), qi)
+			z[1] = CRed(z[1]+MRed(y[x[1]], w[1], qi, mredParams), qi)
+			z[2] = CRed(z[2]+MRed(y[x[2]], w[2], qi, mredParams), qi)
+			z[3] = CRed(z[3]+MRed(y[x[3]], w[3], qi, mredParams), qi)
+			z[4] = CRed(z[4]+MRed(y[x[4]], w[4], qi, mredParams), qi)
+			z[5] = CRed(z[5]+MRed(y[x[5]], w[5], qi, mredParams), qi)
+			z[6] = CRed(z[6]+MRed(y[x[6]], w[6], qi, mredParams), qi)
+			z[7] = CRed(z[7]+MRed(y[x[7]], w[7], qi, mredParams), qi)
+		}
+	}
+}
+
 // Permute applies the Galois transform on a polynomial outside of the NTT domain.
 // It maps the coefficients x^i to x^(gen*i)
 // It must be noted that the result cannot be in-place.
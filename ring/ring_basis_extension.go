@@ -18,6 +18,7 @@ package ring
 
 import (
 	"math"
+	"math/big"
 	"math/bits"
 	"unsafe"
 )
@@ -32,10 +33,30 @@ type BasisExtender struct {
 	modDownparamsPtoQ [][]uint64
 	modDownparamsQtoP [][]uint64
 
+	// Parameters of ModDownQPtoQRescale, generated on first use for each (levelQ, nbRescales, levelP)
+	modDownRescaleParams map[[3]int]*modDownRescaleParams
+
 	polypoolQ *Poly
 	polypoolP *Poly
 }
 
+// modDownRescaleParams stores the parameters of ModDownQPtoQRescale for a given levelQ, nbRescales and levelP.
+type modDownRescaleParams struct {
+	// The moduli D divided by: the nbRescales last moduli of Q followed by P.
+	// Only the moduli and the Montgomery parameters of the ring are set.
+	ringD *Ring
+	// Parameters for basis extension from D to the remaining moduli of Q
+	modup modupParams
+	// floor(D/2) mod each modulus of D
+	halfDModD []uint64
+	// floor(D/2) mod each remaining modulus of Q
+	halfDModQ []uint64
+	// -D^-1 mod each remaining modulus of Q (in Montgomery form)
+	dInvNegModQ []uint64
+	// q^-1 mod each remaining modulus of Q, with q the product of the moduli of Q divided by (in Montgomery form)
+	qInvModQ []uint64
+}
+
 type modupParams struct {
 	//Parameters for basis extension from Q to P
 	// (Q/Qi)^-1) (mod each Qi) (in Montgomery form)
@@ -88,6 +109,7 @@ func NewBasisExtender(ringQ, ringP *Ring) *BasisExtender {
 
 	newParams.modDownparamsPtoQ = genModDownParams(ringQ, ringP)
 	newParams.modDownparamsQtoP = genModDownParams(ringP, ringQ)
+	newParams.modDownRescaleParams = make(map[[3]int]*modDownRescaleParams)
 
 	newParams.polypoolQ = ringQ.NewPoly()
 	newParams.polypoolP = ringP.NewPoly()
@@ -181,6 +203,8 @@ func (be *BasisExtender) ShallowCopy() *BasisExtender {
 		modDownparamsQtoP: be.modDownparamsQtoP,
 		modDownparamsPtoQ: be.modDownparamsPtoQ,
 
+		modDownRescaleParams: make(map[[3]int]*modDownRescaleParams),
+
 		polypoolQ: be.ringQ.NewPoly(),
 		polypoolP: be.ringP.NewPoly(),
 	}
@@ -277,6 +301,102 @@ func (be *BasisExtender) ModDownQPtoP(levelQ, levelP int, p1Q, p1P, p2P *Poly) {
 	// In total we do len(P) + len(Q) NTT, which is optimal (linear in the number of moduli of P and Q)
 }
 
+// ModDownQPtoQRescale reduces the basis of a polynomial and divides it by P and by the last moduli of Q
+// with a single basis extension.
+// Given a polynomial with coefficients in basis {Q0,Q1....QlevelQ} and {P0,P1...PlevelP},
+// it reduces its basis to {Q0,Q1....QlevelQ-nbRescales} and does a rounded integer division of the
+// result by the product D of {QlevelQ-nbRescales+1....QlevelQ} and {P0,P1...PlevelP}.
+// Inputs and output must be out of the NTT domain, and p2Q can be p1Q.
+func (be *BasisExtender) ModDownQPtoQRescale(levelQ, nbRescales, levelP int, p1Q, p1P, p2Q *Poly) {
+
+	ringQ := be.ringQ
+	params := be.getModDownRescaleParams(levelQ, nbRescales, levelP)
+	levelOut := levelQ - nbRescales
+
+	// The residues of p1 + floor(D/2) mod D are stored on the unused rows of the pools
+	polyD := make([][]uint64, nbRescales+levelP+1)
+	for i := range polyD {
+		var p1D []uint64
+		if i < nbRescales {
+			p1D = p1Q.Coeffs[levelOut+1+i]
+			polyD[i] = be.polypoolQ.Coeffs[levelOut+1+i]
+		} else {
+			p1D = p1P.Coeffs[i-nbRescales]
+			polyD[i] = be.polypoolP.Coeffs[i-nbRescales]
+		}
+		AddScalarVec(p1D, polyD[i], params.halfDModD[i], params.ringD.Modulus[i])
+	}
+
+	// polypool is now the representation of [p1 + floor(D/2)]_D in basis {Q0,Q1....QlevelQ-nbRescales}
+	modUpExact(polyD, be.polypoolQ.Coeffs[:levelOut+1], params.ringD, ringQ, params.modup)
+
+	// Finally, we compute p2 = (p1 + floor(D/2) - polypool) * D^-1 mod Q
+	for i := 0; i < levelOut+1; i++ {
+		AddScalarVec(p1Q.Coeffs[i], p2Q.Coeffs[i], params.halfDModQ[i], ringQ.Modulus[i])
+		SubVecAndMulScalarMontgomeryTwoQiVec(be.polypoolQ.Coeffs[i], p2Q.Coeffs[i], p2Q.Coeffs[i], params.dInvNegModQ[i], ringQ.Modulus[i], ringQ.MredParams[i])
+	}
+}
+
+// RescaleInvModQ returns the inverse, modulo each of the moduli {Q0,Q1....QlevelQ-nbRescales} and in Montgomery
+// form, of the product of the moduli of Q divided by ModDownQPtoQRescale(levelQ, nbRescales, levelP, ...).
+func (be *BasisExtender) RescaleInvModQ(levelQ, nbRescales, levelP int) []uint64 {
+	return be.getModDownRescaleParams(levelQ, nbRescales, levelP).qInvModQ
+}
+
+func (be *BasisExtender) getModDownRescaleParams(levelQ, nbRescales, levelP int) *modDownRescaleParams {
+
+	key := [3]int{levelQ, nbRescales, levelP}
+	if params, ok := be.modDownRescaleParams[key]; ok {
+		return params
+	}
+
+	ringQ, ringP := be.ringQ, be.ringP
+	levelOut := levelQ - nbRescales
+
+	ringD := &Ring{
+		Modulus:    append(append([]uint64{}, ringQ.Modulus[levelOut+1:levelQ+1]...), ringP.Modulus[:levelP+1]...),
+		MredParams: append(append([]uint64{}, ringQ.MredParams[levelOut+1:levelQ+1]...), ringP.MredParams[:levelP+1]...),
+		BredParams: append(append([][]uint64{}, ringQ.BredParams[levelOut+1:levelQ+1]...), ringP.BredParams[:levelP+1]...),
+	}
+
+	D := NewUint(1)
+	for _, di := range ringD.Modulus {
+		D.Mul(D, NewUint(di))
+	}
+	halfD := new(big.Int).Rsh(D, 1)
+
+	q := NewUint(1)
+	for _, qi := range ringQ.Modulus[levelOut+1 : levelQ+1] {
+		q.Mul(q, NewUint(qi))
+	}
+
+	params := &modDownRescaleParams{
+		ringD:       ringD,
+		modup:       basisextenderparameters(ringD.Modulus, ringQ.Modulus[:levelOut+1]),
+		halfDModD:   make([]uint64, len(ringD.Modulus)),
+		halfDModQ:   make([]uint64, levelOut+1),
+		dInvNegModQ: make([]uint64, levelOut+1),
+		qInvModQ:    make([]uint64, levelOut+1),
+	}
+
+	tmp := new(big.Int)
+	for i, di := range ringD.Modulus {
+		params.halfDModD[i] = tmp.Mod(halfD, NewUint(di)).Uint64()
+	}
+
+	for i, qi := range ringQ.Modulus[:levelOut+1] {
+		params.halfDModQ[i] = tmp.Mod(halfD, NewUint(qi)).Uint64()
+		dInv := ModExp(tmp.Mod(D, NewUint(qi)).Uint64(), qi-2, qi)
+		params.dInvNegModQ[i] = qi - MForm(dInv, qi, ringQ.BredParams[i])
+		qInv := ModExp(tmp.Mod(q, NewUint(qi)).Uint64(), qi-2, qi)
+		params.qInvModQ[i] = MForm(qInv, qi, ringQ.BredParams[i])
+	}
+
+	be.modDownRescaleParams[key] = params
+
+	return params
+}
+
 // Caution, returns the values in [0, 2q-1]
 func modUpExact(p1, p2 [][]uint64, ringQ, ringP *Ring, params modupParams) {
 
@@ -708,6 +708,55 @@ func testExtendBasis(testContext *testParams, t *testing.T) {
 		}
 
 	})
+
+	t.Run(testString("ModDownRescale/", testContext.ringQ), func(t *testing.T) {
+
+		basisextender := NewBasisExtender(testContext.ringQ, testContext.ringP)
+
+		levelQ := len(testContext.ringQ.Modulus) - 1
+		levelP := len(testContext.ringP.Modulus) - 1
+
+		for nbRescales := 1; nbRescales < levelQ+1; nbRescales++ {
+
+			levelOut := levelQ - nbRescales
+
+			D := NewUint(1)
+			QP := NewUint(1)
+			for i := range testContext.ringQ.Modulus[:levelQ+1] {
+				if i > levelOut {
+					D.Mul(D, NewUint(testContext.ringQ.Modulus[i]))
+				}
+				QP.Mul(QP, NewUint(testContext.ringQ.Modulus[i]))
+			}
+
+			for i := range testContext.ringP.Modulus[:levelP+1] {
+				D.Mul(D, NewUint(testContext.ringP.Modulus[i]))
+				QP.Mul(QP, NewUint(testContext.ringP.Modulus[i]))
+			}
+
+			coeffs := make([]*big.Int, testContext.ringQ.N)
+			coeffsWant := make([]*big.Int, testContext.ringQ.N)
+			for i := range coeffs {
+				coeffs[i] = RandInt(QP)
+				coeffsWant[i] = new(big.Int)
+				DivRound(coeffs[i], D, coeffsWant[i])
+			}
+
+			PolQHave := testContext.ringQ.NewPolyLvl(levelQ)
+			PolPHave := testContext.ringP.NewPolyLvl(levelP)
+			PolQWant := testContext.ringQ.NewPolyLvl(levelOut)
+
+			testContext.ringQ.SetCoefficientsBigintLvl(levelQ, coeffs, PolQHave)
+			testContext.ringP.SetCoefficientsBigintLvl(levelP, coeffs, PolPHave)
+			testContext.ringQ.SetCoefficientsBigintLvl(levelOut, coeffsWant, PolQWant)
+
+			basisextender.ModDownQPtoQRescale(levelQ, nbRescales, levelP, PolQHave, PolPHave, PolQHave)
+
+			for i := 0; i < levelOut+1; i++ {
+				require.Equal(t, PolQWant.Coeffs[i], PolQHave.Coeffs[i])
+			}
+		}
+	})
 }
 
 func testScaling(testContext *testParams, t *testing.T) {
@@ -49,11 +49,21 @@ type skEncryptor struct {
 // NewEncryptor creates a new Encryptor
 // Accepts either a secret-key or a public-key.
 func NewEncryptor(params Parameters, key interface{}) Encryptor {
-	enc := newEncryptor(params)
+	prng, err := utils.NewPRNG()
+	if err != nil {
+		panic(err)
+	}
+	return NewEncryptorWithPRNG(params, key, prng)
+}
+
+// NewEncryptorWithPRNG creates a new Encryptor whose samplers all read from the provided PRNG.
+// Accepts either a secret-key or a public-key.
+func NewEncryptorWithPRNG(params Parameters, key interface{}, prng utils.PRNG) Encryptor {
+	enc := newEncryptor(params, prng)
 	return enc.setKey(key)
 }
 
-func newEncryptor(params Parameters) encryptor {
+func newEncryptor(params Parameters, prng utils.PRNG) encryptor {
 
 	var bc *ring.BasisExtender
 	if params.PCount() != 0 {
@@ -62,7 +72,7 @@ func newEncryptor(params Parameters) encryptor {
 
 	return encryptor{
 		encryptorBase:     newEncryptorBase(params),
-		encryptorSamplers: newEncryptorSamplers(params),
+		encryptorSamplers: newEncryptorSamplers(params, prng),
 		encryptorBuffers:  newEncryptorBuffers(params),
 		basisextender:     bc,
 	}
@@ -83,12 +93,7 @@ type encryptorSamplers struct {
 	uniformSampler  *ring.UniformSampler
 }
 
-func newEncryptorSamplers(params Parameters) *encryptorSamplers {
-	prng, err := utils.NewPRNG()
-	if err != nil {
-		panic(err)
-	}
-
+func newEncryptorSamplers(params Parameters, prng utils.PRNG) *encryptorSamplers {
 	return &encryptorSamplers{
 		gaussianSampler: ring.NewGaussianSampler(prng, params.RingQ(), params.Sigma(), int(6*params.Sigma())),
 		ternarySampler:  ring.NewTernarySamplerWithHammingWeight(prng, params.ringQ, params.h, false),
@@ -170,9 +175,14 @@ func (enc *skEncryptor) ShallowCopy() Encryptor {
 
 // ShallowCopy creates a shallow copy of this encryptor in which all the read-only data-structures are
 // shared with the receiver and the temporary buffers are reallocated. The receiver and the returned
-// Encryptors can be used concurrently.
+// Encryptors can be used concurrently. The copy samples from a new random PRNG.
 func (enc *encryptor) ShallowCopy() *encryptor {
 
+	prng, err := utils.NewPRNG()
+	if err != nil {
+		panic(err)
+	}
+
 	var bc *ring.BasisExtender
 	if enc.params.PCount() != 0 {
 		bc = enc.basisextender.ShallowCopy()
@@ -180,7 +190,7 @@ func (enc *encryptor) ShallowCopy() *encryptor {
 
 	return &encryptor{
 		encryptorBase:     enc.encryptorBase,
-		encryptorSamplers: newEncryptorSamplers(enc.params),
+		encryptorSamplers: newEncryptorSamplers(enc.params, prng),
 		encryptorBuffers:  newEncryptorBuffers(enc.params),
 		basisextender:     bc,
 	}
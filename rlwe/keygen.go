@@ -41,12 +41,14 @@ type KeyGenerator interface {
 	GenSwitchingKeyForRowRotation(sk *SecretKey) (swk *SwitchingKey)
 	GenRotationKeysForInnerSum(sk *SecretKey) (rks *RotationKeySet)
 	GenSwitchingKeysForRingSwap(skCKKS, skCI *SecretKey) (swkStdToConjugateInvariant, swkConjugateInvariantToStd *SwitchingKey)
+	Reseed(prng utils.PRNG)
 }
 
 // KeyGenerator is a structure that stores the elements required to create new keys,
 // as well as a small memory pool for intermediate values.
 type keyGenerator struct {
 	params           Parameters
+	prng             utils.PRNG
 	poolQ            *ring.Poly
 	poolQP           PolyQP
 	ternarySampler   *ring.TernarySampler
@@ -64,21 +66,41 @@ func NewKeyGenerator(params Parameters) KeyGenerator {
 		panic(err)
 	}
 
+	return NewKeyGeneratorWithPRNG(params, prng)
+}
+
+// NewKeyGeneratorWithPRNG creates a new KeyGenerator whose samplers all read from the provided PRNG.
+func NewKeyGeneratorWithPRNG(params Parameters, prng utils.PRNG) KeyGenerator {
+
 	var poolQP PolyQP
-	var uniformSamplerP *ring.UniformSampler
 	if params.PCount() > 0 {
 		poolQP = params.RingQP().NewPoly()
-		uniformSamplerP = ring.NewUniformSampler(prng, params.RingP())
 	}
 
-	return &keyGenerator{
-		params:           params,
-		poolQ:            params.RingQ().NewPoly(),
-		poolQP:           poolQP,
-		ternarySampler:   ring.NewTernarySamplerWithHammingWeight(prng, params.ringQ, params.h, false),
-		gaussianSamplerQ: ring.NewGaussianSampler(prng, params.RingQ(), params.Sigma(), int(6*params.Sigma())),
-		uniformSamplerQ:  ring.NewUniformSampler(prng, params.RingQ()),
-		uniformSamplerP:  uniformSamplerP,
+	keygen := &keyGenerator{
+		params: params,
+		poolQ:  params.RingQ().NewPoly(),
+		poolQP: poolQP,
+	}
+
+	keygen.Reseed(prng)
+
+	return keygen
+}
+
+// Reseed sets prng as the source of randomness of the KeyGenerator. The samplers are recreated and keep no
+// randomness drawn from the previous source, so that the keys generated after Reseed only depend on the state of prng.
+func (keygen *keyGenerator) Reseed(prng utils.PRNG) {
+
+	params := keygen.params
+
+	keygen.prng = prng
+	keygen.ternarySampler = ring.NewTernarySamplerWithHammingWeight(prng, params.ringQ, params.h, false)
+	keygen.gaussianSamplerQ = ring.NewGaussianSampler(prng, params.RingQ(), params.Sigma(), int(6*params.Sigma()))
+	keygen.uniformSamplerQ = ring.NewUniformSampler(prng, params.RingQ())
+
+	if params.PCount() > 0 {
+		keygen.uniformSamplerP = ring.NewUniformSampler(prng, params.RingP())
 	}
 }
 
@@ -94,21 +116,13 @@ func (keygen *keyGenerator) GenSecretKeyGaussian() (sk *SecretKey) {
 
 // GenSecretKeyWithDistrib generates a new SecretKey with the distribution [(p-1)/2, p, (p-1)/2].
 func (keygen *keyGenerator) GenSecretKeyWithDistrib(p float64) (sk *SecretKey) {
-	prng, err := utils.NewPRNG()
-	if err != nil {
-		panic(err)
-	}
-	ternarySamplerMontgomery := ring.NewTernarySampler(prng, keygen.params.RingQ(), p, false)
+	ternarySamplerMontgomery := ring.NewTernarySampler(keygen.prng, keygen.params.RingQ(), p, false)
 	return keygen.genSecretKeyFromSampler(ternarySamplerMontgomery)
 }
 
 // GenSecretKeyWithHammingWeight generates a new SecretKey with exactly hw non-zero coefficients.
 func (keygen *keyGenerator) GenSecretKeyWithHammingWeight(hw int) (sk *SecretKey) {
-	prng, err := utils.NewPRNG()
-	if err != nil {
-		panic(err)
-	}
-	ternarySamplerMontgomery := ring.NewTernarySamplerWithHammingWeight(prng, keygen.params.RingQ(), hw, false)
+	ternarySamplerMontgomery := ring.NewTernarySamplerWithHammingWeight(keygen.prng, keygen.params.RingQ(), hw, false)
 	return keygen.genSecretKeyFromSampler(ternarySamplerMontgomery)
 }
 
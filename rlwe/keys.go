@@ -93,6 +93,39 @@ func (rtks *RotationKeySet) GetRotationKey(galoisEl uint64) (*SwitchingKey, bool
 	return rotKey, inSet
 }
 
+// GetRelinearizationKey returns the switching key used to relinearize degree two ciphertexts and
+// whether it is available. It implements EvaluationKeySet.
+func (evk EvaluationKey) GetRelinearizationKey() (KeySwitchingKey, bool) {
+	if evk.Rlk == nil || len(evk.Rlk.Keys) == 0 {
+		return nil, false
+	}
+	return evk.Rlk.Keys[0], true
+}
+
+// GetGaloisKey returns the switching key of the automorphism defined by galEl and whether it is
+// available. It implements EvaluationKeySet.
+func (evk EvaluationKey) GetGaloisKey(galEl uint64) (KeySwitchingKey, bool) {
+	if evk.Rtks == nil {
+		return nil, false
+	}
+	if swk, inSet := evk.Rtks.GetRotationKey(galEl); inSet {
+		return swk, true
+	}
+	return nil, false
+}
+
+// GaloisElements returns the Galois elements of the rotation keys of the set. It implements EvaluationKeySet.
+func (evk EvaluationKey) GaloisElements() (galEls []uint64) {
+	if evk.Rtks == nil {
+		return nil
+	}
+	galEls = make([]uint64, 0, len(evk.Rtks.Keys))
+	for galEl := range evk.Rtks.Keys {
+		galEls = append(galEls, galEl)
+	}
+	return
+}
+
 // NewSwitchingKey returns a new public switching key with pre-allocated zero-value
 func NewSwitchingKey(params Parameters, levelQ, levelP int) *SwitchingKey {
 	decompSize := int(math.Ceil(float64(levelQ+1) / float64(levelP+1)))
@@ -106,6 +139,11 @@ func NewSwitchingKey(params Parameters, levelQ, levelP int) *SwitchingKey {
 	return swk
 }
 
+// LevelQ returns the level of the modulus Q of the target SwitchingKey.
+func (swk *SwitchingKey) LevelQ() int {
+	return swk.Value[0][0].Q.Level()
+}
+
 // NewRelinKey creates a new EvaluationKey with zero values.
 func NewRelinKey(params Parameters, maxRelinDegree int) (evakey *RelinearizationKey) {
 
@@ -297,3 +297,96 @@ func (ks *KeySwitcher) KeyswitchHoistedNoModDown(levelQ int, PoolDecompQP []Poly
 		ringP.ReduceLvl(levelP, c1QP.P, c1QP.P)
 	}
 }
+
+// KeySwitchingKey is the interface implemented by the switching keys of the key-switching backends.
+type KeySwitchingKey interface {
+	// LevelQ returns the maximum level of the ciphertexts the key can switch.
+	LevelQ() int
+}
+
+// EvaluationKeySet is the interface of the sets of keys an evaluator uses for the relinearization
+// and for the automorphisms. The returned keys are of the type expected by the KeySwitchingBackend
+// of the evaluator.
+type EvaluationKeySet interface {
+	GetRelinearizationKey() (swk KeySwitchingKey, ok bool)
+	GetGaloisKey(galEl uint64) (swk KeySwitchingKey, ok bool)
+	GaloisElements() (galEls []uint64)
+}
+
+// Decomposition stores the gadget decomposition of a polynomial, which can be re-used by several
+// hoisted key-switches. QP is used by KeySwitcher, and T by the backends decomposing in an
+// auxiliary modulus T.
+type Decomposition struct {
+	QP []PolyQP
+	T  []*ring.Poly
+}
+
+// KeySwitchingBackend is the interface of the key-switching methods. It is implemented by KeySwitcher,
+// which uses the hybrid key-switching, and by frlwe.KeySwitcher, which uses the key-switching over an
+// auxiliary modulus T. The keys given to a backend must be of the type of the backend.
+type KeySwitchingBackend interface {
+	// Switch computes [c0, c1] = [cx*swk[0], cx*swk[1]] mod Q.
+	// The result is returned in the same domain as cx.
+	Switch(levelQ int, cx *ring.Poly, swk KeySwitchingKey, c0, c1 *ring.Poly)
+
+	// SwitchNoModDown computes [c0, c1] = [P*cx*swk[0], P*cx*swk[1]] mod QP.
+	// The result is returned in the NTT domain.
+	SwitchNoModDown(levelQ int, cx *ring.Poly, swk KeySwitchingKey, c0Q, c0P, c1Q, c1P *ring.Poly)
+
+	// NewDecomposition allocates a Decomposition for the target backend.
+	NewDecomposition() *Decomposition
+
+	// Decompose computes the gadget decomposition of cx on decomp.
+	// Expects the flag IsNTT of cx to correctly reflect the domain of cx.
+	Decompose(levelQ int, cx *ring.Poly, decomp *Decomposition)
+
+	// SwitchHoisted computes [c0Q, c1Q] = [cx*swk[0], cx*swk[1]] mod Q from the decomposition of cx.
+	// The result is returned in the NTT domain and c0P, c1P are used as buffers.
+	SwitchHoisted(levelQ int, decomp *Decomposition, swk KeySwitchingKey, c0Q, c1Q, c0P, c1P *ring.Poly)
+
+	// SwitchHoistedNoModDown computes [c0, c1] = [P*cx*swk[0], P*cx*swk[1]] mod QP from the decomposition
+	// of cx. The result is returned in the NTT domain.
+	SwitchHoistedNoModDown(levelQ int, decomp *Decomposition, swk KeySwitchingKey, c0Q, c1Q, c0P, c1P *ring.Poly)
+}
+
+func (ks *KeySwitcher) switchingKey(swk KeySwitchingKey) *SwitchingKey {
+	if swk, ok := swk.(*SwitchingKey); ok {
+		return swk
+	}
+	panic("cannot switch keys: key is not a *rlwe.SwitchingKey")
+}
+
+// Switch implements KeySwitchingBackend with SwitchKeysInPlace.
+func (ks *KeySwitcher) Switch(levelQ int, cx *ring.Poly, swk KeySwitchingKey, c0, c1 *ring.Poly) {
+	ks.SwitchKeysInPlace(levelQ, cx, ks.switchingKey(swk), c0, c1)
+}
+
+// SwitchNoModDown implements KeySwitchingBackend with SwitchKeysInPlaceNoModDown.
+func (ks *KeySwitcher) SwitchNoModDown(levelQ int, cx *ring.Poly, swk KeySwitchingKey, c0Q, c0P, c1Q, c1P *ring.Poly) {
+	ks.SwitchKeysInPlaceNoModDown(levelQ, cx, ks.switchingKey(swk), c0Q, c0P, c1Q, c1P)
+}
+
+// NewDecomposition allocates a Decomposition holding Beta() polynomials mod QP.
+func (ks *KeySwitcher) NewDecomposition() *Decomposition {
+	ringQP := ks.RingQP()
+	decomp := &Decomposition{QP: make([]PolyQP, ks.Beta())}
+	for i := range decomp.QP {
+		decomp.QP[i] = ringQP.NewPoly()
+	}
+	return decomp
+}
+
+// Decompose implements KeySwitchingBackend with DecomposeNTT.
+func (ks *KeySwitcher) Decompose(levelQ int, cx *ring.Poly, decomp *Decomposition) {
+	ks.DecomposeNTT(levelQ, ks.PCount()-1, ks.PCount(), cx, decomp.QP)
+}
+
+// SwitchHoisted implements KeySwitchingBackend with KeyswitchHoisted.
+func (ks *KeySwitcher) SwitchHoisted(levelQ int, decomp *Decomposition, swk KeySwitchingKey, c0Q, c1Q, c0P, c1P *ring.Poly) {
+	ks.KeyswitchHoisted(levelQ, decomp.QP, ks.switchingKey(swk), c0Q, c1Q, c0P, c1P)
+}
+
+// SwitchHoistedNoModDown implements KeySwitchingBackend with KeyswitchHoistedNoModDown.
+func (ks *KeySwitcher) SwitchHoistedNoModDown(levelQ int, decomp *Decomposition, swk KeySwitchingKey, c0Q, c1Q, c0P, c1P *ring.Poly) {
+	ks.KeyswitchHoistedNoModDown(levelQ, decomp.QP, ks.switchingKey(swk), c0Q, c1Q, c0P, c1P)
+}